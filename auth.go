@@ -2,76 +2,218 @@ package gopherb2
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/spf13/viper"
 	"github.com/uber-go/zap"
 )
 
+// DefaultAPIURL is used for authorization when no API URL is configured. Configured API URLs
+// including an API version path, such as "https://api.backblazeb2.com/b2api/v1/", are also accepted.
+const DefaultAPIURL = "https://api.backblazeb2.com"
+
 // APIAuthorization.Minimum Part Size deprecated and will match recommended part size
 type APIAuthorization struct {
-	AccountID           string `json:"accountId"`
-	ApiURL              string `json:"apiUrl"`
-	AuthorizationToken  string `json:"authorizationToken"`
-	DownloadURL         string `json:"downloadURL"`
-	MinimumPartSize     int    `json:"minimumPartSize"`
-	RecommendedPartSize int    `json:"recommendedPartSize"`
-	AbsoluteMinPartSize int    `json:"absoluteMinimumPartSize"`
+	AccountID           string  `json:"accountId"`
+	ApiURL              string  `json:"apiUrl"`
+	AuthorizationToken  string  `json:"authorizationToken"`
+	DownloadURL         string  `json:"downloadUrl"`
+	S3ApiURL            string  `json:"s3ApiUrl"`
+	MinimumPartSize     int     `json:"minimumPartSize"`
+	RecommendedPartSize int     `json:"recommendedPartSize"`
+	AbsoluteMinPartSize int     `json:"absoluteMinimumPartSize"`
+	Allowed             Allowed `json:"allowed"`
+}
+
+// Allowed holds the restrictions of the application key used for authorization
+type Allowed struct {
+	Capabilities []string `json:"capabilities"`
+	// BucketID and BucketName are set if the key is restricted to one bucket
+	BucketID   string `json:"bucketId"`
+	BucketName string `json:"bucketName"`
+	// Buckets lists the buckets the key is restricted to, API version 3 allows more than one
+	Buckets []AllowedBucket `json:"buckets"`
+	// NamePrefix is set if the key is restricted to files with names starting with it
+	NamePrefix string `json:"namePrefix"`
+}
+
+// AllowedBucket is a bucket an application key is restricted to
+type AllowedBucket struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// authorizationV3 is the b2_authorize_account response of API version 3, which nests the
+// storage API settings in apiInfo
+type authorizationV3 struct {
+	AccountID          string `json:"accountId"`
+	AuthorizationToken string `json:"authorizationToken"`
+	APIInfo            struct {
+		StorageAPI struct {
+			APIURL                  string  `json:"apiUrl"`
+			DownloadURL             string  `json:"downloadUrl"`
+			S3ApiURL                string  `json:"s3ApiUrl"`
+			RecommendedPartSize     int     `json:"recommendedPartSize"`
+			AbsoluteMinimumPartSize int     `json:"absoluteMinimumPartSize"`
+			Allowed                 Allowed `json:"allowed"`
+		} `json:"storageApi"`
+	} `json:"apiInfo"`
+}
+
+// AllowsBucket reports whether the key may access the bucket bucketID
+func (a Allowed) AllowsBucket(bucketID string) bool {
+	if len(a.Buckets) == 0 {
+		return true
+	}
+	for _, b := range a.Buckets {
+		if b.ID == bucketID {
+			return true
+		}
+	}
+	return false
+}
+
+// HasCapability reports whether the key may use capability, such as "listBuckets". Authorizations
+// without an allowed block, as returned for account master keys by older API versions, allow everything.
+func (a Allowed) HasCapability(capability string) bool {
+	if a.Capabilities == nil {
+		return true
+	}
+	for _, c := range a.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultProfile is the profile used when neither settings.toml nor the B2Profile environment
+// variable select a default profile
+const DefaultProfile = "Account1"
+
+// LoadConfiguration returns the credentials of the default profile, see LoadProfile
+func LoadConfiguration() (Configuration, error) {
+	return LoadProfile("")
+}
+
+// LoadProfile reads the credentials of the named profile from settings.toml in "/config", where
+// every TOML section is a profile. If name is empty the profile named by the B2Profile environment
+// variable, or else the DefaultProfile setting of settings.toml, is used.
+//
+// The environment variables B2AcctID_<PROFILE>, B2AppID_<PROFILE> and B2APIURL_<PROFILE>, with the
+// profile name in upper case, override the settings of that profile. B2AcctID, B2AppID and B2APIURL
+// are used for the default profile when it does not set them.
+func LoadProfile(name string) (Configuration, error) {
+	v := viper.New()
+	v.SetConfigName("settings")                                    // no need to include file extension
+	v.AddConfigPath("$GOPATH/src/github.com/dwin/gopherb2/config") // set the path of your config file
+	v.AddConfigPath("config")                                      // set the path of your config file
+	if err := v.ReadInConfig(); err != nil {
+		logger.Debug("No Configuration file found. Checking ENV.")
+	}
+	return loadProfile(v, name)
+}
+
+// Profiles returns the names of all profiles in settings.toml
+func Profiles() ([]string, error) {
+	v := viper.New()
+	v.SetConfigName("settings")
+	v.AddConfigPath("$GOPATH/src/github.com/dwin/gopherb2/config")
+	v.AddConfigPath("config")
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	return profiles(v), nil
+}
+
+// profiles returns the sorted names of all sections in the configuration read by v
+func profiles(v *viper.Viper) []string {
+	var names []string
+	for key, value := range v.AllSettings() {
+		if _, ok := value.(map[string]interface{}); ok {
+			names = append(names, key)
+		}
+	}
+	sort.Strings(names)
+	return names
 }
 
-// Calling this function reads settings.toml file in "/config" , calls B2 API , then returns the response as APIAuthorization struct
-func AuthorizeAcct() APIAuthorization {
+// loadProfile returns the credentials of the named profile from the configuration read by v
+func loadProfile(v *viper.Viper, name string) (Configuration, error) {
 	var Config Configuration
-	viper.SetConfigName("settings")                                    // no need to include file extension
-	viper.AddConfigPath("$GOPATH/src/github.com/dwin/gopherb2/config") // set the path of your config file
-	err := viper.ReadInConfig()
-	viper.AddConfigPath("config") // set the path of your config file
-	err = viper.ReadInConfig()
-	if err != nil {
-		logger.Debug("No Configuration file found, Cannot Attempt Authorization with API. Checking ENV.")
-		Config.AcctID = os.Getenv("B2AcctID")
-		Config.AppID = os.Getenv("B2AppID")
-		Config.APIURL = os.Getenv("B2APIURL")
-	} else {
-		Config.AcctID = viper.GetString("Account1.AcctID")
-		logger.Debug("Obtained Account ID from Configuration file",
-			zap.String("Account ID:", Config.AcctID),
-		)
-		Config.AppID = viper.GetString("Account1.AppID")
-		logger.Debug("Obtained Account ID from Configuration file",
-			zap.String("Application ID:", Config.AppID),
-		)
-		Config.APIURL = viper.GetString("Account1.APIURL")
-		logger.Debug("Obtained Account ID from Configuration file",
-			zap.String("API URL:", Config.APIURL),
-		)
+	defaultProfile := os.Getenv("B2Profile")
+	if defaultProfile == "" {
+		defaultProfile = v.GetString("DefaultProfile")
+	}
+	if defaultProfile == "" {
+		defaultProfile = DefaultProfile
+	}
+	if name == "" {
+		name = defaultProfile
+	}
+	isDefault := strings.EqualFold(name, defaultProfile)
+
+	// Environment variables of the profile take precedence over the configuration file
+	envSuffix := "_" + strings.ToUpper(strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name))
+	setting := func(key string) string {
+		if value := os.Getenv("B2" + key + envSuffix); value != "" {
+			return value
+		}
+		if value := v.GetString(name + "." + key); value != "" {
+			return value
+		}
+		if isDefault {
+			return os.Getenv("B2" + key)
+		}
+		return ""
+	}
+	Config.AcctID = setting("AcctID")
+	Config.AppID = setting("AppID")
+	Config.APIURL = setting("APIURL")
+	Config.APIVersion = setting("APIVersion")
+	logger.Debug("Loaded B2 credentials profile",
+		zap.String("Profile", name),
+		zap.String("Account ID", Config.AcctID),
+		zap.String("API URL", Config.APIURL),
+	)
+
+	if Config.AcctID == "" && Config.AppID == "" && !v.IsSet(name) && !isDefault {
+		return Config, fmt.Errorf("Profile %q not found in settings.toml or environment.", name)
 	}
 	if Config.AcctID == "" {
-		logger.Fatal("Account ID set to default. Update with your Account Id from Backblaze Settings.")
+		return Config, fmt.Errorf("Account ID of profile %q not set. Update with your Account Id from Backblaze Settings.", name)
 	} else if Config.AppID == "" {
-		logger.Fatal("Application ID set to default. Update with your Application Id from Backblaze Settings.")
+		return Config, fmt.Errorf("Application ID of profile %q not set. Update with your Application Id from Backblaze Settings.", name)
 	}
+	return Config, nil
+}
+
+// authorizeAccount calls B2 API with the client credentials, then returns the response as APIAuthorization struct
+func (c *Client) authorizeAccount(ctx context.Context) (APIAuthorization, error) {
+	var apiAuth APIAuthorization
 	// Encode credentials to base64
-	credentials := base64.StdEncoding.EncodeToString([]byte(Config.AcctID + ":" + Config.AppID))
+	credentials := base64.StdEncoding.EncodeToString([]byte(c.config.AcctID + ":" + c.config.AppID))
 
-	// Request (POST https://api.backblazeb2.com/b2api/v1/b2_authorize_account)
+	// Request (POST https://api.backblazeb2.com/b2api/v3/b2_authorize_account)
 	body := bytes.NewBuffer([]byte(`{}`))
-	logger.Debug("Preparing to send API Auth Request")
-
-	// Create client
-	client := &http.Client{}
+	c.Logger.Debug("Preparing to send API Auth Request")
 
 	// Create request
-	req, err := http.NewRequest("POST", Config.APIURL+"b2_authorize_account", body)
+	req, err := http.NewRequestWithContext(ctx, "POST", c.authorizeEndpoint(), body)
 	if err != nil {
-		logger.Fatal("Creating API Auth Request Failed.",
-			zap.Error(err),
-		)
+		return apiAuth, err
 	}
 
 	// Headers
@@ -79,189 +221,159 @@ func AuthorizeAcct() APIAuthorization {
 	req.Header.Add("Content-Type", "application/json; charset=utf-8")
 
 	// Fetch Request
-	resp, err := client.Do(req)
-
+	apiResponse, err := c.do(req)
 	if err != nil {
-		logger.Fatal("API Auth Request Failed.",
+		c.Logger.Warn("Authorization with Backblaze B2 API Failed",
 			zap.Error(err),
 		)
+		return apiAuth, err
 	}
-	logger.Debug("Received API Authorization response.")
-
-	var apiAuth APIAuthorization
-
-	// Read Response Body
-	respBody, _ := ioutil.ReadAll(resp.Body)
-	defer resp.Body.Close()
-	err = json.Unmarshal(respBody, &apiAuth)
+	c.Logger.Debug("Received API Authorization response.")
 
+	apiAuth, err = decodeAuthorization(c.config.APIVersion, apiResponse.Body)
 	if err != nil {
-		fmt.Println("API Auth JSON Parse Failed", err)
-		logger.Fatal("Cannot parse API Auth Response JSON.",
-			zap.Error(err),
-		)
-	}
-
-	if resp.Status != "200 OK" {
-		logger.Fatal("Authorization with Backblaze B2 API Failed",
-			zap.String("API Resp Body:", string(respBody)),
-		)
+		return apiAuth, err
 	}
 
-	// Check API Response matches config
-	if apiAuth.AccountID != Config.AcctID {
-		logger.Fatal("API Account ID Response does not match Account ID in Config.",
+	// Check API Response matches config, application keys other than the master key have their own ID
+	if apiAuth.AccountID != c.config.AcctID && apiAuth.Allowed.Capabilities == nil {
+		c.Logger.Warn("API Account ID Response does not match Account ID in Config.",
 			zap.String("API Resp Acct ID", apiAuth.AccountID),
-			zap.String("Config Acct ID", Config.AcctID),
+			zap.String("Config Acct ID", c.config.AcctID),
 		)
 	}
+	c.Logger.Info("Authorized with Backblaze B2 API",
+		zap.String("Account ID", apiAuth.AccountID),
+	)
 
-	return apiAuth
+	return apiAuth, nil
 }
 
-// Requests Upload URL from API and returns 'UploadURL'
-func B2GetUploadURL(bucketId string) UploadURL {
-	// Authorize and Get API Token
-	authorizationResponse := AuthorizeAcct()
-
-	// Get Upload URL (POST https://api001.backblazeb2.com/b2api/v1/b2_get_upload_url)
-
-	jsonData := []byte(`{"bucketId": "` + bucketId + `"}`)
-	body := bytes.NewBuffer(jsonData)
-
-	// Create client
-	client := &http.Client{}
-
-	// Create request
-	req, err := http.NewRequest("POST", authorizationResponse.ApiURL+"/b2api/v1/b2_get_upload_url", body)
-
-	// Headers
-	req.Header.Add("Authorization", authorizationResponse.AuthorizationToken)
-	req.Header.Add("Content-Type", "application/json; charset=utf-8")
-
-	logger.Debug("Preparing to send Get Upload URL request.")
-
-	// Fetch Request
-	resp, err := client.Do(req)
-
-	if err != nil {
-		fmt.Println("Failure : ", err)
+// decodeAuthorization decodes the b2_authorize_account response body of API version, flattening
+// the nested layout of version 3 and the single bucket restriction of earlier versions alike
+func decodeAuthorization(version string, body []byte) (APIAuthorization, error) {
+	var apiAuth APIAuthorization
+	if version != APIv3 {
+		if err := json.Unmarshal(body, &apiAuth); err != nil {
+			return apiAuth, err
+		}
+		if apiAuth.Allowed.BucketID != "" {
+			apiAuth.Allowed.Buckets = []AllowedBucket{{ID: apiAuth.Allowed.BucketID, Name: apiAuth.Allowed.BucketName}}
+		}
+		return apiAuth, nil
 	}
+	var v3 authorizationV3
+	if err := json.Unmarshal(body, &v3); err != nil {
+		return apiAuth, err
+	}
+	storage := v3.APIInfo.StorageAPI
+	apiAuth = APIAuthorization{
+		AccountID:           v3.AccountID,
+		ApiURL:              storage.APIURL,
+		AuthorizationToken:  v3.AuthorizationToken,
+		DownloadURL:         storage.DownloadURL,
+		S3ApiURL:            storage.S3ApiURL,
+		MinimumPartSize:     storage.RecommendedPartSize,
+		RecommendedPartSize: storage.RecommendedPartSize,
+		AbsoluteMinPartSize: storage.AbsoluteMinimumPartSize,
+		Allowed:             storage.Allowed,
+	}
+	if len(apiAuth.Allowed.Buckets) == 1 {
+		apiAuth.Allowed.BucketID = apiAuth.Allowed.Buckets[0].ID
+		apiAuth.Allowed.BucketName = apiAuth.Allowed.Buckets[0].Name
+	}
+	return apiAuth, nil
+}
 
-	// Read Response Body
-	respBody, _ := ioutil.ReadAll(resp.Body)
-	defer resp.Body.Close()
-
-	var apiResponse Response
-	apiResponse = Response{Header: resp.Header, Status: resp.Status, Body: respBody}
+// GetUploadURL requests Upload URL from API and returns 'UploadURL'
+func (c *Client) GetUploadURL(bucketId string) (UploadURL, error) {
+	return c.GetUploadURLContext(context.Background(), bucketId)
+}
 
+// GetUploadURLContext is like GetUploadURL with a context limiting the request
+func (c *Client) GetUploadURLContext(ctx context.Context, bucketId string) (UploadURL, error) {
 	var uploadURL UploadURL
+	bucketId, err := c.ResolveBucketContext(ctx, bucketId)
+	if err != nil {
+		return uploadURL, err
+	}
+	c.Logger.Debug("Preparing to send Get Upload URL request.")
 
-	err = json.Unmarshal(apiResponse.Body, &uploadURL)
+	// Get Upload URL (POST https://api001.backblazeb2.com/b2api/v1/b2_get_upload_url)
+	err = c.apiCall(ctx, "b2_get_upload_url", map[string]string{"bucketId": bucketId}, &uploadURL)
 	if err != nil {
-		logger.Fatal("Bucket JSON Parse Failed",
+		c.Logger.Warn("Get Upload URL Request Failed",
+			zap.String("Bucket ID", bucketId),
 			zap.Error(err),
 		)
+		return uploadURL, err
 	}
-	logger.Debug("Get Upload URL response received from API")
+	c.Logger.Debug("Get Upload URL response received from API")
 
-	return uploadURL
+	return uploadURL, nil
 }
 
-func B2FinishLargeFile(largeFile LargeFile) error {
-	apiAuth := AuthorizeAcct()
+// FinishLargeFile sends the SHA1 of every uploaded part of largeFile to B2 to complete the upload,
+// returning the finished file
+func (c *Client) FinishLargeFile(largeFile LargeFile) (File, error) {
+	return c.FinishLargeFileContext(context.Background(), largeFile)
+}
 
+// FinishLargeFileContext is like FinishLargeFile with a context limiting the request
+func (c *Client) FinishLargeFileContext(ctx context.Context, largeFile LargeFile) (File, error) {
 	// Create SHA1 array of completed files
-	var partSha1Array string
-	var buffer bytes.Buffer
+	partSha1Array := make([]string, len(largeFile.Temp))
 	for i := 0; i < len(largeFile.Temp); i++ {
-		buffer.WriteString("\u0022") // Add double quotation mark --> "
-		buffer.WriteString(largeFile.Temp[i].SHA1)
-		buffer.WriteString("\u0022")
-		if i != len(largeFile.Temp)-1 {
-			buffer.WriteString(",") // Only add trailing comma if item is not last
-		}
+		partSha1Array[i] = largeFile.Temp[i].SHA1
 	}
-	partSha1Array = buffer.String()
-
-	// Create client
-	client := &http.Client{}
-	// Request Body : JSON object with fileID & array of SHA1 hashes of files transmitted
-	jsonBody := []byte(`{"fileId": "` + largeFile.FileID + `", "partSha1Array":[` + partSha1Array + `]}`)
-	body := bytes.NewBuffer(jsonBody)
-
-	// Create request
-	req, err := http.NewRequest("POST", apiAuth.ApiURL+"/b2api/v1/b2_finish_large_file", body)
-
-	// Headers
-	req.Header.Add("Authorization", apiAuth.AuthorizationToken)
-
-	// Fetch Request
-	resp, err := client.Do(req)
-
+	finished, err := c.finishLargeFile(ctx, largeFile.FileID, partSha1Array)
 	if err != nil {
-		fmt.Println("Failure : ", err)
+		return finished, err
 	}
+	c.Logger.Info("Finish Large File Upload Completed",
+		zap.String("Filepath", largeFile.OrigPath),
+		zap.String("B2 File ID", largeFile.FileID),
+	)
 
-	// Read Response Body
-	respBody, _ := ioutil.ReadAll(resp.Body)
-	defer resp.Body.Close()
-
-	if resp.Status != "200 OK" {
-		logger.Warn("Finish B2 Large File Failed",
-			zap.String("HTTP Status Response", resp.Status),
-			zap.String("HTTP Resp Body", string(respBody)),
-		)
-	}
-	if resp.Status == "200 OK" {
-		logger.Info("Finish Large File Upload Completed",
-			zap.String("Filepath", largeFile.OrigPath),
-			zap.String("B2 File ID", largeFile.FileID),
-		)
-	}
-
-	return err
+	return finished, nil
 }
 
-func B2GetUploadPartURL(fileId string) UploadPartResponse {
-	apiAuth := AuthorizeAcct()
-
-	// Create client
-	client := &http.Client{}
-	// Request Body : JSON object with fileId
-	jsonBody := []byte(`{"fileId": "` + fileId + `"}`)
-	body := bytes.NewBuffer(jsonBody)
-
-	// Create request
-	req, err := http.NewRequest("POST", apiAuth.ApiURL+"/b2api/v1/b2_get_upload_part_url", body)
-
-	// Headers
-	req.Header.Add("Authorization", apiAuth.AuthorizationToken)
-
-	// Fetch Request
-	resp, err := client.Do(req)
-
+// finishLargeFile completes the large file fileID from the parts with the SHA1 hashes in partSha1Array
+func (c *Client) finishLargeFile(ctx context.Context, fileID string, partSha1Array []string) (File, error) {
+	var b2File File
+	// Request Body : JSON object with fileID & array of SHA1 hashes of files transmitted
+	reqBody := map[string]interface{}{
+		"fileId":        fileID,
+		"partSha1Array": partSha1Array,
+	}
+	err := c.apiCall(ctx, "b2_finish_large_file", reqBody, &b2File)
 	if err != nil {
-		logger.Fatal("Error requesting Part Upload URL",
+		c.Logger.Warn("Finish B2 Large File Failed",
+			zap.String("B2 File ID", fileID),
 			zap.Error(err),
 		)
 	}
+	return b2File, err
+}
 
-	// Read Response Body
-	respBody, _ := ioutil.ReadAll(resp.Body)
-	defer resp.Body.Close()
+// GetUploadPartURL requests an URL and authorization token to upload parts of the large file fileId
+func (c *Client) GetUploadPartURL(fileId string) (UploadPartResponse, error) {
+	return c.GetUploadPartURLContext(context.Background(), fileId)
+}
 
+// GetUploadPartURLContext is like GetUploadPartURL with a context limiting the request
+func (c *Client) GetUploadPartURLContext(ctx context.Context, fileId string) (UploadPartResponse, error) {
 	var uploadPartResponse UploadPartResponse
-	if resp.Status != "200 OK" {
-		logger.Fatal("Could not obtain Part Upload URL", zap.String("Response", string(respBody)))
-	} else if resp.Status == "200 OK" {
-		err = json.Unmarshal(respBody, &uploadPartResponse)
-		if err != nil {
-			logger.Fatal("Upload Part Response JSON Parse Failed", zap.Error(err))
-		}
-		logger.Info("Obtained Upload Part URL",
-			zap.String("B2 File ID", uploadPartResponse.FileID),
+	err := c.apiCall(ctx, "b2_get_upload_part_url", map[string]string{"fileId": fileId}, &uploadPartResponse)
+	if err != nil {
+		c.Logger.Warn("Could not obtain Part Upload URL",
+			zap.String("B2 File ID", fileId),
+			zap.Error(err),
 		)
+		return uploadPartResponse, err
 	}
-	return uploadPartResponse
+	c.Logger.Info("Obtained Upload Part URL",
+		zap.String("B2 File ID", uploadPartResponse.FileID),
+	)
+	return uploadPartResponse, nil
 }
@@ -0,0 +1,216 @@
+package b2test
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type bucket struct {
+	AccountID      string            `json:"accountId"`
+	BucketID       string            `json:"bucketId"`
+	BucketName     string            `json:"bucketName"`
+	BucketType     string            `json:"bucketType"`
+	BucketInfo     map[string]string `json:"bucketInfo"`
+	LifecycleRules []lifecycleRule   `json:"lifecycleRules"`
+	CORSRules      []corsRule        `json:"corsRules"`
+	Revision       int               `json:"revision"`
+}
+
+func (s *Server) createBucket(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AccountID      string            `json:"accountId"`
+		BucketName     string            `json:"bucketName"`
+		BucketType     string            `json:"bucketType"`
+		BucketInfo     map[string]string `json:"bucketInfo"`
+		LifecycleRules []lifecycleRule   `json:"lifecycleRules"`
+		CORSRules      []corsRule        `json:"corsRules"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if req.AccountID != s.AccountID {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "accountId does not match authorization")
+		return
+	}
+	if !checkLifecycleRules(w, req.LifecycleRules) {
+		return
+	}
+	if !checkBucketInfo(w, req.BucketInfo) {
+		return
+	}
+	if req.BucketInfo == nil {
+		req.BucketInfo = map[string]string{}
+	}
+	if req.LifecycleRules == nil {
+		req.LifecycleRules = []lifecycleRule{}
+	}
+	if req.CORSRules == nil {
+		req.CORSRules = []corsRule{}
+	}
+	if len(req.BucketName) < 6 || len(req.BucketName) > 50 || strings.HasPrefix(req.BucketName, "b2-") || strings.Trim(req.BucketName, bucketNameChars) != "" {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid bucketName "+req.BucketName)
+		return
+	}
+	if req.BucketType != "allPrivate" && req.BucketType != "allPublic" {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid bucketType "+req.BucketType)
+		return
+	}
+	for _, b := range s.buckets {
+		if b.BucketName == req.BucketName {
+			writeError(w, http.StatusBadRequest, "duplicate_bucket_name", "Bucket name is already in use.")
+			return
+		}
+	}
+	b := &bucket{
+		AccountID:      s.AccountID,
+		BucketID:       s.newBucketID(),
+		BucketName:     req.BucketName,
+		BucketType:     req.BucketType,
+		BucketInfo:     req.BucketInfo,
+		LifecycleRules: req.LifecycleRules,
+		CORSRules:      req.CORSRules,
+		Revision:       1,
+	}
+	s.buckets[b.BucketID] = b
+	writeJSON(w, b)
+}
+
+func (s *Server) listBuckets(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AccountID  string `json:"accountId"`
+		BucketID   string `json:"bucketId"`
+		BucketName string `json:"bucketName"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	buckets := []*bucket{}
+	for _, b := range s.buckets {
+		if (req.BucketID == "" || req.BucketID == b.BucketID) && (req.BucketName == "" || req.BucketName == b.BucketName) {
+			buckets = append(buckets, b)
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].BucketName < buckets[j].BucketName })
+	writeJSON(w, map[string]interface{}{"buckets": buckets})
+}
+
+func (s *Server) updateBucket(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AccountID         string            `json:"accountId"`
+		BucketID          string            `json:"bucketId"`
+		BucketType        string            `json:"bucketType"`
+		BucketInfo        map[string]string `json:"bucketInfo"`
+		LifecycleRules    []lifecycleRule   `json:"lifecycleRules"`
+		CORSRules         []corsRule        `json:"corsRules"`
+		IfRevisionMatches int               `json:"ifRevisionMatches"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	b := s.buckets[req.BucketID]
+	if b == nil || req.AccountID != s.AccountID {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid bucketId: "+req.BucketID)
+		return
+	}
+	if req.IfRevisionMatches != 0 && req.IfRevisionMatches != b.Revision {
+		writeError(w, http.StatusConflict, "conflict", "ifRevisionMatches is "+strconv.Itoa(req.IfRevisionMatches)+", but bucket revision is "+strconv.Itoa(b.Revision))
+		return
+	}
+	switch req.BucketType {
+	case "":
+	case "allPrivate", "allPublic":
+		b.BucketType = req.BucketType
+	default:
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid bucketType "+req.BucketType)
+		return
+	}
+	if !checkLifecycleRules(w, req.LifecycleRules) || !checkBucketInfo(w, req.BucketInfo) {
+		return
+	}
+	if req.BucketInfo != nil {
+		b.BucketInfo = req.BucketInfo
+	}
+	if req.LifecycleRules != nil {
+		b.LifecycleRules = req.LifecycleRules
+	}
+	if req.CORSRules != nil {
+		b.CORSRules = req.CORSRules
+	}
+	b.Revision++
+	writeJSON(w, b)
+}
+
+func (s *Server) deleteBucket(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AccountID string `json:"accountId"`
+		BucketID  string `json:"bucketId"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	b := s.buckets[req.BucketID]
+	if b == nil || req.AccountID != s.AccountID {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid bucketId: "+req.BucketID)
+		return
+	}
+	for _, f := range s.files {
+		if f.BucketID == b.BucketID {
+			writeError(w, http.StatusBadRequest, "cannot_delete_non_empty_bucket", "Cannot delete non-empty bucket")
+			return
+		}
+	}
+	delete(s.buckets, b.BucketID)
+	writeJSON(w, b)
+}
+
+// bucketNameChars are the characters allowed in bucket names
+const bucketNameChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-"
+
+type lifecycleRule struct {
+	FileNamePrefix            string `json:"fileNamePrefix"`
+	DaysFromUploadingToHiding *int   `json:"daysFromUploadingToHiding"`
+	DaysFromHidingToDeleting  *int   `json:"daysFromHidingToDeleting"`
+}
+
+type corsRule struct {
+	CorsRuleName      string   `json:"corsRuleName"`
+	AllowedOrigins    []string `json:"allowedOrigins"`
+	AllowedOperations []string `json:"allowedOperations"`
+	AllowedHeaders    []string `json:"allowedHeaders"`
+	ExposeHeaders     []string `json:"exposeHeaders"`
+	MaxAgeSeconds     int      `json:"maxAgeSeconds"`
+}
+
+// checkLifecycleRules responds with 400 and returns false if any prefixes of rules overlap
+func checkLifecycleRules(w http.ResponseWriter, rules []lifecycleRule) bool {
+	for i := range rules {
+		for j := range rules {
+			if i != j && strings.HasPrefix(rules[i].FileNamePrefix, rules[j].FileNamePrefix) {
+				writeError(w, http.StatusBadRequest, "bad_request", "overlapping lifecycle rule prefixes")
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// checkBucketInfo responds with 400 and returns false if info has more than 10 entries
+func checkBucketInfo(w http.ResponseWriter, info map[string]string) bool {
+	if len(info) > 10 {
+		writeError(w, http.StatusBadRequest, "bad_request", "too many bucket info entries")
+		return false
+	}
+	return true
+}
+
+// bucketByName returns the bucket named name, or nil if there is none
+func (s *Server) bucketByName(name string) *bucket {
+	for _, b := range s.buckets {
+		if b.BucketName == name {
+			return b
+		}
+	}
+	return nil
+}
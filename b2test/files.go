@@ -0,0 +1,844 @@
+package b2test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type file struct {
+	AccountID       string            `json:"accountId"`
+	Action          string            `json:"action"`
+	BucketID        string            `json:"bucketId"`
+	ContentLength   int64             `json:"contentLength"`
+	ContentMd5      string            `json:"contentMd5,omitempty"`
+	ContentSha1     string            `json:"contentSha1"`
+	ContentType     string            `json:"contentType"`
+	FileID          string            `json:"fileId"`
+	FileInfo        map[string]string `json:"fileInfo"`
+	FileName        string            `json:"fileName"`
+	UploadTimestamp int64             `json:"uploadTimestamp"`
+
+	data  []byte
+	parts map[int]*part // Uploaded parts of an unfinished large file
+}
+
+type part struct {
+	sha1     string
+	data     []byte
+	uploaded int64
+}
+
+// folder returns the entry listed for a folder when listing with a delimiter
+func folder(name string) *file {
+	return &file{Action: "folder", FileName: name, FileInfo: map[string]string{}}
+}
+
+func (s *Server) getUploadURL(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		BucketID string `json:"bucketId"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if s.buckets[req.BucketID] == nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid bucketId: "+req.BucketID)
+		return
+	}
+	token := s.newID("upload")
+	s.uploadURLs[token] = s.URL + "/upload/" + req.BucketID + "/" + token
+	writeJSON(w, map[string]string{
+		"bucketId":           req.BucketID,
+		"uploadUrl":          s.uploadURLs[token],
+		"authorizationToken": token,
+	})
+}
+
+func (s *Server) uploadFile(w http.ResponseWriter, r *http.Request) {
+	bucketID := strings.Split(strings.TrimPrefix(r.URL.Path, "/upload/"), "/")[0]
+	b := s.buckets[bucketID]
+	if b == nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid bucketId: "+bucketID)
+		return
+	}
+	fileName, err := url.QueryUnescape(r.Header.Get("X-Bz-File-Name"))
+	if err != nil || fileName == "" {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid X-Bz-File-Name")
+		return
+	}
+	data, ok := readChecked(w, r)
+	if !ok {
+		return
+	}
+	f := &file{
+		AccountID:       s.AccountID,
+		Action:          "upload",
+		BucketID:        bucketID,
+		ContentLength:   int64(len(data)),
+		ContentMd5:      md5Hex(data),
+		ContentSha1:     sha1Hex(data),
+		ContentType:     contentType(r.Header.Get("Content-Type"), fileName),
+		FileID:          s.newID("4_z" + bucketID + "_f"),
+		FileInfo:        fileInfo(r.Header),
+		FileName:        fileName,
+		UploadTimestamp: s.now(),
+		data:            data,
+	}
+	s.files[f.FileID] = f
+	writeJSON(w, f)
+}
+
+func (s *Server) startLargeFile(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		BucketID    string            `json:"bucketId"`
+		FileName    string            `json:"fileName"`
+		ContentType string            `json:"contentType"`
+		FileInfo    map[string]string `json:"fileInfo"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if s.buckets[req.BucketID] == nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid bucketId: "+req.BucketID)
+		return
+	}
+	if req.FileInfo == nil {
+		req.FileInfo = map[string]string{}
+	}
+	f := &file{
+		AccountID:       s.AccountID,
+		Action:          "start",
+		BucketID:        req.BucketID,
+		ContentSha1:     "none",
+		ContentType:     contentType(req.ContentType, req.FileName),
+		FileID:          s.newID("4_z" + req.BucketID + "_f"),
+		FileInfo:        req.FileInfo,
+		FileName:        req.FileName,
+		UploadTimestamp: s.now(),
+		parts:           make(map[int]*part),
+	}
+	s.files[f.FileID] = f
+	writeJSON(w, f)
+}
+
+func (s *Server) getUploadPartURL(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		FileID string `json:"fileId"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if f := s.files[req.FileID]; f == nil || f.Action != "start" {
+		writeError(w, http.StatusBadRequest, "bad_request", "no unfinished large file with fileId "+req.FileID)
+		return
+	}
+	token := s.newID("upload")
+	s.uploadURLs[token] = s.URL + "/upload_part/" + req.FileID + "/" + token
+	writeJSON(w, map[string]string{
+		"fileId":             req.FileID,
+		"uploadUrl":          s.uploadURLs[token],
+		"authorizationToken": token,
+	})
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request) {
+	fileID := strings.Split(strings.TrimPrefix(r.URL.Path, "/upload_part/"), "/")[0]
+	f := s.files[fileID]
+	if f == nil || f.Action != "start" {
+		writeError(w, http.StatusBadRequest, "bad_request", "no unfinished large file with fileId "+fileID)
+		return
+	}
+	partNumber, err := strconv.Atoi(r.Header.Get("X-Bz-Part-Number"))
+	if err != nil || partNumber < 1 || partNumber > 10000 {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid X-Bz-Part-Number")
+		return
+	}
+	data, ok := readChecked(w, r)
+	if !ok {
+		return
+	}
+	f.parts[partNumber] = &part{sha1: sha1Hex(data), data: data, uploaded: s.now()}
+	writeJSON(w, map[string]interface{}{
+		"fileId":        fileID,
+		"partNumber":    partNumber,
+		"contentLength": len(data),
+		"contentSha1":   sha1Hex(data),
+	})
+}
+
+func (s *Server) finishLargeFile(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		FileID        string   `json:"fileId"`
+		PartSha1Array []string `json:"partSha1Array"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	f := s.files[req.FileID]
+	if f == nil || f.Action != "start" {
+		writeError(w, http.StatusBadRequest, "bad_request", "no unfinished large file with fileId "+req.FileID)
+		return
+	}
+	if len(req.PartSha1Array) < 2 || len(req.PartSha1Array) != len(f.parts) {
+		writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("large file has %d parts, partSha1Array has %d", len(f.parts), len(req.PartSha1Array)))
+		return
+	}
+	var data []byte
+	for i, partSha1 := range req.PartSha1Array {
+		p := f.parts[i+1]
+		if p == nil || p.sha1 != partSha1 {
+			writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("part %d missing or SHA1 does not match", i+1))
+			return
+		}
+		if i < len(req.PartSha1Array)-1 && int64(len(p.data)) < s.AbsoluteMinimumPartSize {
+			writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("part %d is smaller than the minimum part size", i+1))
+			return
+		}
+		data = append(data, p.data...)
+	}
+	f.Action = "upload"
+	f.data = data
+	f.parts = nil
+	f.ContentLength = int64(len(data))
+	writeJSON(w, f)
+}
+
+func (s *Server) listFileNames(w http.ResponseWriter, r *http.Request, version string) {
+	var req struct {
+		BucketID      string `json:"bucketId"`
+		StartFileName string `json:"startFileName"`
+		MaxFileCount  int    `json:"maxFileCount"`
+		Prefix        string `json:"prefix"`
+		Delimiter     string `json:"delimiter"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if s.buckets[req.BucketID] == nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid bucketId: "+req.BucketID)
+		return
+	}
+	// Only the latest version of each file name is listed, and none if that is hidden
+	latest := make(map[string]*file)
+	for _, f := range s.files {
+		if f.BucketID != req.BucketID || f.Action == "start" || !strings.HasPrefix(f.FileName, req.Prefix) {
+			continue
+		}
+		if l := latest[f.FileName]; l == nil || l.UploadTimestamp < f.UploadTimestamp {
+			latest[f.FileName] = f
+		}
+	}
+	var files []*file
+	for _, f := range latest {
+		if f.Action == "upload" {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].FileName < files[j].FileName })
+	files = fold(files, req.Prefix, req.Delimiter)
+
+	var page []*file
+	var nextFileName interface{}
+	for _, f := range files {
+		if f.FileName < req.StartFileName {
+			continue
+		}
+		if len(page) == maxFileCount(req.MaxFileCount) {
+			nextFileName = f.FileName
+			break
+		}
+		page = append(page, f)
+	}
+	writeJSON(w, map[string]interface{}{"files": listed(page, version), "nextFileName": nextFileName})
+}
+
+func (s *Server) listFileVersions(w http.ResponseWriter, r *http.Request, version string) {
+	var req struct {
+		BucketID      string `json:"bucketId"`
+		StartFileName string `json:"startFileName"`
+		StartFileID   string `json:"startFileId"`
+		MaxFileCount  int    `json:"maxFileCount"`
+		Prefix        string `json:"prefix"`
+		Delimiter     string `json:"delimiter"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if s.buckets[req.BucketID] == nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid bucketId: "+req.BucketID)
+		return
+	}
+	var files []*file
+	for _, f := range s.files {
+		if f.BucketID == req.BucketID && strings.HasPrefix(f.FileName, req.Prefix) {
+			files = append(files, f)
+		}
+	}
+	// Sorted by name, newest version first
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].FileName != files[j].FileName {
+			return files[i].FileName < files[j].FileName
+		}
+		return files[i].UploadTimestamp > files[j].UploadTimestamp
+	})
+	files = fold(files, req.Prefix, req.Delimiter)
+
+	var page []*file
+	var nextFileName, nextFileID interface{}
+	started := req.StartFileID == ""
+	for _, f := range files {
+		if f.FileName < req.StartFileName {
+			continue
+		}
+		if !started {
+			// Skip versions of startFileName listed before startFileId
+			if f.FileName == req.StartFileName && f.FileID != req.StartFileID {
+				continue
+			}
+			started = true
+		}
+		if len(page) == maxFileCount(req.MaxFileCount) {
+			nextFileName, nextFileID = f.FileName, f.FileID
+			break
+		}
+		page = append(page, f)
+	}
+	writeJSON(w, map[string]interface{}{"files": listed(page, version), "nextFileName": nextFileName, "nextFileId": nextFileID})
+}
+
+func (s *Server) deleteFileVersion(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		FileName string `json:"fileName"`
+		FileID   string `json:"fileId"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	f := s.files[req.FileID]
+	if f == nil || f.FileName != req.FileName || f.Action == "start" {
+		writeError(w, http.StatusBadRequest, "file_not_present", "File not present: "+req.FileName+" "+req.FileID)
+		return
+	}
+	delete(s.files, f.FileID)
+	writeJSON(w, map[string]string{"fileId": f.FileID, "fileName": f.FileName})
+}
+
+func (s *Server) copyFile(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		SourceFileID        string            `json:"sourceFileId"`
+		DestinationBucketID string            `json:"destinationBucketId"`
+		FileName            string            `json:"fileName"`
+		Range               string            `json:"range"`
+		MetadataDirective   string            `json:"metadataDirective"`
+		ContentType         string            `json:"contentType"`
+		FileInfo            map[string]string `json:"fileInfo"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	source := s.files[req.SourceFileID]
+	if source == nil || source.Action != "upload" {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid sourceFileId: "+req.SourceFileID)
+		return
+	}
+	bucketID := source.BucketID
+	if req.DestinationBucketID != "" {
+		bucketID = req.DestinationBucketID
+	}
+	if s.buckets[bucketID] == nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid destinationBucketId: "+bucketID)
+		return
+	}
+	data, ok := copyRange(w, source, req.Range)
+	if !ok {
+		return
+	}
+	f := &file{
+		AccountID:       s.AccountID,
+		Action:          "upload",
+		BucketID:        bucketID,
+		ContentLength:   int64(len(data)),
+		ContentMd5:      md5Hex(data),
+		ContentSha1:     sha1Hex(data),
+		FileID:          s.newID("4_z" + bucketID + "_f"),
+		FileName:        req.FileName,
+		UploadTimestamp: s.now(),
+		data:            data,
+	}
+	switch req.MetadataDirective {
+	case "", "COPY":
+		if req.ContentType != "" || req.FileInfo != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", "contentType and fileInfo must not be set with metadataDirective COPY")
+			return
+		}
+		f.ContentType, f.FileInfo = source.ContentType, make(map[string]string)
+		for key, value := range source.FileInfo {
+			f.FileInfo[key] = value
+		}
+	case "REPLACE":
+		if req.ContentType == "" {
+			writeError(w, http.StatusBadRequest, "bad_request", "contentType is required with metadataDirective REPLACE")
+			return
+		}
+		f.ContentType, f.FileInfo = contentType(req.ContentType, req.FileName), req.FileInfo
+		if f.FileInfo == nil {
+			f.FileInfo = map[string]string{}
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid metadataDirective: "+req.MetadataDirective)
+		return
+	}
+	s.files[f.FileID] = f
+	writeJSON(w, f)
+}
+
+func (s *Server) copyPart(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		SourceFileID string `json:"sourceFileId"`
+		LargeFileID  string `json:"largeFileId"`
+		PartNumber   int    `json:"partNumber"`
+		Range        string `json:"range"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	source := s.files[req.SourceFileID]
+	if source == nil || source.Action != "upload" {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid sourceFileId: "+req.SourceFileID)
+		return
+	}
+	f := s.files[req.LargeFileID]
+	if f == nil || f.Action != "start" {
+		writeError(w, http.StatusBadRequest, "bad_request", "no unfinished large file with fileId "+req.LargeFileID)
+		return
+	}
+	if req.PartNumber < 1 || req.PartNumber > 10000 {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid partNumber")
+		return
+	}
+	data, ok := copyRange(w, source, req.Range)
+	if !ok {
+		return
+	}
+	f.parts[req.PartNumber] = &part{sha1: sha1Hex(data), data: data, uploaded: s.now()}
+	writeJSON(w, map[string]interface{}{
+		"fileId":        req.LargeFileID,
+		"partNumber":    req.PartNumber,
+		"contentLength": len(data),
+		"contentSha1":   sha1Hex(data),
+	})
+}
+
+// copyRange returns the bytes of source selected by a copy range "bytes=first-last", all of them if
+// rangeHeader is empty, responding with 400 if the range is invalid
+func copyRange(w http.ResponseWriter, source *file, rangeHeader string) ([]byte, bool) {
+	if rangeHeader == "" {
+		return source.data, true
+	}
+	var first, last int64
+	if _, err := fmt.Sscanf(rangeHeader, "bytes=%d-%d", &first, &last); err != nil || first < 0 || last < first || last >= int64(len(source.data)) {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid range: "+rangeHeader)
+		return nil, false
+	}
+	return source.data[first : last+1], true
+}
+
+// downloadAuth is a token returned by b2_get_download_authorization
+type downloadAuth struct {
+	bucketID           string
+	prefix             string
+	contentDisposition string
+	expires            time.Time
+}
+
+func (s *Server) getDownloadAuthorization(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		BucketID               string `json:"bucketId"`
+		FileNamePrefix         string `json:"fileNamePrefix"`
+		ValidDurationInSeconds int64  `json:"validDurationInSeconds"`
+		B2ContentDisposition   string `json:"b2ContentDisposition"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if s.buckets[req.BucketID] == nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid bucketId: "+req.BucketID)
+		return
+	}
+	if req.ValidDurationInSeconds < 1 || req.ValidDurationInSeconds > 604800 {
+		writeError(w, http.StatusBadRequest, "bad_request", "validDurationInSeconds must be between 1 and 604800")
+		return
+	}
+	token := s.newID("3_download_")
+	s.shares[token] = &downloadAuth{
+		bucketID:           req.BucketID,
+		prefix:             req.FileNamePrefix,
+		contentDisposition: req.B2ContentDisposition,
+		expires:            time.Now().Add(time.Duration(req.ValidDurationInSeconds) * time.Second),
+	}
+	writeJSON(w, map[string]string{
+		"bucketId":           req.BucketID,
+		"fileNamePrefix":     req.FileNamePrefix,
+		"authorizationToken": token,
+	})
+}
+
+// checkShare accepts the authorization of r for downloading fileName from bucketID if it is an
+// account token or an unexpired download authorization for a prefix of fileName. Downloads with a
+// Content-Disposition override must request the one of the authorization, which is then sent.
+func (s *Server) checkShare(r *http.Request, bucketID string, fileName string) (int, string) {
+	token := r.Header.Get("Authorization")
+	if token == "" {
+		token = r.URL.Query().Get("Authorization")
+	}
+	share := s.shares[token]
+	if share == nil {
+		return s.checkToken(r, "b2_download_file_by_name")
+	}
+	switch {
+	case time.Now().After(share.expires):
+		return http.StatusUnauthorized, "expired_auth_token"
+	case share.bucketID != bucketID || !strings.HasPrefix(fileName, share.prefix):
+		return http.StatusUnauthorized, "unauthorized"
+	case r.URL.Query().Get("b2ContentDisposition") != share.contentDisposition:
+		return http.StatusBadRequest, "bad_request"
+	}
+	return http.StatusOK, ""
+}
+
+func (s *Server) getFileInfo(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		FileID string `json:"fileId"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	f := s.files[req.FileID]
+	if f == nil {
+		writeError(w, http.StatusNotFound, "not_found", "File not present: "+req.FileID)
+		return
+	}
+	writeJSON(w, f)
+}
+
+func (s *Server) hideFile(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		BucketID string `json:"bucketId"`
+		FileName string `json:"fileName"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if s.buckets[req.BucketID] == nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid bucketId: "+req.BucketID)
+		return
+	}
+	var latest *file
+	for _, f := range s.files {
+		if f.BucketID == req.BucketID && f.FileName == req.FileName && f.Action != "start" && (latest == nil || latest.UploadTimestamp < f.UploadTimestamp) {
+			latest = f
+		}
+	}
+	if latest == nil {
+		writeError(w, http.StatusNotFound, "no_such_file", "File not present: "+req.FileName)
+		return
+	}
+	if latest.Action == "hide" {
+		writeError(w, http.StatusBadRequest, "already_hidden", "File already hidden: "+req.FileName)
+		return
+	}
+	f := &file{
+		AccountID:       s.AccountID,
+		Action:          "hide",
+		BucketID:        req.BucketID,
+		ContentType:     "application/x-bz-hide-marker",
+		FileID:          s.newID("4_z" + req.BucketID + "_f"),
+		FileInfo:        map[string]string{},
+		FileName:        req.FileName,
+		UploadTimestamp: s.now(),
+	}
+	s.files[f.FileID] = f
+	writeJSON(w, f)
+}
+
+func (s *Server) listUnfinishedLargeFiles(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		BucketID     string `json:"bucketId"`
+		NamePrefix   string `json:"namePrefix"`
+		StartFileID  string `json:"startFileId"`
+		MaxFileCount int    `json:"maxFileCount"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if s.buckets[req.BucketID] == nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid bucketId: "+req.BucketID)
+		return
+	}
+	if req.MaxFileCount > 100 {
+		writeError(w, http.StatusBadRequest, "bad_request", "maxFileCount must be at most 100")
+		return
+	}
+	if req.MaxFileCount == 0 {
+		req.MaxFileCount = 100
+	}
+	var files []*file
+	for _, f := range s.files {
+		if f.BucketID == req.BucketID && f.Action == "start" && strings.HasPrefix(f.FileName, req.NamePrefix) && f.FileID >= req.StartFileID {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].FileID < files[j].FileID })
+	var nextFileID interface{}
+	if len(files) > req.MaxFileCount {
+		nextFileID = files[req.MaxFileCount].FileID
+		files = files[:req.MaxFileCount]
+	}
+	writeJSON(w, map[string]interface{}{"files": nonNil(files), "nextFileId": nextFileID})
+}
+
+func (s *Server) listParts(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		FileID          string `json:"fileId"`
+		StartPartNumber int    `json:"startPartNumber"`
+		MaxPartCount    int    `json:"maxPartCount"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	f := s.files[req.FileID]
+	if f == nil || f.Action != "start" {
+		writeError(w, http.StatusBadRequest, "bad_request", "no unfinished large file with fileId "+req.FileID)
+		return
+	}
+	if req.MaxPartCount == 0 {
+		req.MaxPartCount = 100
+	}
+	var numbers []int
+	for number := range f.parts {
+		if number >= req.StartPartNumber {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Ints(numbers)
+	var nextPartNumber interface{}
+	if len(numbers) > req.MaxPartCount {
+		nextPartNumber = numbers[req.MaxPartCount]
+		numbers = numbers[:req.MaxPartCount]
+	}
+	parts := []map[string]interface{}{}
+	for _, number := range numbers {
+		p := f.parts[number]
+		parts = append(parts, map[string]interface{}{
+			"fileId":          f.FileID,
+			"partNumber":      number,
+			"contentLength":   len(p.data),
+			"contentSha1":     p.sha1,
+			"uploadTimestamp": p.uploaded,
+		})
+	}
+	writeJSON(w, map[string]interface{}{"parts": parts, "nextPartNumber": nextPartNumber})
+}
+
+func (s *Server) cancelLargeFile(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		FileID string `json:"fileId"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	f := s.files[req.FileID]
+	if f == nil || f.Action != "start" {
+		writeError(w, http.StatusBadRequest, "bad_request", "no such unfinished large file: "+req.FileID)
+		return
+	}
+	delete(s.files, f.FileID)
+	writeJSON(w, map[string]string{"accountId": f.AccountID, "bucketId": f.BucketID, "fileId": f.FileID, "fileName": f.FileName})
+}
+
+func (s *Server) downloadFileByID(w http.ResponseWriter, r *http.Request) {
+	f := s.files[r.URL.Query().Get("fileId")]
+	if f == nil || f.Action != "upload" {
+		writeError(w, http.StatusNotFound, "not_found", "file not present: "+r.URL.Query().Get("fileId"))
+		return
+	}
+	serveFile(w, r, f)
+}
+
+func (s *Server) downloadFileByName(w http.ResponseWriter, r *http.Request) {
+	// Path is /file/<bucketName>/<fileName>, where fileName may contain further slashes
+	names := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/file/"), "/", 2)
+	bucketName, fileName := names[0], names[1]
+	b := s.bucketByName(bucketName)
+	if b == nil {
+		writeError(w, http.StatusNotFound, "not_found", "bucket not found: "+bucketName)
+		return
+	}
+	if b.BucketType != "allPublic" {
+		if status, code := s.checkShare(r, b.BucketID, fileName); status != http.StatusOK {
+			writeError(w, status, code, "invalid authorization token")
+			return
+		}
+	}
+	var latest *file
+	for _, f := range s.files {
+		if f.BucketID == b.BucketID && f.FileName == fileName && f.Action != "start" && (latest == nil || latest.UploadTimestamp < f.UploadTimestamp) {
+			latest = f
+		}
+	}
+	if latest == nil || latest.Action != "upload" {
+		writeError(w, http.StatusNotFound, "not_found", "file not present: "+fileName)
+		return
+	}
+	serveFile(w, r, latest)
+}
+
+// serveFile writes the data of f with the headers B2 sends for downloads, supporting Range requests
+func serveFile(w http.ResponseWriter, r *http.Request, f *file) {
+	w.Header().Set("Content-Type", f.ContentType)
+	w.Header().Set("X-Bz-File-Id", f.FileID)
+	w.Header().Set("X-Bz-File-Name", url.QueryEscape(f.FileName))
+	w.Header().Set("X-Bz-Content-Sha1", f.ContentSha1)
+	w.Header().Set("X-Bz-Upload-Timestamp", strconv.FormatInt(f.UploadTimestamp, 10))
+	if disposition := r.URL.Query().Get("b2ContentDisposition"); disposition != "" {
+		w.Header().Set("Content-Disposition", disposition)
+	}
+	for key, value := range f.FileInfo {
+		w.Header().Set("X-Bz-Info-"+key, value)
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(f.data))
+}
+
+// fold replaces all files below a delimiter after prefix by a single folder entry
+func fold(files []*file, prefix, delimiter string) []*file {
+	if delimiter == "" {
+		return files
+	}
+	var folded []*file
+	for _, f := range files {
+		if i := strings.Index(f.FileName[len(prefix):], delimiter); i >= 0 {
+			name := f.FileName[:len(prefix)+i+len(delimiter)]
+			if len(folded) == 0 || folded[len(folded)-1].FileName != name {
+				folded = append(folded, folder(name))
+			}
+			continue
+		}
+		folded = append(folded, f)
+	}
+	return folded
+}
+
+// readChecked reads the request body and validates it against X-Bz-Content-Sha1
+func readChecked(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "could not read request body")
+		return nil, false
+	}
+	if r.ContentLength >= 0 && int64(len(data)) != r.ContentLength {
+		writeError(w, http.StatusBadRequest, "bad_request", "Content-Length does not match data received")
+		return nil, false
+	}
+	contentSha1 := r.Header.Get("X-Bz-Content-Sha1")
+	if contentSha1 == "" {
+		writeError(w, http.StatusBadRequest, "bad_request", "missing X-Bz-Content-Sha1")
+		return nil, false
+	}
+	if contentSha1 == "hex_digits_at_end" {
+		// The SHA1 follows the data as 40 hex digits
+		if len(data) < 40 {
+			writeError(w, http.StatusBadRequest, "bad_request", "missing SHA1 at end of data")
+			return nil, false
+		}
+		data, contentSha1 = data[:len(data)-40], string(data[len(data)-40:])
+	}
+	if contentSha1 != "do_not_verify" && !strings.EqualFold(contentSha1, sha1Hex(data)) {
+		writeError(w, http.StatusBadRequest, "bad_request", "Checksum did not match data received")
+		return nil, false
+	}
+	return data, true
+}
+
+// fileInfo returns the custom file information sent as X-Bz-Info-* headers, with lower case names
+func fileInfo(header http.Header) map[string]string {
+	info := make(map[string]string)
+	for key := range header {
+		if strings.HasPrefix(key, "X-Bz-Info-") {
+			value, err := url.QueryUnescape(header.Get(key))
+			if err != nil {
+				value = header.Get(key)
+			}
+			info[strings.ToLower(strings.TrimPrefix(key, "X-Bz-Info-"))] = value
+		}
+	}
+	return info
+}
+
+// contentType resolves b2/x-auto to a content type based on the file name extension
+func contentType(requested, fileName string) string {
+	if requested != "" && requested != "b2/x-auto" {
+		return requested
+	}
+	if t := mime.TypeByExtension(path.Ext(fileName)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+func maxFileCount(requested int) int {
+	if requested <= 0 {
+		return 100
+	}
+	if requested > 10000 {
+		return 10000
+	}
+	return requested
+}
+
+// v1File is a file as listed by version 1 of the API, with its size instead of contentLength
+type v1File struct {
+	*file
+	// ContentLength hides the contentLength of file, nil is omitted
+	ContentLength *int64 `json:"contentLength,omitempty"`
+	Size          int64  `json:"size"`
+}
+
+// listed returns files in the layout of listings of version
+func listed(files []*file, version string) interface{} {
+	if version != "v1" {
+		return nonNil(files)
+	}
+	v1Files := []v1File{}
+	for _, f := range files {
+		v1Files = append(v1Files, v1File{file: f, Size: f.ContentLength})
+	}
+	return v1Files
+}
+
+func nonNil(files []*file) []*file {
+	if files == nil {
+		return []*file{}
+	}
+	return files
+}
+
+func sha1Hex(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
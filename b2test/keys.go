@@ -0,0 +1,147 @@
+package b2test
+
+import (
+	"net/http"
+	"sort"
+)
+
+// allCapabilities are the capabilities of the account master key
+var allCapabilities = []string{
+	"listKeys", "writeKeys", "deleteKeys",
+	"listBuckets", "writeBuckets", "deleteBuckets",
+	"listFiles", "readFiles", "shareFiles", "writeFiles", "deleteFiles",
+}
+
+type appKey struct {
+	AccountID           string   `json:"accountId"`
+	ApplicationKeyID    string   `json:"applicationKeyId"`
+	ApplicationKey      string   `json:"applicationKey,omitempty"`
+	KeyName             string   `json:"keyName"`
+	Capabilities        []string `json:"capabilities"`
+	BucketID            *string  `json:"bucketId"`
+	NamePrefix          *string  `json:"namePrefix"`
+	ExpirationTimestamp *int64   `json:"expirationTimestamp"`
+}
+
+// allowed returns the allowed block of b2_authorize_account for key, which lists the buckets
+// of the key in version 3 and a single bucket in earlier versions
+func (s *Server) allowed(key *appKey, version string) map[string]interface{} {
+	allowed := map[string]interface{}{
+		"capabilities": key.Capabilities,
+		"namePrefix":   key.NamePrefix,
+	}
+	var bucketName *string
+	if key.BucketID != nil {
+		if b, ok := s.buckets[*key.BucketID]; ok {
+			bucketName = &b.BucketName
+		}
+	}
+	if version != "v3" {
+		allowed["bucketId"] = key.BucketID
+		allowed["bucketName"] = bucketName
+		return allowed
+	}
+	buckets := []map[string]interface{}{}
+	if key.BucketID != nil {
+		buckets = append(buckets, map[string]interface{}{"id": *key.BucketID, "name": bucketName})
+	}
+	allowed["buckets"] = buckets
+	return allowed
+}
+
+func (s *Server) createKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AccountID              string   `json:"accountId"`
+		KeyName                string   `json:"keyName"`
+		Capabilities           []string `json:"capabilities"`
+		ValidDurationInSeconds int64    `json:"validDurationInSeconds"`
+		BucketID               *string  `json:"bucketId"`
+		NamePrefix             *string  `json:"namePrefix"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if req.AccountID != s.AccountID {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "accountId does not match authorization")
+		return
+	}
+	if req.KeyName == "" || len(req.Capabilities) == 0 {
+		writeError(w, http.StatusBadRequest, "bad_request", "keyName and capabilities are required")
+		return
+	}
+	if req.BucketID != nil {
+		if _, ok := s.buckets[*req.BucketID]; !ok {
+			writeError(w, http.StatusBadRequest, "bad_request", "invalid bucketId "+*req.BucketID)
+			return
+		}
+	} else if req.NamePrefix != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "namePrefix requires bucketId")
+		return
+	}
+	key := &appKey{
+		AccountID:        s.AccountID,
+		ApplicationKeyID: s.newID("key"),
+		ApplicationKey:   s.newID("secret"),
+		KeyName:          req.KeyName,
+		Capabilities:     req.Capabilities,
+		BucketID:         req.BucketID,
+		NamePrefix:       req.NamePrefix,
+	}
+	if req.ValidDurationInSeconds > 0 {
+		expires := s.clock + req.ValidDurationInSeconds*1000
+		key.ExpirationTimestamp = &expires
+	}
+	s.keys[key.ApplicationKeyID] = key
+	writeJSON(w, key)
+}
+
+func (s *Server) listKeys(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AccountID             string `json:"accountId"`
+		MaxKeyCount           int    `json:"maxKeyCount"`
+		StartApplicationKeyID string `json:"startApplicationKeyId"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if req.MaxKeyCount <= 0 {
+		req.MaxKeyCount = 100
+	}
+	var ids []string
+	for id := range s.keys {
+		if id >= req.StartApplicationKeyID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	keys := []appKey{}
+	var next *string
+	for i, id := range ids {
+		if i == req.MaxKeyCount {
+			next = &ids[i]
+			break
+		}
+		key := *s.keys[id]
+		key.ApplicationKey = ""
+		keys = append(keys, key)
+	}
+	writeJSON(w, map[string]interface{}{"keys": keys, "nextApplicationKeyId": next})
+}
+
+func (s *Server) deleteKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ApplicationKeyID string `json:"applicationKeyId"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	key, ok := s.keys[req.ApplicationKeyID]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid applicationKeyId "+req.ApplicationKeyID)
+		return
+	}
+	delete(s.keys, req.ApplicationKeyID)
+	deleted := *key
+	deleted.ApplicationKey = ""
+	writeJSON(w, deleted)
+}
@@ -0,0 +1,331 @@
+// Package b2test provides an in-memory fake of the Backblaze B2 API for hermetic tests.
+//
+// A Server implements the endpoints used by gopherb2 on top of an httptest.Server, for all native
+// API versions. Point a client at it by using APIURL() as the configured API URL together with
+// the AccountID and ApplicationKey of the Server:
+//
+//	srv := b2test.NewServer()
+//	defer srv.Close()
+//	client, err := gopherb2.NewClient(gopherb2.Configuration{
+//		AcctID: srv.AccountID,
+//		AppID:  srv.ApplicationKey,
+//		APIURL: srv.APIURL(),
+//	})
+package b2test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is a fake B2 API keeping all buckets and files in memory
+type Server struct {
+	*httptest.Server
+
+	// AccountID and ApplicationKey are the credentials accepted by b2_authorize_account
+	AccountID      string
+	ApplicationKey string
+	// AbsoluteMinimumPartSize is the smallest part, other than the last, accepted for large files
+	AbsoluteMinimumPartSize int64
+	// RecommendedPartSize is returned by b2_authorize_account
+	RecommendedPartSize int64
+
+	mu         sync.Mutex
+	counter    int
+	clock      int64
+	tokens     map[string]bool // Authorization token and whether it is still valid
+	uploadURLs map[string]string
+	buckets    map[string]*bucket
+	files      map[string]*file
+	keys       map[string]*appKey
+	shares     map[string]*downloadAuth
+	faults     []fault
+	calls      map[string]int
+}
+
+// fault is an error response injected for the next calls of an operation
+type fault struct {
+	operation string
+	status    int
+	code      string
+	count     int
+}
+
+// NewServer starts and returns a new fake B2 API server, the caller should call Close when finished
+func NewServer() *Server {
+	s := &Server{
+		AccountID:               "b2testaccount",
+		ApplicationKey:          "b2testapplicationkey",
+		AbsoluteMinimumPartSize: 5 * 1000 * 1000,
+		RecommendedPartSize:     100 * 1000 * 1000,
+		clock:                   1500000000000,
+		tokens:                  make(map[string]bool),
+		uploadURLs:              make(map[string]string),
+		buckets:                 make(map[string]*bucket),
+		files:                   make(map[string]*file),
+		keys:                    make(map[string]*appKey),
+		shares:                  make(map[string]*downloadAuth),
+		calls:                   make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// APIURL returns the URL to configure as API URL of a client using the server
+func (s *Server) APIURL() string {
+	return s.URL
+}
+
+// ExpireTokens makes every authorization token issued so far, including download authorizations,
+// fail with 401 expired_auth_token
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token := range s.tokens {
+		s.tokens[token] = false
+	}
+	for _, share := range s.shares {
+		share.expires = time.Time{}
+	}
+}
+
+// FailNext makes the next count calls of operation, such as "b2_list_buckets" or "b2_upload_file",
+// fail with the given HTTP status and B2 error code
+func (s *Server) FailNext(operation string, count int, status int, code string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, fault{operation: operation, status: status, code: code, count: count})
+}
+
+// Calls returns how many times operation was called, including failed calls
+func (s *Server) Calls(operation string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[operation]
+}
+
+// AddFile stores a new version of the file name with content data in bucketID, as if it was uploaded,
+// and returns its file ID
+func (s *Server) AddFile(bucketID string, name string, data []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := &file{
+		AccountID:       s.AccountID,
+		Action:          "upload",
+		BucketID:        bucketID,
+		ContentLength:   int64(len(data)),
+		ContentMd5:      md5Hex(data),
+		ContentSha1:     sha1Hex(data),
+		ContentType:     contentType("", name),
+		FileID:          s.newID("4_z" + bucketID + "_f"),
+		FileInfo:        map[string]string{},
+		FileName:        name,
+		UploadTimestamp: s.now(),
+		data:            data,
+	}
+	s.files[f.FileID] = f
+	return f.FileID
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	parts := strings.SplitN(path, "/", 3)
+	switch {
+	case len(parts) == 3 && parts[0] == "b2api":
+		s.serveAPI(w, r, parts[1], parts[2])
+	case len(parts) == 3 && parts[0] == "upload":
+		s.serveAPI(w, r, "", "b2_upload_file")
+	case len(parts) == 3 && parts[0] == "upload_part":
+		s.serveAPI(w, r, "", "b2_upload_part")
+	case len(parts) == 3 && parts[0] == "file":
+		s.serveAPI(w, r, "", "b2_download_file_by_name")
+	default:
+		writeError(w, http.StatusNotFound, "not_found", "unknown path "+r.URL.Path)
+	}
+}
+
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request, version string, operation string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[operation]++
+	for i := range s.faults {
+		if s.faults[i].operation == operation && s.faults[i].count > 0 {
+			s.faults[i].count--
+			writeError(w, s.faults[i].status, s.faults[i].code, "injected failure")
+			return
+		}
+	}
+
+	if operation == "b2_authorize_account" {
+		s.authorizeAccount(w, r, version)
+		return
+	}
+	// Downloads by name from public buckets need no authorization, downloadFileByName checks the token
+	if status, code := s.checkToken(r, operation); status != http.StatusOK && operation != "b2_download_file_by_name" {
+		writeError(w, status, code, "invalid authorization token")
+		return
+	}
+	switch operation {
+	case "b2_create_key":
+		s.createKey(w, r)
+	case "b2_list_keys":
+		s.listKeys(w, r)
+	case "b2_delete_key":
+		s.deleteKey(w, r)
+	case "b2_create_bucket":
+		s.createBucket(w, r)
+	case "b2_list_buckets":
+		s.listBuckets(w, r)
+	case "b2_update_bucket":
+		s.updateBucket(w, r)
+	case "b2_delete_bucket":
+		s.deleteBucket(w, r)
+	case "b2_get_upload_url":
+		s.getUploadURL(w, r)
+	case "b2_upload_file":
+		s.uploadFile(w, r)
+	case "b2_start_large_file":
+		s.startLargeFile(w, r)
+	case "b2_get_upload_part_url":
+		s.getUploadPartURL(w, r)
+	case "b2_upload_part":
+		s.uploadPart(w, r)
+	case "b2_finish_large_file":
+		s.finishLargeFile(w, r)
+	case "b2_list_file_names":
+		s.listFileNames(w, r, version)
+	case "b2_list_file_versions":
+		s.listFileVersions(w, r, version)
+	case "b2_delete_file_version":
+		s.deleteFileVersion(w, r)
+	case "b2_copy_file":
+		s.copyFile(w, r)
+	case "b2_copy_part":
+		s.copyPart(w, r)
+	case "b2_get_download_authorization":
+		s.getDownloadAuthorization(w, r)
+	case "b2_get_file_info":
+		s.getFileInfo(w, r)
+	case "b2_hide_file":
+		s.hideFile(w, r)
+	case "b2_list_unfinished_large_files":
+		s.listUnfinishedLargeFiles(w, r)
+	case "b2_list_parts":
+		s.listParts(w, r)
+	case "b2_cancel_large_file":
+		s.cancelLargeFile(w, r)
+	case "b2_download_file_by_id":
+		s.downloadFileByID(w, r)
+	case "b2_download_file_by_name":
+		s.downloadFileByName(w, r)
+	default:
+		writeError(w, http.StatusBadRequest, "bad_request", "unsupported operation "+operation)
+	}
+}
+
+func (s *Server) authorizeAccount(w http.ResponseWriter, r *http.Request, version string) {
+	keyID, secret, ok := r.BasicAuth()
+	// The account master key uses the account ID as key ID, other keys are created with b2_create_key
+	key := &appKey{Capabilities: allCapabilities}
+	if !ok || keyID != s.AccountID || secret != s.ApplicationKey {
+		key = s.keys[keyID]
+		if !ok || key == nil || secret != key.ApplicationKey {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "invalid application key")
+			return
+		}
+	}
+	token := s.newID("token")
+	s.tokens[token] = true
+	storageAPI := map[string]interface{}{
+		"apiUrl":                  s.URL,
+		"downloadUrl":             s.URL,
+		"s3ApiUrl":                s.URL + "/s3",
+		"recommendedPartSize":     s.RecommendedPartSize,
+		"absoluteMinimumPartSize": s.AbsoluteMinimumPartSize,
+		"allowed":                 s.allowed(key, version),
+	}
+	if version == "v3" {
+		// Version 3 nests the storage API settings
+		storageAPI["infoType"] = "storageApi"
+		writeJSON(w, map[string]interface{}{
+			"accountId":          s.AccountID,
+			"authorizationToken": token,
+			"apiInfo":            map[string]interface{}{"storageApi": storageAPI},
+		})
+		return
+	}
+	storageAPI["accountId"] = s.AccountID
+	storageAPI["authorizationToken"] = token
+	storageAPI["minimumPartSize"] = s.RecommendedPartSize
+	writeJSON(w, storageAPI)
+}
+
+// checkToken validates the Authorization header of r, which for uploads must be an upload token
+// and for downloads by name may also be passed as the Authorization query parameter
+func (s *Server) checkToken(r *http.Request, operation string) (int, string) {
+	token := r.Header.Get("Authorization")
+	if token == "" {
+		token = r.URL.Query().Get("Authorization")
+	}
+	if operation == "b2_upload_file" || operation == "b2_upload_part" {
+		if target, ok := s.uploadURLs[token]; ok && s.URL+r.URL.Path == target {
+			return http.StatusOK, ""
+		}
+		return http.StatusUnauthorized, "bad_auth_token"
+	}
+	valid, ok := s.tokens[token]
+	switch {
+	case !ok:
+		return http.StatusUnauthorized, "bad_auth_token"
+	case !valid:
+		return http.StatusUnauthorized, "expired_auth_token"
+	}
+	return http.StatusOK, ""
+}
+
+// newID returns a new unique identifier starting with prefix
+func (s *Server) newID(prefix string) string {
+	s.counter++
+	return fmt.Sprintf("%s%06d", prefix, s.counter)
+}
+
+// newBucketID returns a new bucket ID of 24 hex digits, like the IDs of B2
+func (s *Server) newBucketID() string {
+	s.counter++
+	return fmt.Sprintf("4a48fe%018x", s.counter)
+}
+
+// now returns an increasing fake upload timestamp in milliseconds
+func (s *Server) now() int64 {
+	s.clock += 1000
+	return s.clock
+}
+
+// decodeRequest reads the JSON request body of r into v, responding with 400 if that fails
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid JSON request: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  status,
+		"code":    code,
+		"message": message,
+	})
+}
@@ -0,0 +1,72 @@
+package gopherb2
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// MaxBucketInfo is the number of bucket info entries B2 allows
+const MaxBucketInfo = 10
+
+// ValidateBucketInfo checks info like B2 does: at most MaxBucketInfo entries, no empty keys
+func ValidateBucketInfo(info map[string]string) error {
+	if len(info) > MaxBucketInfo {
+		return fmt.Errorf("At most %d bucket info entries are allowed, got %d", MaxBucketInfo, len(info))
+	}
+	for key := range info {
+		if key == "" {
+			return fmt.Errorf("Bucket info keys must not be empty")
+		}
+	}
+	return nil
+}
+
+// SetBucketInfo sets the bucket info entries of set and removes the keys of remove from bucket
+// bucketID, keeping its other entries, and returns the updated Bucket. The update fails with
+// ErrRevisionConflict if the bucket was changed concurrently.
+func (c *Client) SetBucketInfo(bucketID string, set map[string]string, remove []string) (Bucket, error) {
+	return c.SetBucketInfoContext(context.Background(), bucketID, set, remove)
+}
+
+// SetBucketInfoContext is like SetBucketInfo with a context limiting the requests
+func (c *Client) SetBucketInfoContext(ctx context.Context, bucketID string, set map[string]string, remove []string) (Bucket, error) {
+	bucket, err := c.GetBucketContext(ctx, bucketID)
+	if err != nil {
+		return bucket, err
+	}
+	info := make(map[string]string, len(bucket.BucketInfo)+len(set))
+	for key, value := range bucket.BucketInfo {
+		info[key] = value
+	}
+	for _, key := range remove {
+		if _, ok := info[key]; !ok {
+			return bucket, fmt.Errorf("No bucket info %q", key)
+		}
+		delete(info, key)
+	}
+	for key, value := range set {
+		info[key] = value
+	}
+	return c.UpdateBucketContext(ctx, bucket.BucketID, BucketSettings{BucketInfo: info, IfRevisionMatches: bucket.Revision})
+}
+
+// PrintBucketInfo Displays bucket info in console
+func PrintBucketInfo(info map[string]string) error {
+	keys := make([]string, 0, len(info))
+	for key := range info {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	writer := new(tabwriter.Writer)
+	fmt.Println("Bucket Info")
+	writer.Init(os.Stdout, 0, 5, 1, ' ', 0)
+	fmt.Fprintln(writer, "-KEY-\t -VALUE-")
+	for _, key := range keys {
+		fmt.Fprintln(writer, key+"\t", info[key]+"\t")
+	}
+	fmt.Fprintln(writer)
+	return writer.Flush()
+}
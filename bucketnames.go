@@ -0,0 +1,143 @@
+package gopherb2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/uber-go/zap"
+)
+
+// ValidateBucketName checks name against the B2 bucket name rules: 6 to 50 letters, digits and
+// dashes, not starting with "b2-"
+func ValidateBucketName(name string) error {
+	if len(name) < 6 || len(name) > 50 {
+		return fmt.Errorf("Bucket name %q must be 6 to 50 characters", name)
+	}
+	if strings.HasPrefix(strings.ToLower(name), "b2-") {
+		return fmt.Errorf("Bucket name %q must not start with \"b2-\"", name)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return fmt.Errorf("Bucket name %q may only contain letters, digits and \"-\"", name)
+		}
+	}
+	return nil
+}
+
+// ResolveBucket returns the ID of bucket, which is either a bucket name or a bucket ID. Names are
+// looked up with b2_list_buckets and cached by the client once found, as are the IDs of buckets
+// created or listed. A bucket of 24 hex digits that is not the name of a bucket is returned
+// unchanged as ID, any other name of no bucket returns an error matching ErrNotFound. All methods
+// taking a bucket ID accept a bucket name as well and resolve it with ResolveBucket.
+func (c *Client) ResolveBucket(bucket string) (string, error) {
+	return c.ResolveBucketContext(context.Background(), bucket)
+}
+
+// ResolveBucketContext is like ResolveBucket with a context limiting the request
+func (c *Client) ResolveBucketContext(ctx context.Context, bucket string) (string, error) {
+	if bucket == "" {
+		return "", errors.New("Bucket name or ID required")
+	}
+	c.bucketsMu.Lock()
+	bucketID, ok := c.bucketIDs[bucket]
+	c.bucketsMu.Unlock()
+	if ok {
+		return bucketID, nil
+	}
+	// Keys restricted to buckets know their names without listing them
+	allowed := c.Authorization().Allowed
+	if allowed.BucketName == bucket && allowed.BucketID != "" {
+		c.cacheBucket(bucket, allowed.BucketID)
+		return allowed.BucketID, nil
+	}
+	for _, b := range allowed.Buckets {
+		if b.Name == bucket && b.ID != "" {
+			c.cacheBucket(bucket, b.ID)
+			return b.ID, nil
+		}
+	}
+	if allowed.BucketID != "" || len(allowed.Buckets) > 0 {
+		// Other buckets are out of reach of the key, the operation reports a CapabilityError
+		return bucket, nil
+	}
+	if ValidateBucketName(bucket) != nil {
+		return "", bucketNotFound(bucket)
+	}
+
+	var buckets Buckets
+	reqBody := map[string]string{
+		"accountId":  c.Authorization().AccountID,
+		"bucketName": bucket,
+	}
+	err := c.apiCall(ctx, "b2_list_buckets", reqBody, &buckets)
+	var capErr *CapabilityError
+	if errors.As(err, &capErr) || errors.Is(err, ErrUnauthorized) {
+		// The key may not list buckets, so bucket can only be used as ID
+		return bucket, nil
+	}
+	if err != nil {
+		c.Logger.Warn("Could not look up Bucket name",
+			zap.String("Bucket", bucket),
+			zap.Error(err),
+		)
+		return "", err
+	}
+	if len(buckets.Bucket) != 1 {
+		if isBucketID(bucket) {
+			// Not the name of a bucket, so bucket is used as ID
+			return bucket, nil
+		}
+		// Not cached, the bucket may be created later
+		return "", bucketNotFound(bucket)
+	}
+	bucketID = buckets.Bucket[0].BucketID
+	c.cacheBucket(bucket, bucketID)
+	c.cacheBucket(bucketID, bucketID)
+	c.Logger.Debug("Bucket resolved",
+		zap.String("Bucket", bucket),
+		zap.String("Bucket ID", bucketID),
+	)
+	return bucketID, nil
+}
+
+// isBucketID reports whether bucket has the form of a B2 bucket ID, 24 lower case hex digits
+func isBucketID(bucket string) bool {
+	if len(bucket) != 24 {
+		return false
+	}
+	for _, r := range bucket {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// bucketNotFound returns the error for bucket, which is neither a bucket name nor ID
+func bucketNotFound(bucket string) error {
+	return &APIError{Status: http.StatusNotFound, Code: "not_found", Message: "no bucket named " + bucket}
+}
+
+// cacheBucket remembers that bucket, a name or ID, refers to bucketID
+func (c *Client) cacheBucket(bucket string, bucketID string) {
+	c.bucketsMu.Lock()
+	defer c.bucketsMu.Unlock()
+	if c.bucketIDs == nil {
+		c.bucketIDs = make(map[string]string)
+	}
+	c.bucketIDs[bucket] = bucketID
+}
+
+// forgetBucket removes the cached names of the deleted bucket bucketID
+func (c *Client) forgetBucket(bucketID string) {
+	c.bucketsMu.Lock()
+	defer c.bucketsMu.Unlock()
+	for bucket, id := range c.bucketIDs {
+		if id == bucketID {
+			delete(c.bucketIDs, bucket)
+		}
+	}
+}
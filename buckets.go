@@ -1,12 +1,9 @@
 package gopherb2
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"os"
 	"text/tabwriter"
 
@@ -17,134 +14,280 @@ type Buckets struct {
 	Bucket []Bucket `json:"buckets"`
 }
 type Bucket struct {
-	AccountID      string   `json:"accountId"`
-	BucketID       string   `json:"bucketId"`
-	BucketName     string   `json:"bucketName"`
-	BucketType     string   `json:"bucketType"`
-	LifecycleRules []string `json:"lifecycleRules"`
-	Revision       int      `json:"revision"`
+	AccountID      string            `json:"accountId"`
+	BucketID       string            `json:"bucketId"`
+	BucketName     string            `json:"bucketName"`
+	BucketType     string            `json:"bucketType"`
+	BucketInfo     map[string]string `json:"bucketInfo"`
+	LifecycleRules []LifecycleRule   `json:"lifecycleRules"`
+	CORSRules      []CORSRule        `json:"corsRules"`
+	Revision       int               `json:"revision"`
+	// DefaultServerSideEncryption is returned by API version 2 and later
+	DefaultServerSideEncryption ServerSideEncryption `json:"defaultServerSideEncryption"`
 }
 
-// Creates new B2 bucket and returns API response
-func B2CreateBucket(bucketName string, bucketPublic bool) {
-	//TODO: Check bucket name validity
-
-	if len(bucketName) < 6 {
-		logger.Fatal("Bucket Name must be at least 6 chars",
-			zap.String("Bucket Name too short", bucketName),
-		)
-	}
+// CreateBucket creates new B2 bucket and returns the created Bucket
+func (c *Client) CreateBucket(bucketName string, bucketPublic bool) (Bucket, error) {
+	return c.CreateBucketContext(context.Background(), bucketName, bucketPublic)
+}
 
+// CreateBucketContext is like CreateBucket with a context limiting the request
+func (c *Client) CreateBucketContext(ctx context.Context, bucketName string, bucketPublic bool) (Bucket, error) {
 	// Public or private bucketName
 	var bucketType = "allPrivate"
 	if bucketPublic == true {
 		bucketType = "allPublic"
 	}
+	return c.CreateBucketWithSettingsContext(ctx, bucketName, BucketSettings{BucketType: bucketType})
+}
 
-	// Authorize and Get API Token
-	authorizationResponse := AuthorizeAcct()
-
-	// Request (POST https://api001.backblazeb2.com/b2api/v1/b2_create_bucket)
-
-	jsonData := []byte(`{"accountId": "` + authorizationResponse.AccountID + `", "bucketName":"` + bucketName + `", "bucketType":"` + bucketType + `" }`)
-	body := bytes.NewBuffer(jsonData)
-
-	// Create client
-	client := &http.Client{}
-
-	// Create request
-	req, err := http.NewRequest("POST", authorizationResponse.ApiURL+"/b2api/v1/b2_create_bucket", body)
-
-	// Headers
-	req.Header.Add("Authorization", authorizationResponse.AuthorizationToken)
-	req.Header.Add("Content-Type", "application/json; charset=utf-8")
-
-	// Fetch Request
-	resp, err := client.Do(req)
+// CreateBucketWithSettings creates new B2 bucket with the type, bucket info, lifecycle and CORS rules
+// of settings, a private bucket if settings has no type, and returns the created Bucket
+func (c *Client) CreateBucketWithSettings(bucketName string, settings BucketSettings) (Bucket, error) {
+	return c.CreateBucketWithSettingsContext(context.Background(), bucketName, settings)
+}
 
-	if err != nil {
-		fmt.Println("Failure : ", err)
+// CreateBucketWithSettingsContext is like CreateBucketWithSettings with a context limiting the request
+func (c *Client) CreateBucketWithSettingsContext(ctx context.Context, bucketName string, settings BucketSettings) (Bucket, error) {
+	var bucket Bucket
+	if err := ValidateBucketName(bucketName); err != nil {
+		return bucket, err
+	}
+	if settings.BucketType == "" {
+		settings.BucketType = "allPrivate"
+	}
+	if err := ValidateLifecycleRules(settings.LifecycleRules); err != nil {
+		return bucket, err
+	}
+	if err := ValidateCORSRules(settings.CORSRules); err != nil {
+		return bucket, err
+	}
+	if err := ValidateBucketInfo(settings.BucketInfo); err != nil {
+		return bucket, err
 	}
 
-	// Read Response Body
-	respBody, _ := ioutil.ReadAll(resp.Body)
-	defer resp.Body.Close()
-
-	var apiResponse Response
-	apiResponse = Response{Header: resp.Header, Status: resp.Status, Body: respBody}
-	if apiResponse.Status == "200 OK" {
-		logger.Debug("Create New Bucket Successful",
-			zap.String("Bucket Name:", bucketName),
-		)
-	} else {
-		logger.Panic("Could not create new Bucket",
-			zap.String("API Resp Body:", string(apiResponse.Body)),
-		)
+	// Request (POST https://api001.backblazeb2.com/b2api/v1/b2_create_bucket)
+	reqBody := map[string]interface{}{
+		"accountId":  c.Authorization().AccountID,
+		"bucketName": bucketName,
+		"bucketType": settings.BucketType,
 	}
-	// Parse JSON 'Bucket' Response
-	var bucket Bucket
-	err = json.Unmarshal(apiResponse.Body, &bucket)
+	if settings.BucketInfo != nil {
+		reqBody["bucketInfo"] = settings.BucketInfo
+	}
+	if settings.LifecycleRules != nil {
+		reqBody["lifecycleRules"] = settings.LifecycleRules
+	}
+	if settings.CORSRules != nil {
+		reqBody["corsRules"] = settings.CORSRules
+	}
+	err := c.apiCall(ctx, "b2_create_bucket", reqBody, &bucket)
 	if err != nil {
-		fmt.Println("Bucket JSON Parse Failed", err)
+		c.Logger.Warn("Could not create new Bucket",
+			zap.String("Bucket Name:", bucketName),
+			zap.Error(err),
+		)
+		return bucket, err
 	}
-	logger.Info("New Bucket Created",
+	c.cacheBucket(bucket.BucketName, bucket.BucketID)
+	c.cacheBucket(bucket.BucketID, bucket.BucketID)
+	c.Logger.Info("New Bucket Created",
 		zap.String("Bucket Name:", bucketName),
 		zap.String("Bucket ID:", bucket.BucketID),
 	)
 
-	return
+	return bucket, nil
 }
 
-// B2GetBuckets calls authorizeAccount then connects to API to request list of all B2 buckets and information, returns type 'Buckets' and error
-func GetBuckets() (Buckets, error) {
-	// Authorize and Get API Token
-	authorizationResponse := AuthorizeAcct()
+// GetBucket returns the bucket bucketID
+func (c *Client) GetBucket(bucketID string) (Bucket, error) {
+	return c.GetBucketContext(context.Background(), bucketID)
+}
 
-	// Request (POST https://api001.backblazeb2.com/b2api/v1/b2_list_buckets)
-	jsonData := []byte(`{"accountId": "` + authorizationResponse.AccountID + `"}`)
-	body := bytes.NewBuffer(jsonData)
+// GetBucketContext is like GetBucket with a context limiting the request
+func (c *Client) GetBucketContext(ctx context.Context, bucketID string) (Bucket, error) {
+	var buckets Buckets
+	bucketID, err := c.ResolveBucketContext(ctx, bucketID)
+	if err != nil {
+		return Bucket{}, err
+	}
+	reqBody := map[string]string{
+		"accountId": c.Authorization().AccountID,
+		"bucketId":  bucketID,
+	}
+	err = c.apiCall(ctx, "b2_list_buckets", reqBody, &buckets)
+	if err != nil {
+		c.Logger.Warn("Get Bucket Failed.",
+			zap.String("Bucket ID", bucketID),
+			zap.Error(err),
+		)
+		return Bucket{}, err
+	}
+	if len(buckets.Bucket) != 1 {
+		return Bucket{}, fmt.Errorf("Bucket %v not found", bucketID)
+	}
+	return buckets.Bucket[0], nil
+}
 
-	// Create client
-	client := &http.Client{}
+// GetBuckets connects to API to request list of all B2 buckets and information, returns type 'Buckets' and error
+func (c *Client) GetBuckets() (Buckets, error) {
+	return c.GetBucketsContext(context.Background())
+}
 
-	// Create request
-	req, err := http.NewRequest("POST", authorizationResponse.ApiURL+"/b2api/v1/b2_list_buckets", body)
+// GetBucketsContext is like GetBuckets with a context limiting the request
+func (c *Client) GetBucketsContext(ctx context.Context) (Buckets, error) {
+	var buckets Buckets
+	// Request (POST https://api001.backblazeb2.com/b2api/v1/b2_list_buckets)
+	reqBody := map[string]string{"accountId": c.Authorization().AccountID}
+	// Keys restricted to a bucket may only list that bucket
+	if bucketID := c.Authorization().Allowed.BucketID; bucketID != "" {
+		reqBody["bucketId"] = bucketID
+	}
+	err := c.apiCall(ctx, "b2_list_buckets", reqBody, &buckets)
+	if err != nil {
+		c.Logger.Warn("List Buckets Failed.",
+			zap.Error(err),
+		)
+		return buckets, err
+	}
+	for _, bucket := range buckets.Bucket {
+		c.cacheBucket(bucket.BucketName, bucket.BucketID)
+		c.cacheBucket(bucket.BucketID, bucket.BucketID)
+	}
 
-	// Headers
-	req.Header.Add("Authorization", authorizationResponse.AuthorizationToken)
-	req.Header.Add("Content-Type", "application/json; charset=utf-8")
+	return buckets, nil
+}
 
-	// Fetch Request
-	resp, err := client.Do(req)
+// BucketSettings holds the settings of a new bucket, or the settings changed by UpdateBucket
+// where empty fields are left unchanged
+type BucketSettings struct {
+	// BucketType is "allPublic" or "allPrivate"
+	BucketType string
+	// BucketInfo replaces all bucket info if not nil
+	BucketInfo map[string]string
+	// LifecycleRules replaces all lifecycle rules if not nil, an empty slice removes all rules
+	LifecycleRules []LifecycleRule
+	// CORSRules replaces all CORS rules if not nil, an empty slice removes all rules
+	CORSRules []CORSRule
+	// IfRevisionMatches makes the update fail with ErrRevisionConflict unless the bucket is still at
+	// this revision, if not 0
+	IfRevisionMatches int
+}
 
+// UpdateBucket changes the settings of bucket bucketID and returns the updated Bucket
+func (c *Client) UpdateBucket(bucketID string, update BucketSettings) (Bucket, error) {
+	return c.UpdateBucketContext(context.Background(), bucketID, update)
+}
+
+// UpdateBucketContext is like UpdateBucket with a context limiting the request
+func (c *Client) UpdateBucketContext(ctx context.Context, bucketID string, update BucketSettings) (Bucket, error) {
+	var bucket Bucket
+	bucketID, err := c.ResolveBucketContext(ctx, bucketID)
+	if err != nil {
+		return bucket, err
+	}
+	if err := ValidateLifecycleRules(update.LifecycleRules); err != nil {
+		return bucket, err
+	}
+	if err := ValidateCORSRules(update.CORSRules); err != nil {
+		return bucket, err
+	}
+	if err := ValidateBucketInfo(update.BucketInfo); err != nil {
+		return bucket, err
+	}
+	reqBody := map[string]interface{}{
+		"accountId": c.Authorization().AccountID,
+		"bucketId":  bucketID,
+	}
+	if update.LifecycleRules != nil {
+		reqBody["lifecycleRules"] = update.LifecycleRules
+	}
+	if update.CORSRules != nil {
+		reqBody["corsRules"] = update.CORSRules
+	}
+	if update.BucketType != "" {
+		reqBody["bucketType"] = update.BucketType
+	}
+	if update.BucketInfo != nil {
+		reqBody["bucketInfo"] = update.BucketInfo
+	}
+	if update.IfRevisionMatches != 0 {
+		reqBody["ifRevisionMatches"] = update.IfRevisionMatches
+	}
+	err = c.apiCall(ctx, "b2_update_bucket", reqBody, &bucket)
 	if err != nil {
-		logger.Warn("List Buckets Failed.",
+		c.Logger.Warn("Could not update Bucket",
+			zap.String("Bucket ID", bucketID),
 			zap.Error(err),
 		)
+		return bucket, err
 	}
+	c.Logger.Info("Bucket Updated",
+		zap.String("Bucket ID", bucket.BucketID),
+		zap.Int("Revision", bucket.Revision),
+	)
+	return bucket, nil
+}
 
-	// Read Response Body
-	respBody, _ := ioutil.ReadAll(resp.Body)
-	defer resp.Body.Close()
+// DeleteBucket deletes the empty bucket bucketID and returns the deleted Bucket
+func (c *Client) DeleteBucket(bucketID string) (Bucket, error) {
+	return c.DeleteBucketContext(context.Background(), bucketID)
+}
 
-	var apiResponse Response
-	apiResponse = Response{Header: resp.Header, Status: resp.Status, Body: respBody}
-	if resp.Status != "200 OK" {
-		logger.Fatal("Bucket List Request API Error",
-			zap.String("API Resp Status", resp.Status),
-			zap.String("API Resp Body", string(respBody)),
-		)
+// DeleteBucketContext is like DeleteBucket with a context limiting the request
+func (c *Client) DeleteBucketContext(ctx context.Context, bucketID string) (Bucket, error) {
+	var bucket Bucket
+	bucketID, err := c.ResolveBucketContext(ctx, bucketID)
+	if err != nil {
+		return bucket, err
 	}
-	// Parse JSON 'Bucket' Response
-	var buckets Buckets
-	err = json.Unmarshal(apiResponse.Body, &buckets)
+	reqBody := map[string]string{
+		"accountId": c.Authorization().AccountID,
+		"bucketId":  bucketID,
+	}
+	err = c.apiCall(ctx, "b2_delete_bucket", reqBody, &bucket)
 	if err != nil {
-		logger.Fatal("Bucket JSON Parse Failed",
+		c.Logger.Warn("Could not delete Bucket",
+			zap.String("Bucket ID", bucketID),
 			zap.Error(err),
 		)
+		return bucket, err
 	}
+	c.forgetBucket(bucket.BucketID)
+	c.Logger.Info("Bucket Deleted",
+		zap.String("Bucket Name", bucket.BucketName),
+		zap.String("Bucket ID", bucket.BucketID),
+	)
+	return bucket, nil
+}
+
+// EmptyBucket deletes every file version in bucket bucketID and cancels its unfinished large
+// files, so it can be deleted. It returns the number of versions removed.
+func (c *Client) EmptyBucket(bucketID string) (int, error) {
+	return c.EmptyBucketContext(context.Background(), bucketID)
+}
 
-	return buckets, err
+// EmptyBucketContext is like EmptyBucket, cancelling ctx stops removing versions
+func (c *Client) EmptyBucketContext(ctx context.Context, bucketID string) (int, error) {
+	removed := 0
+	bucketID, err := c.ResolveBucketContext(ctx, bucketID)
+	if err != nil {
+		return removed, err
+	}
+	versions := c.ListFileVersionsContext(ctx, bucketID, ListOptions{})
+	for versions.Next() {
+		f := versions.File()
+		if f.Action == "start" {
+			err = c.CancelLargeFileContext(ctx, f.FileID)
+		} else {
+			err = c.DeleteFileVersionContext(ctx, f.FileName, f.FileID)
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, versions.Err()
 }
 
 // PrintBuckets Diplays list of buckets in console
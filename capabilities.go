@@ -0,0 +1,101 @@
+package gopherb2
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Capabilities of application keys
+const (
+	CapListKeys      = "listKeys"
+	CapWriteKeys     = "writeKeys"
+	CapDeleteKeys    = "deleteKeys"
+	CapListBuckets   = "listBuckets"
+	CapWriteBuckets  = "writeBuckets"
+	CapDeleteBuckets = "deleteBuckets"
+	CapListFiles     = "listFiles"
+	CapReadFiles     = "readFiles"
+	CapShareFiles    = "shareFiles"
+	CapWriteFiles    = "writeFiles"
+	CapDeleteFiles   = "deleteFiles"
+)
+
+// operationCapabilities maps API operations to the capability they require
+var operationCapabilities = map[string]string{
+	"b2_create_key":                  CapWriteKeys,
+	"b2_list_keys":                   CapListKeys,
+	"b2_delete_key":                  CapDeleteKeys,
+	"b2_create_bucket":               CapWriteBuckets,
+	"b2_list_buckets":                CapListBuckets,
+	"b2_update_bucket":               CapWriteBuckets,
+	"b2_delete_bucket":               CapDeleteBuckets,
+	"b2_list_file_names":             CapListFiles,
+	"b2_list_file_versions":          CapListFiles,
+	"b2_list_unfinished_large_files": CapListFiles,
+	"b2_get_file_info":               CapReadFiles,
+	"b2_download_file_by_id":         CapReadFiles,
+	"b2_download_file_by_name":       CapReadFiles,
+	"b2_get_download_authorization":  CapShareFiles,
+	"b2_get_upload_url":              CapWriteFiles,
+	"b2_upload_file":                 CapWriteFiles,
+	"b2_start_large_file":            CapWriteFiles,
+	"b2_get_upload_part_url":         CapWriteFiles,
+	"b2_upload_part":                 CapWriteFiles,
+	"b2_finish_large_file":           CapWriteFiles,
+	"b2_cancel_large_file":           CapWriteFiles,
+	"b2_list_parts":                  CapWriteFiles,
+	"b2_copy_file":                   CapWriteFiles,
+	"b2_copy_part":                   CapWriteFiles,
+	"b2_hide_file":                   CapWriteFiles,
+	"b2_delete_file_version":         CapDeleteFiles,
+}
+
+// operationNames maps the operations taking a file name or prefix to the request field holding
+// it, which must start with the name prefix of restricted keys. An absent prefix means all names.
+var operationNames = map[string]string{
+	"b2_list_file_names":             "prefix",
+	"b2_list_file_versions":          "prefix",
+	"b2_list_unfinished_large_files": "namePrefix",
+	"b2_get_download_authorization":  "fileNamePrefix",
+	"b2_download_file_by_name":       "fileName",
+	"b2_upload_file":                 "fileName",
+	"b2_start_large_file":            "fileName",
+	"b2_copy_file":                   "fileName",
+	"b2_hide_file":                   "fileName",
+	"b2_delete_file_version":         "fileName",
+}
+
+// checkCapability returns a *CapabilityError if the key of auth may not call operation with
+// the JSON request body jsonBody, so restricted keys fail early instead of with a 401 from B2
+func checkCapability(auth APIAuthorization, operation string, jsonBody []byte) error {
+	if capability, ok := operationCapabilities[operation]; ok && !auth.Allowed.HasCapability(capability) {
+		return &CapabilityError{Operation: operation, Capability: capability}
+	}
+	nameField, hasName := operationNames[operation]
+	checkBucket := len(auth.Allowed.Buckets) > 0 && operation != "b2_create_bucket"
+	checkName := auth.Allowed.NamePrefix != "" && hasName
+	if !checkBucket && !checkName {
+		return nil
+	}
+	var request map[string]interface{}
+	if json.Unmarshal(jsonBody, &request) != nil {
+		return nil
+	}
+	if bucketID, _ := request["bucketId"].(string); checkBucket && bucketID != "" && !auth.Allowed.AllowsBucket(bucketID) {
+		return &CapabilityError{Operation: operation, BucketID: bucketID}
+	}
+	if name, _ := request[nameField].(string); checkName && !strings.HasPrefix(name, auth.Allowed.NamePrefix) {
+		return &CapabilityError{Operation: operation, FileName: name, NamePrefix: auth.Allowed.NamePrefix}
+	}
+	return nil
+}
+
+// checkFileName returns a *CapabilityError if the key of the client may not call operation for
+// fileName in bucketID, for requests that send the file name outside a JSON body
+func (c *Client) checkFileName(operation string, bucketID string, fileName string) error {
+	jsonBody, err := json.Marshal(map[string]string{"bucketId": bucketID, "fileName": fileName})
+	if err != nil {
+		return err
+	}
+	return checkCapability(c.Authorization(), operation, jsonBody)
+}
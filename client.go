@@ -0,0 +1,278 @@
+package gopherb2
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/uber-go/zap"
+)
+
+// Client is a Backblaze B2 API client for a single account. It authorizes once, shares the
+// authorization between all calls and re-authorizes only when B2 reports the token expired.
+// A Client is safe for concurrent use by multiple goroutines.
+type Client struct {
+	// HTTPClient is used to send all requests, http.DefaultClient is used if nil
+	HTTPClient *http.Client
+	// Logger receives all log output of the client
+	Logger zap.Logger
+	// UploadConcurrency is the number of large file parts uploaded or copied simultaneously
+	UploadConcurrency int
+	// UploadMemoryBudget is the memory in bytes large file uploads from files read parts through,
+	// split between the parts in flight. Parts are read straight from the source file as they are
+	// sent, a larger budget reads it in fewer, longer reads. DefaultUploadMemoryBudget if 0.
+	UploadMemoryBudget int64
+	// CopyPartSize is the size in bytes of the parts of large file copies, copies up to this size
+	// are made with a single request. Parts other than the last must be at least the
+	// absoluteMinimumPartSize of the account.
+	CopyPartSize int64
+	// DownloadConcurrency is the number of ranges fetched simultaneously by parallel downloads
+	DownloadConcurrency int
+	// DownloadRangeSize is the size in bytes of the ranges fetched by parallel downloads
+	DownloadRangeSize int64
+	// Retry is the policy for retrying failed API calls and uploads
+	Retry RetryPolicy
+	// StatePath is the BoltDB file the progress of large file uploads is saved in, so an upload
+	// killed part way continues where it stopped when the same file is uploaded again. Progress
+	// is not saved if empty.
+	StatePath string
+
+	config Configuration
+	mu     sync.RWMutex
+	auth   APIAuthorization
+	// authCall is the authorization in progress, shared by all callers needing a new token
+	authCall *authCall
+
+	// bucketIDs caches the IDs of bucket names resolved by ResolveBucket
+	bucketsMu sync.Mutex
+	bucketIDs map[string]string
+
+	// stateDB is the database at StatePath, opened by the first large file upload
+	stateMu sync.Mutex
+	stateDB *boltDB
+}
+
+// NewClient authorizes the account in config with the B2 API and returns a Client using that authorization
+func NewClient(config Configuration) (*Client, error) {
+	return NewClientContext(context.Background(), config)
+}
+
+// NewClientContext is like NewClient, ctx limits the initial authorization
+func NewClientContext(ctx context.Context, config Configuration) (*Client, error) {
+	if config.AcctID == "" {
+		return nil, errors.New("Account ID not set. Update with your Account Id from Backblaze Settings.")
+	} else if config.AppID == "" {
+		return nil, errors.New("Application ID not set. Update with your Application Id from Backblaze Settings.")
+	}
+	if config.APIURL == "" {
+		config.APIURL = DefaultAPIURL
+	}
+	if config.APIVersion == "" {
+		config.APIVersion = DefaultAPIVersion
+	}
+	if err := checkAPIVersion(config.APIVersion); err != nil {
+		return nil, err
+	}
+	c := &Client{
+		Logger:              logLevel(),
+		UploadConcurrency:   4,
+		UploadMemoryBudget:  DefaultUploadMemoryBudget,
+		DownloadConcurrency: 4,
+		DownloadRangeSize:   DefaultDownloadRangeSize,
+		CopyPartSize:        DefaultCopyPartSize,
+		Retry:               DefaultRetryPolicy,
+		config:              config,
+	}
+	if _, err := c.reauthorize(ctx, "", true); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close closes the database at c.StatePath if an upload opened it
+func (c *Client) Close() error {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	if c.stateDB == nil {
+		return nil
+	}
+	err := c.stateDB.Close()
+	c.stateDB = nil
+	return err
+}
+
+// uploadStateDB returns the database at c.StatePath, opening it on first use
+func (c *Client) uploadStateDB() (*boltDB, error) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	if c.stateDB != nil {
+		return c.stateDB, nil
+	}
+	db, err := openDB(c.StatePath)
+	if err != nil {
+		if db != nil {
+			db.Close()
+		}
+		return nil, err
+	}
+	c.stateDB = db
+	return db, nil
+}
+
+// Authorization returns the authorization currently used by the client
+func (c *Client) Authorization() APIAuthorization {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.auth
+}
+
+// authorization returns the cached authorization, authorizing first if there is none yet. It is
+// called within the retries of a request, so authorizing is not retried itself.
+func (c *Client) authorization(ctx context.Context) (APIAuthorization, error) {
+	auth := c.Authorization()
+	if auth.AuthorizationToken != "" {
+		return auth, nil
+	}
+	return c.reauthorize(ctx, "", false)
+}
+
+// authCall is an authorization shared by the callers waiting for it, done is closed once
+// auth and err are set
+type authCall struct {
+	done chan struct{}
+	auth APIAuthorization
+	err  error
+}
+
+// reauthorize replaces the cached authorization, unless another goroutine already replaced
+// the expired token. Concurrent callers share a single authorization, which runs without
+// holding c.mu so Authorization and calls with a valid token are not blocked by its retries.
+// Callers retrying the request that needs the authorization pass retry false, so authorizing is
+// attempted once per attempt of the request instead of a full retry policy each time.
+func (c *Client) reauthorize(ctx context.Context, expiredToken string, retry bool) (APIAuthorization, error) {
+	for {
+		c.mu.Lock()
+		if c.auth.AuthorizationToken != "" && c.auth.AuthorizationToken != expiredToken {
+			auth := c.auth
+			c.mu.Unlock()
+			return auth, nil
+		}
+		if call := c.authCall; call != nil {
+			c.mu.Unlock()
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return APIAuthorization{}, ctx.Err()
+			}
+			// Authorize again if only the context of the caller that authorized was done
+			if (errors.Is(call.err, context.Canceled) || errors.Is(call.err, context.DeadlineExceeded)) && ctx.Err() == nil {
+				continue
+			}
+			return call.auth, call.err
+		}
+		call := &authCall{done: make(chan struct{})}
+		c.authCall = call
+		c.mu.Unlock()
+
+		if retry {
+			_, _, call.err = c.retry(ctx, "b2_authorize_account", func() (Response, error) {
+				var err error
+				call.auth, err = c.authorizeAccount(ctx)
+				return Response{}, err
+			})
+		} else {
+			call.auth, call.err = c.authorizeAccount(ctx)
+		}
+		c.mu.Lock()
+		if call.err == nil {
+			c.auth = call.auth
+		}
+		c.authCall = nil
+		c.mu.Unlock()
+		close(call.done)
+		return call.auth, call.err
+	}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// apiCall posts request as JSON to the named B2 API operation with the cached authorization and decodes
+// the JSON response into response, unless it is nil. If B2 responds that the authorization token expired
+// the client re-authorizes and sends the request once more, other failures are retried following c.Retry
+// until ctx is done.
+func (c *Client) apiCall(ctx context.Context, operation string, request, response interface{}) error {
+	jsonBody, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	apiResponse, _, err := c.retry(ctx, operation, func() (Response, error) {
+		auth, err := c.authorization(ctx)
+		if err != nil {
+			return Response{}, err
+		}
+		if err := checkCapability(auth, operation, jsonBody); err != nil {
+			return Response{}, err
+		}
+		apiResponse, err := c.post(ctx, auth, operation, jsonBody)
+		if errors.Is(err, ErrExpiredAuthToken) {
+			c.Logger.Info("Authorization token expired, re-authorizing",
+				zap.String("Operation", operation),
+			)
+			auth, err = c.reauthorize(ctx, auth.AuthorizationToken, false)
+			if err != nil {
+				return Response{}, err
+			}
+			apiResponse, err = c.post(ctx, auth, operation, jsonBody)
+		}
+		return apiResponse, err
+	})
+	if err != nil {
+		return err
+	}
+	if response == nil {
+		return nil
+	}
+	return json.Unmarshal(apiResponse.Body, response)
+}
+
+func (c *Client) post(ctx context.Context, auth APIAuthorization, operation string, jsonBody []byte) (Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.apiEndpoint(auth, operation), bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return Response{}, err
+	}
+	// Headers
+	req.Header.Add("Authorization", auth.AuthorizationToken)
+	req.Header.Add("Content-Type", "application/json; charset=utf-8")
+
+	return c.do(req)
+}
+
+// do sends req and reads the response body, returning an *APIError if the response is unsuccessful
+func (c *Client) do(req *http.Request) (Response, error) {
+	// Fetch Request
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return Response{}, err
+	}
+	defer resp.Body.Close()
+
+	// Read Response Body
+	respBody, err := ioutil.ReadAll(resp.Body)
+	apiResponse := Response{Header: resp.Header, Status: resp.Status, StatusCode: resp.StatusCode, Body: respBody}
+	if err != nil {
+		return apiResponse, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return apiResponse, newAPIError(resp.StatusCode, respBody)
+	}
+	return apiResponse, nil
+}
@@ -0,0 +1,258 @@
+package gopherb2
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/uber-go/zap"
+)
+
+// Metadata directives of CopyOptions
+const (
+	// MetadataCopy keeps the content type and file info of the source file
+	MetadataCopy = "COPY"
+	// MetadataReplace sets the content type and file info given in CopyOptions instead
+	MetadataReplace = "REPLACE"
+)
+
+// DefaultCopyPartSize is the size of the parts of large file copies made by clients returned from
+// NewClient
+const DefaultCopyPartSize = 1000 * 1000 * 1000
+
+// MaxCopyPartSize is the largest range B2 copies with a single b2_copy_file or b2_copy_part call
+const MaxCopyPartSize = 5 * 1000 * 1000 * 1000
+
+// CopyOptions changes how CopyFile copies a file
+type CopyOptions struct {
+	// DestinationBucket is the bucket name or ID the copy is created in, the bucket of the source
+	// file if empty. Both buckets must belong to the same account.
+	DestinationBucket string
+	// Offset is the first byte of the source file copied
+	Offset int64
+	// Length is the number of bytes copied, all bytes after Offset if 0
+	Length int64
+	// MetadataDirective is MetadataCopy, the default, or MetadataReplace
+	MetadataDirective string
+	// ContentType is the content type of the copy with MetadataReplace, b2/x-auto if empty
+	ContentType string
+	// FileInfo is the file info of the copy with MetadataReplace
+	FileInfo map[string]string
+}
+
+// CopyFile copies the file version sourceFileID to fileName without downloading it. Copies of a
+// range keep the metadata of the source except its SHA1 and Blake2b file info. Copies larger
+// than c.CopyPartSize are made as large files from parts copied by c.UploadConcurrency
+// b2_copy_part calls at a time, and cancelled if any part fails.
+func (c *Client) CopyFile(sourceFileID string, fileName string, opts CopyOptions) (File, error) {
+	return c.CopyFileContext(context.Background(), sourceFileID, fileName, opts)
+}
+
+// CopyFileContext is like CopyFile, cancelling ctx stops the copy
+func (c *Client) CopyFileContext(ctx context.Context, sourceFileID string, fileName string, opts CopyOptions) (File, error) {
+	var copied File
+	if opts.MetadataDirective == "" {
+		opts.MetadataDirective = MetadataCopy
+	}
+	if opts.MetadataDirective != MetadataCopy && opts.MetadataDirective != MetadataReplace {
+		return copied, fmt.Errorf("Invalid metadata directive %q, must be %v or %v", opts.MetadataDirective, MetadataCopy, MetadataReplace)
+	}
+	if opts.MetadataDirective == MetadataReplace && opts.ContentType == "" {
+		opts.ContentType = "b2/x-auto"
+	}
+	destBucketID := ""
+	if opts.DestinationBucket != "" {
+		var err error
+		if destBucketID, err = c.ResolveBucketContext(ctx, opts.DestinationBucket); err != nil {
+			return copied, err
+		}
+	}
+	partSize := c.CopyPartSize
+	if partSize <= 0 || partSize > MaxCopyPartSize {
+		partSize = DefaultCopyPartSize
+	}
+
+	length := opts.Length
+	partial := opts.Offset > 0 || opts.Length > 0
+	if length == 0 || length > partSize || (partial && opts.MetadataDirective == MetadataCopy) {
+		// The size of the source decides between a single copy and a large file
+		source, err := c.GetFileInfoContext(ctx, sourceFileID)
+		if err != nil {
+			return copied, err
+		}
+		if length == 0 {
+			length = source.ContentLength - opts.Offset
+		}
+		if opts.MetadataDirective == MetadataCopy && (opts.Offset > 0 || length < source.ContentLength) {
+			// The hashes of the whole source do not match a part of it
+			opts.MetadataDirective, opts.ContentType = MetadataReplace, source.ContentType
+			opts.FileInfo = make(map[string]string)
+			for key, value := range source.FileInfo {
+				if key != "large_file_sha1" && key != "content-blake2b" {
+					opts.FileInfo[key] = value
+				}
+			}
+		}
+		if length > partSize {
+			return c.copyLargeFile(ctx, source, fileName, destBucketID, opts, length, partSize)
+		}
+	}
+
+	reqBody := map[string]interface{}{
+		"sourceFileId":      sourceFileID,
+		"fileName":          fileName,
+		"metadataDirective": opts.MetadataDirective,
+	}
+	if destBucketID != "" {
+		reqBody["destinationBucketId"] = destBucketID
+	}
+	if partial {
+		reqBody["range"] = copyRange(opts.Offset, length)
+	}
+	if opts.MetadataDirective == MetadataReplace {
+		reqBody["contentType"] = opts.ContentType
+		if opts.FileInfo != nil {
+			reqBody["fileInfo"] = opts.FileInfo
+		}
+	}
+	err := c.apiCall(ctx, "b2_copy_file", reqBody, &copied)
+	if err != nil {
+		c.Logger.Warn("Could not copy file",
+			zap.String("Source File ID", sourceFileID),
+			zap.String("Filename", fileName),
+			zap.Error(err),
+		)
+		return copied, err
+	}
+	c.Logger.Info("File Copied",
+		zap.String("Source File ID", sourceFileID),
+		zap.String("Filename", copied.FileName),
+		zap.String("File ID", copied.FileID),
+	)
+	return copied, nil
+}
+
+// copyLargeFile copies length bytes of source from opts.Offset to a new large file made of parts of
+// partSize bytes, cancelling the large file if a part cannot be copied
+func (c *Client) copyLargeFile(ctx context.Context, source File, fileName string, destBucketID string, opts CopyOptions, length int64, partSize int64) (File, error) {
+	var started File
+	bucketID := destBucketID
+	if bucketID == "" {
+		bucketID = source.BucketID
+	}
+	contentType, fileInfo := opts.ContentType, opts.FileInfo
+	if opts.MetadataDirective == MetadataCopy {
+		contentType, fileInfo = source.ContentType, source.FileInfo
+	}
+	if fileInfo == nil {
+		fileInfo = map[string]string{}
+	}
+	reqBody := map[string]interface{}{
+		"bucketId":    bucketID,
+		"fileName":    fileName,
+		"contentType": contentType,
+		"fileInfo":    fileInfo,
+	}
+	if err := c.apiCall(ctx, "b2_start_large_file", reqBody, &started); err != nil {
+		c.Logger.Warn("Invalid response to start large file request",
+			zap.Error(err),
+		)
+		return started, err
+	}
+
+	count := int((length + partSize - 1) / partSize)
+	partSha1Array := make([]string, count)
+	concurrency := c.UploadConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	c.Logger.Info("Beginning Large File Copy",
+		zap.String("Source File ID", source.FileID),
+		zap.String("B2 File ID", started.FileID),
+		zap.Int64("Size", length),
+		zap.Int("Parts", count),
+	)
+
+	copyCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	parts := make(chan int)
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var partErr error
+	for i := 0; i < concurrency && i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range parts {
+				start := opts.Offset + int64(index)*partSize
+				size := partSize
+				if end := int64(index+1) * partSize; end > length {
+					size = length - int64(index)*partSize
+				}
+				sha1, err := c.copyPart(copyCtx, source.FileID, started.FileID, index+1, start, size)
+				if err != nil {
+					errOnce.Do(func() {
+						partErr = fmt.Errorf("part %d: %w", index+1, err)
+						cancel()
+					})
+					continue
+				}
+				partSha1Array[index] = sha1
+			}
+		}()
+	}
+	for index := 0; index < count && copyCtx.Err() == nil; index++ {
+		select {
+		case parts <- index:
+		case <-copyCtx.Done():
+		}
+	}
+	close(parts)
+	wg.Wait()
+	if partErr == nil {
+		partErr = ctx.Err()
+	}
+	if partErr != nil {
+		c.Logger.Warn("Large file copy failed, cancelling large file",
+			zap.String("B2 File ID", started.FileID),
+			zap.Error(partErr),
+		)
+		// The parts copied so far are useless without the others, ctx may already be cancelled
+		c.CancelLargeFileContext(context.Background(), started.FileID)
+		return started, partErr
+	}
+	return c.finishLargeFile(ctx, started.FileID, partSha1Array)
+}
+
+// copyPart copies length bytes of sourceFileID from offset as part partNumber of the large file
+// largeFileID, returning the SHA1 of the part
+func (c *Client) copyPart(ctx context.Context, sourceFileID string, largeFileID string, partNumber int, offset int64, length int64) (string, error) {
+	var part struct {
+		ContentSha1 string `json:"contentSha1"`
+	}
+	reqBody := map[string]interface{}{
+		"sourceFileId": sourceFileID,
+		"largeFileId":  largeFileID,
+		"partNumber":   partNumber,
+		"range":        copyRange(offset, length),
+	}
+	err := c.apiCall(ctx, "b2_copy_part", reqBody, &part)
+	if err != nil {
+		c.Logger.Warn("Part Copy Failed",
+			zap.String("B2 File ID", largeFileID),
+			zap.Int("B2 Part #", partNumber),
+			zap.Error(err),
+		)
+		return "", err
+	}
+	c.Logger.Debug("Part Copied",
+		zap.String("B2 File ID", largeFileID),
+		zap.Int("B2 Part #", partNumber),
+	)
+	return part.ContentSha1, nil
+}
+
+// copyRange formats length bytes from offset as the range of a copy request
+func copyRange(offset int64, length int64) string {
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+}
@@ -0,0 +1,142 @@
+package gopherb2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// CORSRule allows browsers on AllowedOrigins to call AllowedOperations on a bucket
+type CORSRule struct {
+	CorsRuleName      string   `json:"corsRuleName"`
+	AllowedOrigins    []string `json:"allowedOrigins"`
+	AllowedOperations []string `json:"allowedOperations"`
+	AllowedHeaders    []string `json:"allowedHeaders,omitempty"`
+	ExposeHeaders     []string `json:"exposeHeaders,omitempty"`
+	MaxAgeSeconds     int      `json:"maxAgeSeconds"`
+}
+
+// B2 limits of CORS rules
+const (
+	MaxCORSRules         = 100
+	MaxCORSMaxAgeSeconds = 86400
+)
+
+// corsOperations are the operations a CORS rule may allow
+var corsOperations = map[string]bool{
+	"b2_download_file_by_name": true,
+	"b2_download_file_by_id":   true,
+	"b2_upload_file":           true,
+	"b2_upload_part":           true,
+	"s3_delete":                true,
+	"s3_get":                   true,
+	"s3_head":                  true,
+	"s3_post":                  true,
+	"s3_put":                   true,
+}
+
+// ValidateCORSRules checks rules against the limits of B2, so invalid rules are rejected before
+// anything is sent
+func ValidateCORSRules(rules []CORSRule) error {
+	if len(rules) > MaxCORSRules {
+		return fmt.Errorf("At most %d CORS rules are allowed, got %d", MaxCORSRules, len(rules))
+	}
+	names := make(map[string]bool)
+	for _, rule := range rules {
+		if err := validateCORSRuleName(rule.CorsRuleName); err != nil {
+			return err
+		}
+		if names[rule.CorsRuleName] {
+			return fmt.Errorf("CORS rule name %q is used more than once", rule.CorsRuleName)
+		}
+		names[rule.CorsRuleName] = true
+
+		if len(rule.AllowedOrigins) == 0 {
+			return fmt.Errorf("CORS rule %q needs at least one allowed origin", rule.CorsRuleName)
+		}
+		for _, origin := range rule.AllowedOrigins {
+			if origin == "" || strings.Count(origin, "*") > 1 {
+				return fmt.Errorf("CORS rule %q has invalid origin %q, use \"*\" or a URL with at most one \"*\"", rule.CorsRuleName, origin)
+			}
+		}
+		if len(rule.AllowedOperations) == 0 {
+			return fmt.Errorf("CORS rule %q needs at least one allowed operation", rule.CorsRuleName)
+		}
+		for _, operation := range rule.AllowedOperations {
+			if !corsOperations[operation] {
+				return fmt.Errorf("CORS rule %q has invalid operation %q", rule.CorsRuleName, operation)
+			}
+		}
+		for _, header := range rule.AllowedHeaders {
+			if header == "" || strings.Count(header, "*") > 1 {
+				return fmt.Errorf("CORS rule %q has invalid allowed header %q", rule.CorsRuleName, header)
+			}
+		}
+		for _, header := range rule.ExposeHeaders {
+			if header == "" || strings.Contains(header, "*") {
+				return fmt.Errorf("CORS rule %q has invalid expose header %q, wildcards are not allowed", rule.CorsRuleName, header)
+			}
+		}
+		if rule.MaxAgeSeconds < 0 || rule.MaxAgeSeconds > MaxCORSMaxAgeSeconds {
+			return fmt.Errorf("CORS rule %q max age must be 0 to %d seconds", rule.CorsRuleName, MaxCORSMaxAgeSeconds)
+		}
+	}
+	return nil
+}
+
+// validateCORSRuleName checks name is 6 to 50 letters, digits and dashes, not starting with "b2-"
+func validateCORSRuleName(name string) error {
+	if len(name) < 6 || len(name) > 50 {
+		return fmt.Errorf("CORS rule name %q must be 6 to 50 characters", name)
+	}
+	if strings.HasPrefix(strings.ToLower(name), "b2-") {
+		return fmt.Errorf("CORS rule name %q must not start with \"b2-\"", name)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return fmt.Errorf("CORS rule name %q may only contain letters, digits and \"-\"", name)
+		}
+	}
+	return nil
+}
+
+// LoadCORSRules reads CORS rules from a JSON or TOML file. JSON files hold an array of rules or an
+// object with a "corsRules" array, TOML files a [[corsRules]] table for every rule.
+func LoadCORSRules(path string) ([]CORSRule, error) {
+	rules := []CORSRule{}
+	if strings.ToLower(filepath.Ext(path)) == ".toml" {
+		v := viper.New()
+		v.SetConfigFile(path)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, err
+		}
+		if err := v.UnmarshalKey("corsRules", &rules); err != nil {
+			return nil, err
+		}
+		return rules, ValidateCORSRules(rules)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		err = json.Unmarshal(data, &rules)
+	} else {
+		var bucket struct {
+			CORSRules []CORSRule `json:"corsRules"`
+		}
+		err = json.Unmarshal(data, &bucket)
+		if bucket.CORSRules != nil {
+			rules = bucket.CORSRules
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rules, ValidateCORSRules(rules)
+}
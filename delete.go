@@ -0,0 +1,153 @@
+package gopherb2
+
+import (
+	"context"
+	"sync"
+
+	"github.com/uber-go/zap"
+)
+
+// DeleteOptions selects the file versions removed by DeleteFiles
+type DeleteOptions struct {
+	// Prefix selects the files with names starting with Prefix, all files of the bucket if empty
+	Prefix string
+	// FileName selects only the file named exactly FileName instead of Prefix
+	FileName string
+	// AllVersions removes every version of the selected files, otherwise only their latest version,
+	// which makes the previous version of each file visible again. Files below Prefix whose latest
+	// version is a hide marker are skipped then, so prefix deletes do not reveal hidden files.
+	AllVersions bool
+	// DryRun only reports the versions that would be removed
+	DryRun bool
+	// Concurrency is the number of versions removed at the same time, 4 if 0
+	Concurrency int
+	// Removed is called for every version removed, or that would be with DryRun, with the error
+	// removing it. Calls are not concurrent.
+	Removed func(f File, err error)
+}
+
+// DeleteSummary counts the file versions handled by DeleteFiles
+type DeleteSummary struct {
+	// Removed is the number of versions removed, or that would be with DryRun
+	Removed int
+	// Failed is the number of versions that could not be removed
+	Failed int
+	// Bytes is the size of the versions removed
+	Bytes int64
+}
+
+// DeleteFiles removes the versions of the files in bucket selected by opts, listing them with
+// b2_list_file_versions. Unfinished large files are cancelled. Versions that cannot be removed do
+// not stop the others, the first error is returned after all selected versions were tried.
+func (c *Client) DeleteFiles(bucket string, opts DeleteOptions) (DeleteSummary, error) {
+	return c.DeleteFilesContext(context.Background(), bucket, opts)
+}
+
+// DeleteFilesContext is like DeleteFiles, cancelling ctx stops removing versions
+func (c *Client) DeleteFilesContext(ctx context.Context, bucket string, opts DeleteOptions) (DeleteSummary, error) {
+	var summary DeleteSummary
+	bucketID, err := c.ResolveBucketContext(ctx, bucket)
+	if err != nil {
+		return summary, err
+	}
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 4
+	}
+	listOpts := ListOptions{Prefix: opts.Prefix}
+	if opts.FileName != "" {
+		listOpts = ListOptions{Prefix: opts.FileName, StartFileName: opts.FileName}
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+	done := func(f File, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			summary.Failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else {
+			summary.Removed++
+			summary.Bytes += f.ContentLength
+		}
+		if opts.Removed != nil {
+			opts.Removed(f, err)
+		}
+	}
+
+	selected := make(chan File)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range selected {
+				var err error
+				switch {
+				case opts.DryRun:
+				case f.Action == "start":
+					err = c.CancelLargeFileContext(ctx, f.FileID)
+				default:
+					err = c.DeleteFileVersionContext(ctx, f.FileName, f.FileID)
+				}
+				done(f, err)
+			}
+		}()
+	}
+
+	versions := c.ListFileVersionsContext(ctx, bucketID, listOpts)
+	lastName := ""
+	first := true
+	for versions.Next() {
+		f := versions.File()
+		if opts.FileName != "" && f.FileName != opts.FileName {
+			break
+		}
+		// Versions of a file name are listed newest first
+		latest := first || f.FileName != lastName
+		first, lastName = false, f.FileName
+		if !latest && !opts.AllVersions {
+			continue
+		}
+		if latest && !opts.AllVersions && opts.FileName == "" && f.Action == "hide" {
+			c.Logger.Debug("Skipping hidden file",
+				zap.String("Filename", f.FileName),
+			)
+			continue
+		}
+		select {
+		case selected <- f:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(selected)
+	wg.Wait()
+	if err := versions.Err(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	if err := ctx.Err(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	if firstErr != nil {
+		c.Logger.Warn("Could not delete all file versions",
+			zap.String("Bucket ID", bucketID),
+			zap.Int("Removed", summary.Removed),
+			zap.Int("Failed", summary.Failed),
+			zap.Error(firstErr),
+		)
+		return summary, firstErr
+	}
+	c.Logger.Info("File versions deleted",
+		zap.String("Bucket ID", bucketID),
+		zap.String("Prefix", listOpts.Prefix),
+		zap.Int("Removed", summary.Removed),
+		zap.Bool("Dry run", opts.DryRun),
+	)
+	return summary, nil
+}
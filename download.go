@@ -0,0 +1,304 @@
+package gopherb2
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	blake2b "github.com/dsjr2006/blake2b-simd"
+	"github.com/uber-go/zap"
+)
+
+// DownloadedFile describes a file downloaded from B2
+type DownloadedFile struct {
+	FileID      string
+	FileName    string
+	ContentType string
+	// ContentLength is the size of the whole file, even if only the data after an offset was downloaded
+	ContentLength int64
+	// ContentSha1 is the SHA1 of the file, "none" for large files
+	ContentSha1 string
+	// FileInfo holds the file info set on upload, such as large_file_sha1 and content-blake2b
+	FileInfo        map[string]string
+	UploadTimestamp int64
+}
+
+// DownloadOptions changes how a file is downloaded
+type DownloadOptions struct {
+	// Offset is the number of bytes of the file already downloaded, only the data after them is
+	// requested and written, to resume an interrupted download
+	Offset int64
+	// Downloaded is read for the first Offset bytes of the file, so the whole file is still verified
+	// when resuming. Without it only downloads starting at offset 0 are verified.
+	Downloaded io.Reader
+}
+
+// DownloadFileByID downloads the file version fileID into w, verifying the data against the
+// SHA1 and Blake2b hashes of the file
+func (c *Client) DownloadFileByID(fileID string, w io.Writer, opts DownloadOptions) (DownloadedFile, error) {
+	return c.DownloadFileByIDContext(context.Background(), fileID, w, opts)
+}
+
+// DownloadFileByIDContext is like DownloadFileByID with a context limiting the download
+func (c *Client) DownloadFileByIDContext(ctx context.Context, fileID string, w io.Writer, opts DownloadOptions) (DownloadedFile, error) {
+	downloadURL := func(auth APIAuthorization) string {
+		return c.downloadEndpoint(auth, "b2_download_file_by_id") + "?fileId=" + url.QueryEscape(fileID)
+	}
+	return c.download(ctx, "b2_download_file_by_id", "", downloadURL, w, opts)
+}
+
+// DownloadFileByName downloads the latest version of fileName in bucket into w, verifying the data
+// against the SHA1 and Blake2b hashes of the file. Files of private buckets are downloaded with the
+// authorization of the client.
+func (c *Client) DownloadFileByName(bucket string, fileName string, w io.Writer, opts DownloadOptions) (DownloadedFile, error) {
+	return c.DownloadFileByNameContext(context.Background(), bucket, fileName, w, opts)
+}
+
+// DownloadFileByNameContext is like DownloadFileByName with a context limiting the download
+func (c *Client) DownloadFileByNameContext(ctx context.Context, bucket string, fileName string, w io.Writer, opts DownloadOptions) (DownloadedFile, error) {
+	bucketID, err := c.ResolveBucketContext(ctx, bucket)
+	if err != nil {
+		return DownloadedFile{}, err
+	}
+	if err := c.checkFileName("b2_download_file_by_name", bucketID, fileName); err != nil {
+		return DownloadedFile{}, err
+	}
+	bucketName, err := c.bucketName(ctx, bucketID)
+	if err != nil {
+		return DownloadedFile{}, err
+	}
+	downloadURL := func(auth APIAuthorization) string {
+		return downloadByNameURL(auth, bucketName, fileName)
+	}
+	return c.download(ctx, "b2_download_file_by_name", bucketID, downloadURL, w, opts)
+}
+
+// bucketName returns the name of bucketID, looking up buckets not yet cached by name
+func (c *Client) bucketName(ctx context.Context, bucketID string) (string, error) {
+	c.bucketsMu.Lock()
+	for name, id := range c.bucketIDs {
+		if id == bucketID && name != bucketID {
+			c.bucketsMu.Unlock()
+			return name, nil
+		}
+	}
+	c.bucketsMu.Unlock()
+	bucket, err := c.GetBucketContext(ctx, bucketID)
+	if err != nil {
+		return "", err
+	}
+	c.cacheBucket(bucket.BucketName, bucket.BucketID)
+	return bucket.BucketName, nil
+}
+
+// download streams the file at downloadURL into w and verifies it
+func (c *Client) download(ctx context.Context, operation string, bucketID string, downloadURL func(APIAuthorization) string, w io.Writer, opts DownloadOptions) (DownloadedFile, error) {
+	sha1Hash, blake2bHash := sha1.New(), blake2b.New512()
+	hashes := io.MultiWriter(sha1Hash, blake2bHash)
+	verify := opts.Offset == 0 || opts.Downloaded != nil
+	if opts.Offset > 0 && opts.Downloaded != nil {
+		if _, err := io.CopyN(hashes, opts.Downloaded, opts.Offset); err != nil {
+			return DownloadedFile{}, fmt.Errorf("Could not read the %d bytes already downloaded: %v", opts.Offset, err)
+		}
+	}
+
+	file, written, err := c.fetch(ctx, operation, bucketID, downloadURL, io.MultiWriter(hashes, w), opts.Offset, 0)
+	if err != nil {
+		c.Logger.Warn("Download failed",
+			zap.String("Filename", file.FileName),
+			zap.Int64("Bytes written", written),
+			zap.Error(err),
+		)
+		return file, err
+	}
+	if verify {
+		if err := verifyDownload(file, sha1Hash, blake2bHash); err != nil {
+			c.Logger.Warn("Downloaded file does not match its hash",
+				zap.String("Filename", file.FileName),
+				zap.String("File ID", file.FileID),
+				zap.Error(err),
+			)
+			return file, err
+		}
+	}
+	c.Logger.Info("File Downloaded",
+		zap.String("Filename", file.FileName),
+		zap.String("File ID", file.FileID),
+		zap.Int64("Bytes written", written),
+	)
+	return file, nil
+}
+
+// fetch writes length bytes of the file at downloadURL starting at offset into w, all bytes after
+// offset if length is 0, and returns the file described by the response and the bytes written. A
+// request failing midway is retried following c.Retry from the first byte not yet written, as long
+// as B2 still serves the same file version.
+func (c *Client) fetch(ctx context.Context, operation string, bucketID string, downloadURL func(APIAuthorization) string, w io.Writer, offset int64, length int64) (DownloadedFile, int64, error) {
+	var file DownloadedFile
+	checkBody, err := json.Marshal(map[string]string{"bucketId": bucketID})
+	if err != nil {
+		return file, 0, err
+	}
+	dst := &downloadWriter{w: w}
+	written := int64(0)
+	_, _, err = c.retry(ctx, operation, func() (Response, error) {
+		auth, err := c.authorization(ctx)
+		if err != nil {
+			return Response{}, err
+		}
+		if err := checkCapability(auth, operation, checkBody); err != nil {
+			return Response{}, err
+		}
+		remaining := int64(0)
+		if length > 0 {
+			remaining = length - written
+		}
+		resp, err := c.get(ctx, auth, downloadURL(auth), offset+written, remaining)
+		if errors.Is(err, ErrExpiredAuthToken) {
+			c.Logger.Info("Authorization token expired, re-authorizing",
+				zap.String("Operation", operation),
+			)
+			if auth, err = c.reauthorize(ctx, auth.AuthorizationToken, false); err != nil {
+				return Response{}, err
+			}
+			resp, err = c.get(ctx, auth, downloadURL(auth), offset+written, remaining)
+		}
+		if err != nil {
+			return Response{}, err
+		}
+		defer resp.Body.Close()
+
+		current := downloadedFile(resp)
+		if file.FileID != "" && current.FileID != file.FileID {
+			return Response{}, &APIError{Status: http.StatusConflict, Code: "file_changed", Message: "file " + file.FileName + " was replaced during the download"}
+		}
+		file = current
+		n, err := io.Copy(dst, resp.Body)
+		written += n
+		return Response{}, err
+	})
+	var writeErr *downloadWriteError
+	if errors.As(err, &writeErr) {
+		err = writeErr.err
+	}
+	return file, written, err
+}
+
+// downloadWriter reports errors writing the downloaded data as *downloadWriteError
+type downloadWriter struct {
+	w io.Writer
+}
+
+func (d *downloadWriter) Write(p []byte) (int, error) {
+	n, err := d.w.Write(p)
+	if err != nil {
+		return n, &downloadWriteError{err: err}
+	}
+	return n, nil
+}
+
+// downloadWriteError is an error of the writer a download is written to, which is not retried
+type downloadWriteError struct {
+	err error
+}
+
+func (e *downloadWriteError) Error() string {
+	return e.err.Error()
+}
+
+// get requests length bytes of downloadURL from offset on, or all bytes after offset if length is 0,
+// returning the response if its status is successful
+func (c *Client) get(ctx context.Context, auth APIAuthorization, downloadURL string, offset int64, length int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", downloadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", auth.AuthorizationToken)
+	if length > 0 {
+		req.Header.Add("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset > 0 {
+		req.Header.Add("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, newAPIError(resp.StatusCode, body)
+	}
+	if (offset > 0 || length > 0) && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, &APIError{Status: resp.StatusCode, Code: "range_not_supported", Message: "download did not resume at the requested offset"}
+	}
+	return resp, nil
+}
+
+// downloadedFile reads the file described by the headers of a download response
+func downloadedFile(resp *http.Response) DownloadedFile {
+	file := DownloadedFile{
+		FileID:        resp.Header.Get("X-Bz-File-Id"),
+		ContentType:   resp.Header.Get("Content-Type"),
+		ContentLength: resp.ContentLength,
+		ContentSha1:   resp.Header.Get("X-Bz-Content-Sha1"),
+		FileInfo:      make(map[string]string),
+	}
+	file.FileName, _ = url.QueryUnescape(resp.Header.Get("X-Bz-File-Name"))
+	file.UploadTimestamp, _ = strconv.ParseInt(resp.Header.Get("X-Bz-Upload-Timestamp"), 10, 64)
+	// Content-Range is "bytes first-last/size" for ranged downloads
+	if contentRange := resp.Header.Get("Content-Range"); contentRange != "" {
+		if i := strings.LastIndex(contentRange, "/"); i >= 0 {
+			if size, err := strconv.ParseInt(contentRange[i+1:], 10, 64); err == nil {
+				file.ContentLength = size
+			}
+		}
+	}
+	for key := range resp.Header {
+		if strings.HasPrefix(key, "X-Bz-Info-") {
+			value, err := url.QueryUnescape(resp.Header.Get(key))
+			if err != nil {
+				value = resp.Header.Get(key)
+			}
+			file.FileInfo[strings.ToLower(strings.TrimPrefix(key, "X-Bz-Info-"))] = value
+		}
+	}
+	return file
+}
+
+// blake2bTruncatedHexLen is the length of the 32 byte Blake2b hashes stored by earlier versions of
+// UpToB2File
+const blake2bTruncatedHexLen = 64
+
+// verifyDownload compares the hashes of the downloaded data with the SHA1 of file, or its
+// large_file_sha1 for large files, and its content-blake2b if set. Files uploaded by earlier
+// versions of UpToB2File store only the first 32 bytes of the Blake2b hash, which are compared.
+func verifyDownload(file DownloadedFile, sha1Hash hash.Hash, blake2bHash hash.Hash) error {
+	expectedSHA1 := strings.TrimPrefix(file.ContentSha1, "unverified:")
+	if expectedSHA1 == "" || expectedSHA1 == "none" {
+		expectedSHA1 = file.FileInfo["large_file_sha1"]
+	}
+	if expectedSHA1 != "" && !strings.EqualFold(expectedSHA1, hex.EncodeToString(sha1Hash.Sum(nil))) {
+		return ErrSHA1Mismatch
+	}
+	if expected := file.FileInfo["content-blake2b"]; expected != "" {
+		actual := hex.EncodeToString(blake2bHash.Sum(nil))
+		if len(expected) == blake2bTruncatedHexLen {
+			actual = actual[:blake2bTruncatedHexLen]
+		}
+		if !strings.EqualFold(expected, actual) {
+			return ErrBlake2bMismatch
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package gopherb2
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// B2 native API versions, see Configuration.APIVersion
+const (
+	APIv1 = "v1"
+	APIv2 = "v2"
+	APIv3 = "v3"
+)
+
+// DefaultAPIVersion is used when no API version is configured
+const DefaultAPIVersion = APIv3
+
+// checkAPIVersion returns an error if version is not a supported API version
+func checkAPIVersion(version string) error {
+	switch version {
+	case APIv1, APIv2, APIv3:
+		return nil
+	}
+	return fmt.Errorf("Unsupported B2 API version %q, use %s, %s or %s.", version, APIv1, APIv2, APIv3)
+}
+
+// apiBaseURL returns the scheme and host of apiURL, dropping any "/b2api/v1/" path of older configurations
+func apiBaseURL(apiURL string) string {
+	if i := strings.Index(apiURL, "/b2api/"); i >= 0 {
+		apiURL = apiURL[:i]
+	}
+	return strings.TrimSuffix(apiURL, "/")
+}
+
+// authorizeEndpoint returns the URL of b2_authorize_account on the configured API host
+func (c *Client) authorizeEndpoint() string {
+	return apiBaseURL(c.config.APIURL) + "/b2api/" + c.config.APIVersion + "/b2_authorize_account"
+}
+
+// apiEndpoint returns the URL of operation on the API host returned by authorization
+func (c *Client) apiEndpoint(auth APIAuthorization, operation string) string {
+	return apiBaseURL(auth.ApiURL) + "/b2api/" + c.config.APIVersion + "/" + operation
+}
+
+// downloadEndpoint returns the URL of a download operation, such as b2_download_file_by_id, on the
+// download host returned by authorization
+func (c *Client) downloadEndpoint(auth APIAuthorization, operation string) string {
+	return apiBaseURL(auth.DownloadURL) + "/b2api/" + c.config.APIVersion + "/" + operation
+}
+
+// downloadByNameURL returns the URL to download the file fileName from bucket bucketName
+func downloadByNameURL(auth APIAuthorization, bucketName string, fileName string) string {
+	return apiBaseURL(auth.DownloadURL) + "/file/" + url.PathEscape(bucketName) + "/" + escapeFileName(fileName)
+}
+
+// escapeFileName percent-encodes fileName for use in a URL path, keeping "/" separators
+func escapeFileName(fileName string) string {
+	segments := strings.Split(fileName, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	return strings.Join(segments, "/")
+}
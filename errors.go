@@ -0,0 +1,110 @@
+package gopherb2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// APIError is the JSON error body returned by the B2 API for any unsuccessful request
+type APIError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Sentinel API errors, compare with errors.Is(err, ErrNotFound)
+var (
+	ErrBadRequest          = &APIError{Code: "bad_request"}
+	ErrBadAuthToken        = &APIError{Code: "bad_auth_token"}
+	ErrExpiredAuthToken    = &APIError{Code: "expired_auth_token"}
+	ErrUnauthorized        = &APIError{Code: "unauthorized"}
+	ErrDuplicateBucketName = &APIError{Code: "duplicate_bucket_name"}
+	ErrCapExceeded         = &APIError{Code: "cap_exceeded"}
+	ErrNotFound            = &APIError{Code: "not_found"}
+	ErrServiceUnavailable  = &APIError{Code: "service_unavailable"}
+	// ErrRevisionConflict is returned by updates with IfRevisionMatches when the bucket was changed concurrently
+	ErrRevisionConflict = &APIError{Code: "conflict", Status: http.StatusConflict}
+)
+
+// ErrSHA1Mismatch is returned when the SHA1 reported by B2 does not match the SHA1 of the local data
+var ErrSHA1Mismatch = errors.New("b2: SHA1 hash mismatch")
+
+// ErrBlake2bMismatch is returned when downloaded data does not match the content-blake2b file info set on upload
+var ErrBlake2bMismatch = errors.New("b2: Blake2b hash mismatch")
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("b2 api error: %d %s", e.Status, e.Code)
+	}
+	return fmt.Sprintf("b2 api error: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// Is reports whether target is an *APIError with the same code, and the same status if target has one set
+func (e *APIError) Is(target error) bool {
+	t, ok := target.(*APIError)
+	if !ok {
+		return false
+	}
+	return t.Code == e.Code && (t.Status == 0 || t.Status == e.Status)
+}
+
+// newAPIError decodes the error body of an unsuccessful API response. Responses without
+// a JSON error body, such as those from proxies, are reported using the HTTP status.
+func newAPIError(statusCode int, body []byte) *APIError {
+	apiErr := &APIError{}
+	if err := json.Unmarshal(body, apiErr); err != nil || apiErr.Code == "" {
+		apiErr.Code = strings.Replace(strings.ToLower(http.StatusText(statusCode)), " ", "_", -1)
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	if apiErr.Status == 0 {
+		apiErr.Status = statusCode
+	}
+	return apiErr
+}
+
+// LargeFileError is returned when some parts of a large file upload failed or were cancelled,
+// the unfinished large file FileID can then be cancelled or its missing parts uploaded again
+type LargeFileError struct {
+	FileID string
+	// Finished holds the part numbers, starting at 1, uploaded successfully
+	Finished []int
+	// Failed holds the part numbers not uploaded because of an error or cancellation
+	Failed []int
+	// Err is the error of the first failed part, or the error of the cancelled context
+	Err error
+}
+
+func (e *LargeFileError) Error() string {
+	return fmt.Sprintf("b2: large file %s: %d of %d parts finished: %v", e.FileID, len(e.Finished), len(e.Finished)+len(e.Failed), e.Err)
+}
+
+// Unwrap returns the underlying error, so errors.Is(err, context.Canceled) reports a cancelled upload
+func (e *LargeFileError) Unwrap() error {
+	return e.Err
+}
+
+// CapabilityError is returned before calling the API when the application key used by the client
+// lacks the capability an operation requires, or is restricted to another bucket or file names
+type CapabilityError struct {
+	Operation  string
+	Capability string
+	// BucketID is set when the key is restricted to buckets other than the requested bucket BucketID
+	BucketID string
+	// NamePrefix is set when the key is restricted to names starting with NamePrefix, which the
+	// requested file name or prefix FileName does not
+	NamePrefix string
+	FileName   string
+}
+
+func (e *CapabilityError) Error() string {
+	if e.NamePrefix != "" {
+		return fmt.Sprintf("b2: application key is restricted to file names starting with %s, %s of %q not allowed", e.NamePrefix, e.Operation, e.FileName)
+	}
+	if e.BucketID != "" {
+		return fmt.Sprintf("b2: application key is restricted to other buckets than %s, %s not allowed", e.BucketID, e.Operation)
+	}
+	return fmt.Sprintf("b2: application key lacks capability %s required for %s", e.Capability, e.Operation)
+}
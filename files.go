@@ -1,62 +1,386 @@
 package gopherb2
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
 
 	"github.com/uber-go/zap"
-	"gopkg.in/resty.v0"
 )
 
 type allFiles struct {
-	File         []file `json:"files"`
+	File         []File `json:"files"`
 	NextFileName string `json:"nextFileName"`
+	NextFileID   string `json:"nextFileId"`
 }
-type file struct {
+
+// File is a version of a file in B2, as returned by uploads, listings and GetFileInfo. Action is
+// "upload" for uploaded files, "start" for unfinished large files, "hide" for hide markers and
+// "folder" for the folders returned by listings with a delimiter.
+type File struct {
+	AccountID     string `json:"accountId"`
 	Action        string `json:"action"`
-	ContentLength int    `json:"contentLength"`
-	ContentSha1   string `json:"contentSha1"`
-	ContentType   string `json:"contentType"`
-	FileID        string `json:"fileId"`
-	FileInfo      struct {
-		ContentBlake2B        string `json:"content-blake2b"`
-		SrcLastModifiedMillis string `json:"src_last_modified_millis"`
-	} `json:"fileInfo"`
-	FileName        string `json:"fileName"`
-	Size            int    `json:"size"`
-	UploadTimestamp int64  `json:"uploadTimestamp"`
-}
-
-// B2ListFilenames lists all files
-func B2ListFilenames(bucketId string, startFile string) {
-	// Authorize and Get API Token
-	authorizationResponse := AuthorizeAcct()
-
-	// Create & Fetch Request
-	resp, err := resty.R().
-		SetHeader("Authorization", authorizationResponse.AuthorizationToken).
-		SetBody(`{"bucketId":"` + bucketId + `","startFileName":"` + startFile + `"}`).
-		Post(authorizationResponse.ApiURL + "/b2api/v1/b2_list_file_names")
+	BucketID      string `json:"bucketId"`
+	ContentLength int64  `json:"contentLength"`
+	// ContentMd5 is the MD5 of the file, empty for large files
+	ContentMd5 string `json:"contentMd5"`
+	// ContentSha1 is the SHA1 of the file, "none" for large files which keep it in large_file_sha1
+	ContentSha1 string `json:"contentSha1"`
+	ContentType string `json:"contentType"`
+	FileID      string `json:"fileId"`
+	// FileInfo holds the custom info of the file, such as src_last_modified_millis, large_file_sha1
+	// and content-blake2b
+	FileInfo             map[string]string    `json:"fileInfo"`
+	FileName             string               `json:"fileName"`
+	UploadTimestamp      int64                `json:"uploadTimestamp"`
+	FileRetention        FileRetention        `json:"fileRetention"`
+	LegalHold            LegalHold            `json:"legalHold"`
+	ServerSideEncryption ServerSideEncryption `json:"serverSideEncryption"`
+}
+
+// UnmarshalJSON decodes a file, taking ContentLength from the size of listings of API version 1
+func (f *File) UnmarshalJSON(data []byte) error {
+	type plain File
+	value := struct {
+		*plain
+		Size *int64 `json:"size"`
+	}{plain: (*plain)(f)}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	if value.Size != nil && f.ContentLength == 0 {
+		f.ContentLength = *value.Size
+	}
+	return nil
+}
+
+// ListOptions selects the files listed by ListFiles
+type ListOptions struct {
+	// Prefix only lists file names starting with Prefix
+	Prefix string
+	// Delimiter lists all names continuing after Prefix up to the next Delimiter as a single folder,
+	// "/" lists a bucket like a directory tree
+	Delimiter string
+	// StartFileName is the first file name listed
+	StartFileName string
+	// StartFileID is the first version of StartFileName listed by ListFileVersions, or the first
+	// file listed by ListUnfinishedLargeFiles
+	StartFileID string
+	// MaxFileCount is the number of files requested per API call, 1000 if 0, or 100 for
+	// ListUnfinishedLargeFiles. Every 1000 files requested count as a transaction, even if fewer
+	// are returned.
+	MaxFileCount int
+}
+
+// FileIterator lists files page by page, requesting the next page once the files of the
+// previous one are used up
+type FileIterator struct {
+	ctx           context.Context
+	c             *Client
+	operation     string
+	bucket        string
+	opts          ListOptions
+	startFileName string
+	startFileID   string
+	page          []File
+	done          bool
+	file          File
+	err           error
+}
+
+// ListFiles returns an iterator over the latest versions of the files in bucketID selected by opts,
+// in file name order. Hidden files are not listed.
+func (c *Client) ListFiles(bucketID string, opts ListOptions) *FileIterator {
+	return c.ListFilesContext(context.Background(), bucketID, opts)
+}
+
+// ListFilesContext is like ListFiles with a context limiting the requests
+func (c *Client) ListFilesContext(ctx context.Context, bucketID string, opts ListOptions) *FileIterator {
+	return c.newFileIterator(ctx, "b2_list_file_names", bucketID, opts)
+}
+
+// ListFileVersions returns an iterator over all versions of the files in bucketID selected by opts,
+// in file name order and newest version first. Besides uploaded versions it lists hide markers with
+// Action "hide" and unfinished large files with Action "start".
+func (c *Client) ListFileVersions(bucketID string, opts ListOptions) *FileIterator {
+	return c.ListFileVersionsContext(context.Background(), bucketID, opts)
+}
+
+// ListFileVersionsContext is like ListFileVersions with a context limiting the requests
+func (c *Client) ListFileVersionsContext(ctx context.Context, bucketID string, opts ListOptions) *FileIterator {
+	return c.newFileIterator(ctx, "b2_list_file_versions", bucketID, opts)
+}
+
+// newFileIterator returns an iterator calling the list operation, b2_list_file_names,
+// b2_list_file_versions or b2_list_unfinished_large_files
+func (c *Client) newFileIterator(ctx context.Context, operation string, bucketID string, opts ListOptions) *FileIterator {
+	switch {
+	case operation == "b2_list_unfinished_large_files" && (opts.MaxFileCount == 0 || opts.MaxFileCount > 100):
+		opts.MaxFileCount = 100
+	case opts.MaxFileCount == 0:
+		opts.MaxFileCount = 1000
+	}
+	return &FileIterator{
+		ctx:           ctx,
+		c:             c,
+		operation:     operation,
+		bucket:        bucketID,
+		opts:          opts,
+		startFileName: opts.StartFileName,
+		startFileID:   opts.StartFileID,
+	}
+}
+
+// Next advances to the next file, requesting the next page if needed. It returns false once all
+// files are listed or a request failed, check Err to tell them apart.
+func (it *FileIterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		it.err = it.nextPage()
+	}
+	it.file = it.page[0]
+	it.page = it.page[1:]
+	return true
+}
+
+// File returns the current file
+func (it *FileIterator) File() File {
+	return it.file
+}
+
+// Err returns the error that stopped the listing, nil if all files were listed
+func (it *FileIterator) Err() error {
+	return it.err
+}
+
+// nextPage requests the page starting at startFileName, and startFileID when listing versions or
+// unfinished large files
+func (it *FileIterator) nextPage() error {
+	bucketID, err := it.c.ResolveBucketContext(it.ctx, it.bucket)
 	if err != nil {
-		logger.Fatal("API Communication Error: Could not get filename list",
+		return err
+	}
+	reqBody := map[string]interface{}{
+		"bucketId":     bucketID,
+		"maxFileCount": it.opts.MaxFileCount,
+	}
+	unfinished := it.operation == "b2_list_unfinished_large_files"
+	if it.startFileName != "" && !unfinished {
+		reqBody["startFileName"] = it.startFileName
+	}
+	if it.startFileID != "" && it.operation != "b2_list_file_names" {
+		reqBody["startFileId"] = it.startFileID
+	}
+	if it.opts.Prefix != "" && unfinished {
+		reqBody["namePrefix"] = it.opts.Prefix
+	} else if it.opts.Prefix != "" {
+		reqBody["prefix"] = it.opts.Prefix
+	}
+	if it.opts.Delimiter != "" {
+		reqBody["delimiter"] = it.opts.Delimiter
+	}
+	var files allFiles
+	err = it.c.apiCall(it.ctx, it.operation, reqBody, &files)
+	if err != nil {
+		it.c.Logger.Warn("API Communication Error: Could not get filename list",
+			zap.String("Bucket ID", bucketID),
+			zap.String("Start Filename", it.startFileName),
 			zap.Error(err),
 		)
+		return err
 	}
+	it.page = files.File
+	it.startFileName, it.startFileID = files.NextFileName, files.NextFileID
+	it.done = files.NextFileName == ""
+	if unfinished {
+		// Unfinished large files are listed by file ID only
+		it.done = files.NextFileID == ""
+	}
+	return nil
+}
 
-	// Read Response
-	var allFiles allFiles
-	err = json.Unmarshal(resp.Body(), &allFiles)
+// ListFilenames displays all files starting at startFile in console
+func (c *Client) ListFilenames(bucketId string, startFile string) error {
+	return c.ListFilenamesContext(context.Background(), bucketId, startFile)
+}
+
+// ListFilenamesContext is like ListFilenames with a context limiting the requests
+func (c *Client) ListFilenamesContext(ctx context.Context, bucketId string, startFile string) error {
+	files := c.ListFilesContext(ctx, bucketId, ListOptions{StartFileName: startFile})
+	for files.Next() {
+		f := files.File()
+		fmt.Printf("\n\nFileID: %v\nFilename: %v\nSHA1: %v\nBlake2b: %v\nSize: %v",
+			f.FileID, f.FileName, f.ContentSha1, f.FileInfo["content-blake2b"], f.ContentLength)
+	}
+	return files.Err()
+}
+
+// PrintFileVersions Displays file versions in console with their upload time, action and SHA1
+func PrintFileVersions(versions []File) error {
+	writer := new(tabwriter.Writer)
+	writer.Init(os.Stdout, 0, 5, 1, ' ', 0)
+	fmt.Fprintln(writer, "-NAME-\t -UPLOADED-\t -ACTION-\t -SIZE-\t -SHA1-\t -ID-")
+	for _, f := range versions {
+		uploaded := "-"
+		if f.UploadTimestamp != 0 {
+			uploaded = time.Unix(0, f.UploadTimestamp*int64(time.Millisecond)).Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintln(writer, f.FileName+"\t", uploaded+"\t", f.Action+"\t", fmt.Sprint(f.ContentLength)+"\t", f.ContentSha1+"\t", f.FileID+"\t")
+	}
+	fmt.Fprintln(writer)
+	return writer.Flush()
+}
+
+// PrintFiles Displays files in console, folders listed with a delimiter without size
+func PrintFiles(files []File) error {
+	writer := new(tabwriter.Writer)
+	writer.Init(os.Stdout, 0, 5, 1, ' ', 0)
+	fmt.Fprintln(writer, "-NAME-\t -SIZE-\t -UPLOADED-\t -ID-")
+	for _, f := range files {
+		if f.Action == "folder" {
+			fmt.Fprintln(writer, f.FileName+"\t", "-\t", "-\t", "-\t")
+			continue
+		}
+		uploaded := time.Unix(0, f.UploadTimestamp*int64(time.Millisecond)).Format("2006-01-02 15:04:05")
+		fmt.Fprintln(writer, f.FileName+"\t", fmt.Sprint(f.ContentLength)+"\t", uploaded+"\t", f.FileID+"\t")
+	}
+	fmt.Fprintln(writer)
+	return writer.Flush()
+}
+
+// DeleteFileVersion deletes the version fileID of fileName. Deleting the latest version of a file
+// name makes the previous version, if any, the current one.
+func (c *Client) DeleteFileVersion(fileName string, fileID string) error {
+	return c.DeleteFileVersionContext(context.Background(), fileName, fileID)
+}
+
+// DeleteFileVersionContext is like DeleteFileVersion with a context limiting the request
+func (c *Client) DeleteFileVersionContext(ctx context.Context, fileName string, fileID string) error {
+	err := c.apiCall(ctx, "b2_delete_file_version", map[string]string{"fileName": fileName, "fileId": fileID}, nil)
 	if err != nil {
-		logger.Fatal("Error parsing JSON response for request all filenames",
+		c.Logger.Warn("Could not delete file version",
+			zap.String("Filename", fileName),
+			zap.String("File ID", fileID),
 			zap.Error(err),
 		)
+		return err
 	}
+	c.Logger.Debug("File version deleted",
+		zap.String("Filename", fileName),
+		zap.String("File ID", fileID),
+	)
+	return nil
+}
 
-	// Display files
-	for i := 0; i < len(allFiles.File); i++ {
-		fmt.Printf("\n\nFileID: %v\nFilename: %v\nSHA1: %v\nBlake2b: %v\nSize: %v",
-			allFiles.File[i].FileID, allFiles.File[i].FileName, allFiles.File[i].ContentSha1,
-			allFiles.File[i].FileInfo.ContentBlake2B, allFiles.File[i].Size)
+// HideFile hides fileName in bucket by uploading a hide marker as its latest version, so it is no
+// longer listed or downloadable by name while its earlier versions are kept
+func (c *Client) HideFile(bucket string, fileName string) (File, error) {
+	return c.HideFileContext(context.Background(), bucket, fileName)
+}
+
+// HideFileContext is like HideFile with a context limiting the request
+func (c *Client) HideFileContext(ctx context.Context, bucket string, fileName string) (File, error) {
+	var hidden File
+	bucketID, err := c.ResolveBucketContext(ctx, bucket)
+	if err != nil {
+		return hidden, err
 	}
+	err = c.apiCall(ctx, "b2_hide_file", map[string]string{"bucketId": bucketID, "fileName": fileName}, &hidden)
+	if err != nil {
+		c.Logger.Warn("Could not hide file",
+			zap.String("Bucket ID", bucketID),
+			zap.String("Filename", fileName),
+			zap.Error(err),
+		)
+		return hidden, err
+	}
+	c.Logger.Debug("File hidden",
+		zap.String("Filename", fileName),
+		zap.String("File ID", hidden.FileID),
+	)
+	return hidden, nil
+}
 
+// GetFileInfo returns the file version fileID, including hide markers and unfinished large files
+func (c *Client) GetFileInfo(fileID string) (File, error) {
+	return c.GetFileInfoContext(context.Background(), fileID)
+}
+
+// GetFileInfoContext is like GetFileInfo with a context limiting the request
+func (c *Client) GetFileInfoContext(ctx context.Context, fileID string) (File, error) {
+	var file File
+	err := c.apiCall(ctx, "b2_get_file_info", map[string]string{"fileId": fileID}, &file)
+	if err != nil {
+		c.Logger.Warn("Could not get file info",
+			zap.String("File ID", fileID),
+			zap.Error(err),
+		)
+	}
+	return file, err
+}
+
+// GetFileInfoByName returns the latest version of fileName in bucket, failing with ErrNotFound if
+// there is none or the file is hidden
+func (c *Client) GetFileInfoByName(bucket string, fileName string) (File, error) {
+	return c.GetFileInfoByNameContext(context.Background(), bucket, fileName)
+}
+
+// GetFileInfoByNameContext is like GetFileInfoByName with a context limiting the requests
+func (c *Client) GetFileInfoByNameContext(ctx context.Context, bucket string, fileName string) (File, error) {
+	files := c.ListFilesContext(ctx, bucket, ListOptions{StartFileName: fileName, MaxFileCount: 1})
+	if !files.Next() {
+		if err := files.Err(); err != nil {
+			return File{}, err
+		}
+	} else if files.File().FileName == fileName {
+		return c.GetFileInfoContext(ctx, files.File().FileID)
+	}
+	return File{}, &APIError{Status: http.StatusNotFound, Code: "not_found", Message: "file not present: " + fileName}
+}
+
+// PrintFile Displays a file version in console with all its file info
+func PrintFile(f File) error {
+	writer := new(tabwriter.Writer)
+	writer.Init(os.Stdout, 0, 5, 1, ' ', 0)
+	uploaded := "-"
+	if f.UploadTimestamp != 0 {
+		uploaded = time.Unix(0, f.UploadTimestamp*int64(time.Millisecond)).Format("2006-01-02 15:04:05")
+	}
+	fmt.Fprintln(writer, "Name:\t", f.FileName)
+	fmt.Fprintln(writer, "ID:\t", f.FileID)
+	fmt.Fprintln(writer, "Bucket ID:\t", f.BucketID)
+	fmt.Fprintln(writer, "Action:\t", f.Action)
+	fmt.Fprintln(writer, "Uploaded:\t", uploaded)
+	fmt.Fprintln(writer, "Size:\t", f.ContentLength)
+	fmt.Fprintln(writer, "Content Type:\t", f.ContentType)
+	fmt.Fprintln(writer, "SHA1:\t", f.ContentSha1)
+	if f.ContentMd5 != "" {
+		fmt.Fprintln(writer, "MD5:\t", f.ContentMd5)
+	}
+	if f.ServerSideEncryption.Mode != "" {
+		fmt.Fprintln(writer, "Encryption:\t", f.ServerSideEncryption.Mode)
+	}
+	if f.FileRetention.Mode != "" {
+		until := time.Unix(0, f.FileRetention.RetainUntilTimestamp*int64(time.Millisecond)).Format("2006-01-02 15:04:05")
+		fmt.Fprintln(writer, "Retention:\t", f.FileRetention.Mode+" until "+until)
+	}
+	if f.LegalHold != "" {
+		fmt.Fprintln(writer, "Legal Hold:\t", f.LegalHold)
+	}
+	keys := make([]string, 0, len(f.FileInfo))
+	for key := range f.FileInfo {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintln(writer, "Info "+key+":\t", f.FileInfo[key])
+	}
+	return writer.Flush()
 }
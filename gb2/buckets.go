@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/dwin/gopherb2"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// bucketCommand manages buckets
+var bucketCommand = cli.Command{
+	Name:        "bucket",
+	Aliases:     []string{"buckets"},
+	Usage:       "[global] bucket [command] [arguments...]",
+	Description: "Manages B2 Buckets",
+	Subcommands: []cli.Command{
+		{
+			Name:        "create",
+			Aliases:     []string{"new"},
+			Usage:       "[global] bucket create [--public] [name of new bucket]",
+			Description: "Creates New Backblaze B2 Bucket",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "public",
+					Usage: "allow anyone to download files of the bucket",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				checkDebug()
+				client := newClient()
+				bucket, err := client.CreateBucket(c.Args().Get(0), c.Bool("public"))
+				if err != nil {
+					log.Fatal(err)
+				}
+				fmt.Printf("Bucket Created\nName: %v\nID: %v\nType: %v\n", bucket.BucketName, bucket.BucketID, bucket.BucketType)
+				return nil
+			},
+		},
+		{
+			Name:        "list",
+			Usage:       "[global] bucket list",
+			Description: "List all Buckets in Account",
+			Action: func(c *cli.Context) error {
+				client := newClient()
+				buckets, err := client.GetBuckets()
+				if err != nil {
+					log.Fatal(err)
+				}
+				err = gopherb2.PrintBuckets(buckets)
+				if err != nil {
+					log.Fatal(err)
+				}
+				return nil
+			},
+		},
+		{
+			Name:        "update",
+			Usage:       "[global] bucket update [options] [bucket]",
+			Description: "Changes type or bucket info of a Bucket",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "type",
+					Usage: "new bucket `type`, allPublic or allPrivate",
+				},
+				cli.StringSliceFlag{
+					Name:  "info",
+					Usage: "replace bucket info with `key=value` pairs, repeat for several",
+				},
+				cli.IntFlag{
+					Name:  "revision",
+					Usage: "only update if the bucket is still at `revision`, the revision read before updating if not set",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				checkDebug()
+				if c.NArg() != 1 {
+					return cli.NewExitError("bucket name or id required", 1)
+				}
+				update := gopherb2.BucketSettings{
+					BucketType:        c.String("type"),
+					IfRevisionMatches: c.Int("revision"),
+				}
+				if c.IsSet("info") {
+					update.BucketInfo = map[string]string{}
+					for _, pair := range c.StringSlice("info") {
+						kv := strings.SplitN(pair, "=", 2)
+						if len(kv) != 2 {
+							return cli.NewExitError("bucket info must be key=value: "+pair, 1)
+						}
+						update.BucketInfo[kv[0]] = kv[1]
+					}
+				}
+				client := newClient()
+				if update.IfRevisionMatches == 0 {
+					update.IfRevisionMatches = currentRevision(client, c.Args().Get(0))
+				}
+				bucket, err := client.UpdateBucket(c.Args().Get(0), update)
+				if errors.Is(err, gopherb2.ErrRevisionConflict) {
+					return cli.NewExitError(fmt.Sprintf("Bucket was changed since revision %v, not updated", update.IfRevisionMatches), 1)
+				}
+				if err != nil {
+					log.Fatal(err)
+				}
+				fmt.Printf("Bucket Updated\nName: %v\nID: %v\nType: %v\nRevision: %v\n", bucket.BucketName, bucket.BucketID, bucket.BucketType, bucket.Revision)
+				return nil
+			},
+		},
+		{
+			Name:        "delete",
+			Aliases:     []string{"rm"},
+			Usage:       "[global] bucket delete [--force] [bucket]",
+			Description: "Deletes an empty Bucket, or with --force every file version in it and then the Bucket",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "force",
+					Usage: "delete all file versions and unfinished large files first",
+				},
+				cli.BoolFlag{
+					Name:  "yes, y",
+					Usage: "do not ask for confirmation of --force",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				checkDebug()
+				if c.NArg() != 1 {
+					return cli.NewExitError("bucket name or id required", 1)
+				}
+				bucketID := c.Args().Get(0)
+				client := newClient()
+				if c.Bool("force") {
+					if !c.Bool("yes") && !confirm(fmt.Sprintf("Delete ALL file versions in bucket %v and the bucket itself?", bucketID)) {
+						return cli.NewExitError("Aborted", 1)
+					}
+					removed, err := client.EmptyBucket(bucketID)
+					fmt.Printf("Removed %v file versions\n", removed)
+					if err != nil {
+						log.Fatal(err)
+					}
+				}
+				bucket, err := client.DeleteBucket(bucketID)
+				if err != nil {
+					log.Fatal(err)
+				}
+				fmt.Printf("Bucket Deleted\nName: %v\nID: %v\n", bucket.BucketName, bucket.BucketID)
+				return nil
+			},
+		},
+		lifecycleCommand,
+		corsCommand,
+		infoCommand,
+	},
+}
+
+// lifecycleCommand manages the lifecycle rules of a bucket
+var lifecycleCommand = cli.Command{
+	Name:        "lifecycle",
+	Usage:       "[global] bucket lifecycle [command] [arguments...]",
+	Description: "Manages lifecycle rules hiding and deleting old files of a Bucket",
+	Subcommands: []cli.Command{
+		{
+			Name:        "list",
+			Usage:       "[global] bucket lifecycle list [bucket]",
+			Description: "List lifecycle rules of a Bucket",
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return cli.NewExitError("bucket name or id required", 1)
+				}
+				client := newClient()
+				bucket, err := client.GetBucket(c.Args().Get(0))
+				if err != nil {
+					log.Fatal(err)
+				}
+				return gopherb2.PrintLifecycleRules(bucket.LifecycleRules)
+			},
+		},
+		{
+			Name:        "add",
+			Usage:       "[global] bucket lifecycle add [--prefix prefix] [--hide-days n] [--delete-days n] [bucket]",
+			Description: "Adds a lifecycle rule to a Bucket",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "prefix",
+					Usage: "apply the rule to file names starting with `prefix`, all files if not set",
+				},
+				cli.IntFlag{
+					Name:  "hide-days",
+					Usage: "hide files `n` days after upload",
+				},
+				cli.IntFlag{
+					Name:  "delete-days",
+					Usage: "delete hidden files `n` days after hiding",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				checkDebug()
+				if c.NArg() != 1 {
+					return cli.NewExitError("bucket name or id required", 1)
+				}
+				rule := gopherb2.LifecycleRule{
+					FileNamePrefix:            c.String("prefix"),
+					DaysFromUploadingToHiding: c.Int("hide-days"),
+					DaysFromHidingToDeleting:  c.Int("delete-days"),
+				}
+				client := newClient()
+				bucket, err := client.AddLifecycleRule(c.Args().Get(0), rule)
+				if err != nil {
+					log.Fatal(err)
+				}
+				return gopherb2.PrintLifecycleRules(bucket.LifecycleRules)
+			},
+		},
+		{
+			Name:        "remove",
+			Aliases:     []string{"rm"},
+			Usage:       "[global] bucket lifecycle remove [--prefix prefix] [bucket]",
+			Description: "Removes the lifecycle rule for a prefix from a Bucket",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "prefix",
+					Usage: "remove the rule for `prefix`, the rule for all files if not set",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				checkDebug()
+				if c.NArg() != 1 {
+					return cli.NewExitError("bucket name or id required", 1)
+				}
+				client := newClient()
+				bucket, err := client.RemoveLifecycleRule(c.Args().Get(0), c.String("prefix"))
+				if err != nil {
+					log.Fatal(err)
+				}
+				return gopherb2.PrintLifecycleRules(bucket.LifecycleRules)
+			},
+		},
+	},
+}
+
+// corsCommand manages the CORS rules of a bucket
+var corsCommand = cli.Command{
+	Name:        "cors",
+	Usage:       "[global] bucket cors [command] [arguments...]",
+	Description: "Manages CORS rules allowing browsers on other sites to access a Bucket",
+	Subcommands: []cli.Command{
+		{
+			Name:        "get",
+			Usage:       "[global] bucket cors get [bucket]",
+			Description: "Prints the CORS rules of a Bucket as JSON",
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return cli.NewExitError("bucket name or id required", 1)
+				}
+				client := newClient()
+				bucket, err := client.GetBucket(c.Args().Get(0))
+				if err != nil {
+					log.Fatal(err)
+				}
+				rules, err := json.MarshalIndent(bucket.CORSRules, "", "  ")
+				if err != nil {
+					log.Fatal(err)
+				}
+				fmt.Println(string(rules))
+				return nil
+			},
+		},
+		{
+			Name:        "set",
+			Usage:       "[global] bucket cors set [bucket] [rules file]",
+			Description: "Replaces the CORS rules of a Bucket with the rules of a JSON or TOML file, an empty list removes all rules",
+			Action: func(c *cli.Context) error {
+				checkDebug()
+				if c.NArg() != 2 {
+					return cli.NewExitError("bucket name or id and rules file required", 1)
+				}
+				rules, err := gopherb2.LoadCORSRules(c.Args().Get(1))
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				client := newClient()
+				revision := currentRevision(client, c.Args().Get(0))
+				bucket, err := client.UpdateBucket(c.Args().Get(0), gopherb2.BucketSettings{CORSRules: rules, IfRevisionMatches: revision})
+				if errors.Is(err, gopherb2.ErrRevisionConflict) {
+					return cli.NewExitError(fmt.Sprintf("Bucket was changed since revision %v, not updated", revision), 1)
+				}
+				if err != nil {
+					log.Fatal(err)
+				}
+				fmt.Printf("CORS Rules Set\nBucket: %v\nRules: %v\nRevision: %v\n", bucket.BucketName, len(bucket.CORSRules), bucket.Revision)
+				return nil
+			},
+		},
+	},
+}
+
+// infoCommand manages the bucket info of a bucket
+var infoCommand = cli.Command{
+	Name:        "info",
+	Usage:       "[global] bucket info [command] [arguments...]",
+	Description: "Manages custom key/value bucket info of a Bucket",
+	Subcommands: []cli.Command{
+		{
+			Name:        "get",
+			Aliases:     []string{"list"},
+			Usage:       "[global] bucket info get [bucket]",
+			Description: "List bucket info of a Bucket",
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return cli.NewExitError("bucket name or id required", 1)
+				}
+				client := newClient()
+				bucket, err := client.GetBucket(c.Args().Get(0))
+				if err != nil {
+					log.Fatal(err)
+				}
+				fmt.Printf("Revision: %v\n", bucket.Revision)
+				return gopherb2.PrintBucketInfo(bucket.BucketInfo)
+			},
+		},
+		{
+			Name:        "set",
+			Usage:       "[global] bucket info set [bucket] [key=value...]",
+			Description: "Sets bucket info entries of a Bucket, keeping its other entries",
+			Action: func(c *cli.Context) error {
+				checkDebug()
+				if c.NArg() < 2 {
+					return cli.NewExitError("bucket name or id and key=value pairs required", 1)
+				}
+				set := map[string]string{}
+				for _, pair := range c.Args().Tail() {
+					kv := strings.SplitN(pair, "=", 2)
+					if len(kv) != 2 {
+						return cli.NewExitError("bucket info must be key=value: "+pair, 1)
+					}
+					set[kv[0]] = kv[1]
+				}
+				return updateBucketInfo(c.Args().Get(0), set, nil)
+			},
+		},
+		{
+			Name:        "remove",
+			Aliases:     []string{"rm"},
+			Usage:       "[global] bucket info remove [bucket] [key...]",
+			Description: "Removes bucket info entries from a Bucket",
+			Action: func(c *cli.Context) error {
+				checkDebug()
+				if c.NArg() < 2 {
+					return cli.NewExitError("bucket name or id and keys required", 1)
+				}
+				return updateBucketInfo(c.Args().Get(0), nil, c.Args().Tail())
+			},
+		},
+	},
+}
+
+// updateBucketInfo sets and removes bucket info entries and prints the result
+func updateBucketInfo(bucketID string, set map[string]string, remove []string) error {
+	client := newClient()
+	bucket, err := client.SetBucketInfo(bucketID, set, remove)
+	if errors.Is(err, gopherb2.ErrRevisionConflict) {
+		return cli.NewExitError("Bucket was changed concurrently, not updated, try again", 1)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Revision: %v\n", bucket.Revision)
+	return gopherb2.PrintBucketInfo(bucket.BucketInfo)
+}
+
+// confirm asks question on the console and reports whether it was answered with yes
+func confirm(question string) bool {
+	fmt.Printf("%v [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// currentRevision returns the revision of bucket, so an update fails instead of overwriting a
+// concurrent change made after the bucket was read
+func currentRevision(client *gopherb2.Client, bucket string) int {
+	current, err := client.GetBucket(bucket)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return current.Revision
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/dwin/gopherb2"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// copyCommand copies a file within or between buckets without downloading it
+var copyCommand = cli.Command{
+	Name:        "cp",
+	Aliases:     []string{"copy"},
+	Usage:       "[global] cp [options] b2://[bucket]/[file name] b2://[bucket]/[file name]",
+	Description: "Copies the latest version of a file, or the version given by --id, on the B2 servers. A destination ending in / keeps the source file name.",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "copy the file version `id` instead of the latest version of the source name",
+		},
+		cli.Int64Flag{
+			Name:  "offset",
+			Usage: "first `byte` of the source copied",
+		},
+		cli.Int64Flag{
+			Name:  "length",
+			Usage: "number of `bytes` copied, all after --offset if 0",
+		},
+		cli.StringFlag{
+			Name:  "content-type",
+			Usage: "replace the content type of the copy, b2/x-auto if only --info is set",
+		},
+		cli.StringSliceFlag{
+			Name:  "info",
+			Usage: "replace the file info of the copy, as `key=value`, repeated for every entry",
+		},
+		cli.IntFlag{
+			Name:  "concurrency",
+			Value: 4,
+			Usage: "number of parts copied at the same time for large files",
+		},
+		cli.Int64Flag{
+			Name:  "part-size",
+			Value: gopherb2.DefaultCopyPartSize / 1000 / 1000,
+			Usage: "size of the parts of large file copies in `MB`, smaller files are copied with one request",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		checkDebug()
+		if c.NArg() != 2 {
+			return cli.NewExitError("source and destination b2://bucket/file name required", 1)
+		}
+		srcBucket, srcName, err := parseB2URL(c.Args().Get(0))
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		dstBucket, dstName, err := parseB2URL(c.Args().Get(1))
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if dstName == "" || strings.HasSuffix(dstName, "/") {
+			dstName += path.Base(srcName)
+		}
+		opts := gopherb2.CopyOptions{
+			DestinationBucket: dstBucket,
+			Offset:            c.Int64("offset"),
+			Length:            c.Int64("length"),
+		}
+		if c.String("content-type") != "" || len(c.StringSlice("info")) > 0 {
+			opts.MetadataDirective = gopherb2.MetadataReplace
+			opts.ContentType = c.String("content-type")
+			opts.FileInfo = make(map[string]string)
+			for _, entry := range c.StringSlice("info") {
+				pair := strings.SplitN(entry, "=", 2)
+				if len(pair) != 2 || pair[0] == "" {
+					return cli.NewExitError("file info must be given as key=value, got "+entry, 1)
+				}
+				opts.FileInfo[pair[0]] = pair[1]
+			}
+		}
+
+		client := newClient()
+		client.UploadConcurrency = c.Int("concurrency")
+		client.CopyPartSize = c.Int64("part-size") * 1000 * 1000
+		ctx, stop := interruptContext()
+		defer stop()
+		sourceID := c.String("id")
+		if sourceID == "" {
+			if srcName == "" {
+				return cli.NewExitError("source file name or --id required", 1)
+			}
+			source, err := client.GetFileInfoByNameContext(ctx, srcBucket, srcName)
+			if err != nil {
+				log.Fatal(err)
+			}
+			sourceID = source.FileID
+		}
+		copied, err := client.CopyFileContext(ctx, sourceID, dstName, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Copied %v to b2://%v/%v\nID: %v\nSize: %v\n", c.Args().Get(0), dstBucket, copied.FileName, copied.FileID, copied.ContentLength)
+		return nil
+	},
+}
+
+// parseB2URL splits a b2://bucket/file name URL into the bucket and the file name, which may be empty
+func parseB2URL(b2URL string) (string, string, error) {
+	if !strings.HasPrefix(b2URL, "b2://") {
+		return "", "", fmt.Errorf("%v is not a b2://bucket/file name URL", b2URL)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(b2URL, "b2://"), "/", 2)
+	if parts[0] == "" {
+		return "", "", fmt.Errorf("%v has no bucket", b2URL)
+	}
+	if len(parts) == 1 {
+		return parts[0], "", nil
+	}
+	return parts[0], parts[1], nil
+}
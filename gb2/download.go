@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/dwin/gopherb2"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// downloadCommand downloads a file into a .part file first, resuming it if it exists
+var downloadCommand = cli.Command{
+	Name:        "download",
+	Aliases:     []string{"get"},
+	Usage:       "[global] download [options] [bucket] [file name] [local path] | download --id [file id] [local path]",
+	Description: "Downloads and verifies a file, continuing an interrupted download of the same local path unless --parallel is set",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "download the file version `id` instead of the latest version of a file name",
+		},
+		cli.BoolFlag{
+			Name:  "parallel, P",
+			Usage: "download ranges of the file concurrently, for large files",
+		},
+		cli.IntFlag{
+			Name:  "concurrency",
+			Value: 4,
+			Usage: "number of ranges downloaded at the same time with --parallel",
+		},
+		cli.Int64Flag{
+			Name:  "range-size",
+			Value: gopherb2.DefaultDownloadRangeSize / 1000 / 1000,
+			Usage: "size of the ranges downloaded with --parallel in `MB`",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		checkDebug()
+		args := c.Args()
+		var dest string
+		switch {
+		case c.String("id") != "" && c.NArg() == 1:
+			dest = args.Get(0)
+		case c.String("id") == "" && c.NArg() == 2:
+			dest = path.Base(args.Get(1))
+		case c.String("id") == "" && c.NArg() == 3:
+			dest = args.Get(2)
+		default:
+			return cli.NewExitError("bucket and file name, or --id and local path required", 1)
+		}
+
+		client := newClient()
+		ctx, stop := interruptContext()
+		defer stop()
+		download := func(w io.Writer, opts gopherb2.DownloadOptions) (gopherb2.DownloadedFile, error) {
+			if c.String("id") != "" {
+				return client.DownloadFileByIDContext(ctx, c.String("id"), w, opts)
+			}
+			return client.DownloadFileByNameContext(ctx, args.Get(0), args.Get(1), w, opts)
+		}
+
+		part := dest + ".part"
+		if c.Bool("parallel") {
+			client.DownloadConcurrency = c.Int("concurrency")
+			client.DownloadRangeSize = c.Int64("range-size") * 1000 * 1000
+			return parallelDownload(ctx, c, client, part, dest)
+		}
+		file, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		downloaded, err := resumeDownload(file, download)
+		var apiErr *gopherb2.APIError
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusRequestedRangeNotSatisfiable {
+			// The .part file is not the start of the file, download it again
+			fmt.Println("Partial download does not match, starting over")
+			if err = file.Truncate(0); err == nil {
+				downloaded, err = resumeDownload(file, download)
+			}
+		}
+		if errors.Is(err, gopherb2.ErrSHA1Mismatch) || errors.Is(err, gopherb2.ErrBlake2bMismatch) {
+			file.Close()
+			os.Remove(part)
+			log.Fatal(err)
+		}
+		if err != nil {
+			fmt.Printf("\nDownload incomplete, run the same command again to resume %v\n", part)
+			log.Fatal(err)
+		}
+		if err = file.Close(); err != nil {
+			log.Fatal(err)
+		}
+		if err = os.Rename(part, dest); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Downloaded %v\nID: %v\nSize: %v\nSaved: %v\n", downloaded.FileName, downloaded.FileID, downloaded.ContentLength, dest)
+		return nil
+	},
+}
+
+// parallelDownload downloads into a new .part file, which is removed if the download fails since
+// ranges may be missing anywhere in it
+func parallelDownload(ctx context.Context, c *cli.Context, client *gopherb2.Client, part string, dest string) error {
+	file, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var downloaded gopherb2.DownloadedFile
+	if c.String("id") != "" {
+		downloaded, err = client.ParallelDownloadFileByIDContext(ctx, c.String("id"), file)
+	} else {
+		downloaded, err = client.ParallelDownloadFileByNameContext(ctx, c.Args().Get(0), c.Args().Get(1), file)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(part)
+		log.Fatal(err)
+	}
+	if err = os.Rename(part, dest); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Downloaded %v\nID: %v\nSize: %v\nSaved: %v\n", downloaded.FileName, downloaded.FileID, downloaded.ContentLength, dest)
+	return nil
+}
+
+// resumeDownload appends the rest of the file to the data already in file, verifying both together
+func resumeDownload(file *os.File, download func(io.Writer, gopherb2.DownloadOptions) (gopherb2.DownloadedFile, error)) (gopherb2.DownloadedFile, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return gopherb2.DownloadedFile{}, err
+	}
+	offset := info.Size()
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return gopherb2.DownloadedFile{}, err
+	}
+	if offset > 0 {
+		fmt.Printf("Resuming download after %v bytes\n", offset)
+	}
+	return download(file, gopherb2.DownloadOptions{Offset: offset, Downloaded: io.NewSectionReader(file, 0, offset)})
+}
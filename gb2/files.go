@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/dwin/gopherb2"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// fileInfoCommand shows a file version looked up by ID, or the latest version of a file name
+var fileInfoCommand = cli.Command{
+	Name:        "info",
+	Aliases:     []string{"show"},
+	Usage:       "[global] file info [file id] | file info [bucket] [file name]",
+	Description: "Shows the size, hashes, retention and file info of a file version, or of the latest version of a file name",
+	Action: func(c *cli.Context) error {
+		checkDebug()
+		if c.NArg() != 1 && c.NArg() != 2 {
+			return cli.NewExitError("file id, or bucket name or id and file name required", 1)
+		}
+		client := newClient()
+		ctx, stop := interruptContext()
+		defer stop()
+		var file gopherb2.File
+		var err error
+		if c.NArg() == 1 {
+			file, err = client.GetFileInfoContext(ctx, c.Args().Get(0))
+		} else {
+			file, err = client.GetFileInfoByNameContext(ctx, c.Args().Get(0), c.Args().Get(1))
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return gopherb2.PrintFile(file)
+	},
+}
+
+// fileRemoveCommand deletes one version, every version, or the files below a prefix
+var fileRemoveCommand = cli.Command{
+	Name:        "rm",
+	Aliases:     []string{"delete"},
+	Usage:       "[global] file rm [options] [bucket] [file name or prefix]",
+	Description: "Deletes the latest version of a file, revealing the previous one, a single version with --id, every version with --all-versions, or every file below a prefix with --recursive. Without --all-versions a recursive delete reveals the previous version of every file, hidden files are skipped.",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "delete only the file version `id`",
+		},
+		cli.BoolFlag{
+			Name:  "all-versions, a",
+			Usage: "delete every version of the files instead of only the latest",
+		},
+		cli.BoolFlag{
+			Name:  "recursive, r",
+			Usage: "delete every file with a name starting with the prefix given, all files of the bucket without one",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run, n",
+			Usage: "only list the versions that would be deleted",
+		},
+		cli.IntFlag{
+			Name:  "concurrency",
+			Value: 4,
+			Usage: "number of versions deleted at the same time",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		checkDebug()
+		switch {
+		case c.String("id") != "" && (c.Bool("recursive") || c.Bool("all-versions")):
+			return cli.NewExitError("--id deletes a single version and cannot be used with --recursive or --all-versions", 1)
+		case c.Bool("recursive") && (c.NArg() == 1 || c.NArg() == 2):
+		case c.NArg() != 2:
+			return cli.NewExitError("bucket name or id and file name required", 1)
+		}
+		bucket, name := c.Args().Get(0), c.Args().Get(1)
+		client := newClient()
+		ctx, stop := interruptContext()
+		defer stop()
+
+		if c.String("id") != "" {
+			if c.Bool("dry-run") {
+				fmt.Printf("Would delete %v (%v)\n", name, c.String("id"))
+				return nil
+			}
+			if err := client.DeleteFileVersionContext(ctx, name, c.String("id")); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("Deleted %v (%v)\n", name, c.String("id"))
+			return nil
+		}
+
+		opts := gopherb2.DeleteOptions{
+			FileName:    name,
+			AllVersions: c.Bool("all-versions"),
+			DryRun:      c.Bool("dry-run"),
+			Concurrency: c.Int("concurrency"),
+		}
+		if c.Bool("recursive") {
+			opts.FileName, opts.Prefix = "", name
+			if name == "" && !opts.DryRun && !confirm("Delete every file in bucket "+bucket+"?") {
+				return nil
+			}
+		}
+		verb := "Deleted"
+		if opts.DryRun {
+			verb = "Would delete"
+		}
+		opts.Removed = func(f gopherb2.File, err error) {
+			if err != nil {
+				fmt.Printf("Could not delete %v (%v): %v\n", f.FileName, f.FileID, err)
+				return
+			}
+			fmt.Printf("%v %v (%v)\n", verb, f.FileName, f.FileID)
+		}
+		summary, err := client.DeleteFilesContext(ctx, bucket, opts)
+		fmt.Printf("%v %v versions, %v bytes", verb, summary.Removed, summary.Bytes)
+		if summary.Failed > 0 {
+			fmt.Printf(", %v failed", summary.Failed)
+		}
+		fmt.Println()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if summary.Removed == 0 {
+			return cli.NewExitError("No files matched "+name, 1)
+		}
+		return nil
+	},
+}
+
+// fileHideCommand hides a file name, keeping its versions
+var fileHideCommand = cli.Command{
+	Name:        "hide",
+	Usage:       "[global] file hide [bucket] [file name]",
+	Description: "Hides a file so it is no longer listed or downloadable by name, keeping its earlier versions. Deleting the hide marker with file rm reveals the file again.",
+	Action: func(c *cli.Context) error {
+		checkDebug()
+		if c.NArg() != 2 {
+			return cli.NewExitError("bucket name or id and file name required", 1)
+		}
+		client := newClient()
+		ctx, stop := interruptContext()
+		defer stop()
+		hidden, err := client.HideFileContext(ctx, c.Args().Get(0), c.Args().Get(1))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Hidden %v\nHide marker ID: %v\n", hidden.FileName, hidden.FileID)
+		return nil
+	},
+}
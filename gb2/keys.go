@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/dwin/gopherb2"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// keyCommand manages application keys
+var keyCommand = cli.Command{
+	Name:        "key",
+	Aliases:     []string{"keys"},
+	Usage:       "[global] key [command] [arguments...]",
+	Description: "Manages B2 Application Keys",
+	Subcommands: []cli.Command{
+		{
+			Name:        "create",
+			Aliases:     []string{"new"},
+			Usage:       "[global] key create [options] [name of new key]",
+			Description: "Creates New Application Key, the secret key is only displayed once",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "capability, c",
+					Usage: "capability of the key, repeat or separate with commas, e.g. listFiles,readFiles",
+				},
+				cli.StringFlag{
+					Name:  "bucket, b",
+					Usage: "restrict the key to the `bucket` with this name or id",
+				},
+				cli.StringFlag{
+					Name:  "prefix",
+					Usage: "restrict the key to file names starting with `prefix`, requires --bucket",
+				},
+				cli.DurationFlag{
+					Name:  "duration",
+					Usage: "key expires after `duration`, e.g. 24h, never if not set",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				checkDebug()
+				var capabilities []string
+				for _, capability := range c.StringSlice("capability") {
+					capabilities = append(capabilities, strings.Split(capability, ",")...)
+				}
+				if c.NArg() != 1 || len(capabilities) == 0 {
+					return cli.NewExitError("key name and at least one --capability required", 1)
+				}
+				if c.String("prefix") != "" && c.String("bucket") == "" {
+					return cli.NewExitError("--prefix requires --bucket", 1)
+				}
+				client := newClient()
+				key, err := client.CreateKey(c.Args().Get(0), capabilities, c.Duration("duration"), c.String("bucket"), c.String("prefix"))
+				if err != nil {
+					log.Fatal(err)
+				}
+				fmt.Printf("Key Created\nName: %v\nApplication Key ID: %v\nApplication Key: %v\n", key.KeyName, key.ApplicationKeyID, key.ApplicationKey)
+				return nil
+			},
+		},
+		{
+			Name:        "list",
+			Usage:       "[global] key list",
+			Description: "List all Application Keys in Account",
+			Action: func(c *cli.Context) error {
+				client := newClient()
+				keys, err := client.ListKeys()
+				if err != nil {
+					log.Fatal(err)
+				}
+				return gopherb2.PrintKeys(keys)
+			},
+		},
+		{
+			Name:        "delete",
+			Aliases:     []string{"rm"},
+			Usage:       "[global] key delete [application key id]",
+			Description: "Deletes Application Key",
+			Action: func(c *cli.Context) error {
+				checkDebug()
+				if c.NArg() != 1 {
+					return cli.NewExitError("application key id required", 1)
+				}
+				client := newClient()
+				key, err := client.DeleteKey(c.Args().Get(0))
+				if err != nil {
+					log.Fatal(err)
+				}
+				fmt.Printf("Key Deleted\nName: %v\nApplication Key ID: %v\n", key.KeyName, key.ApplicationKeyID)
+				return nil
+			},
+		},
+	},
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/dwin/gopherb2"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// largeCommand manages large file uploads that were started but never finished
+var largeCommand = cli.Command{
+	Name:        "large",
+	Usage:       "[global] large [command] [arguments...]",
+	Description: "Lists, cancels and resumes unfinished large file uploads, whose parts are billed until finished or cancelled",
+	Subcommands: []cli.Command{
+		{
+			Name:        "list",
+			Aliases:     []string{"ls"},
+			Usage:       "[global] large list [--prefix prefix] [bucket]",
+			Description: "Lists the unfinished large files of a bucket",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "prefix",
+					Usage: "list files with names starting with `prefix`",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				checkDebug()
+				if c.NArg() != 1 {
+					return cli.NewExitError("bucket name or id required", 1)
+				}
+				client := newClient()
+				ctx, stop := interruptContext()
+				defer stop()
+				var files []gopherb2.File
+				list := client.ListUnfinishedLargeFilesContext(ctx, c.Args().Get(0), gopherb2.ListOptions{Prefix: c.String("prefix")})
+				for list.Next() {
+					files = append(files, list.File())
+				}
+				if err := list.Err(); err != nil {
+					log.Fatal(err)
+				}
+				if len(files) == 0 {
+					fmt.Println("No unfinished large files")
+					return nil
+				}
+				return gopherb2.PrintUnfinishedLargeFiles(files)
+			},
+		},
+		{
+			Name:        "cancel",
+			Aliases:     []string{"rm"},
+			Usage:       "[global] large cancel [file id...] | large cancel --all [bucket]",
+			Description: "Cancels unfinished large files and deletes their uploaded parts",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "all",
+					Usage: "cancel every unfinished large file of the bucket",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				checkDebug()
+				if c.NArg() == 0 || (c.Bool("all") && c.NArg() != 1) {
+					return cli.NewExitError("file ids, or --all and bucket name or id required", 1)
+				}
+				client := newClient()
+				ctx, stop := interruptContext()
+				defer stop()
+				fileIDs := c.Args()
+				if c.Bool("all") {
+					fileIDs = nil
+					list := client.ListUnfinishedLargeFilesContext(ctx, c.Args().Get(0), gopherb2.ListOptions{})
+					for list.Next() {
+						fileIDs = append(fileIDs, list.File().FileID)
+					}
+					if err := list.Err(); err != nil {
+						log.Fatal(err)
+					}
+					if len(fileIDs) == 0 {
+						fmt.Println("No unfinished large files")
+						return nil
+					}
+					if !confirm(fmt.Sprintf("Cancel %v unfinished large files?", len(fileIDs))) {
+						return nil
+					}
+				}
+				for _, fileID := range fileIDs {
+					if err := client.CancelLargeFileContext(ctx, fileID); err != nil {
+						log.Fatal(err)
+					}
+					fmt.Printf("Cancelled %v\n", fileID)
+				}
+				return nil
+			},
+		},
+		{
+			Name:        "resume",
+			Usage:       "[global] large resume [bucket] [local file]",
+			Description: "Finishes an interrupted upload of a local file, uploading only the parts missing from the unfinished large file with the same name, size and SHA1",
+			Action: func(c *cli.Context) error {
+				checkDebug()
+				if c.NArg() != 2 {
+					return cli.NewExitError("bucket name or id and local file required", 1)
+				}
+				client := newClient()
+				ctx, stop := interruptContext()
+				defer stop()
+				finished, err := client.ResumeLargeFileUploadContext(ctx, c.Args().Get(0), c.Args().Get(1))
+				var largeFileErr *gopherb2.LargeFileError
+				if errors.As(err, &largeFileErr) {
+					fmt.Printf("\nLarge file %v incomplete, finished parts: %v\n", largeFileErr.FileID, largeFileErr.Finished)
+				}
+				if err != nil {
+					log.Fatal(err)
+				}
+				fmt.Printf("Upload Complete\nFilename: %v\nFileID: %v\n", finished.FileName, finished.FileID)
+				return nil
+			},
+		},
+	},
+}
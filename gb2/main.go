@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"path/filepath"
 
 	log "github.com/Sirupsen/logrus"
 	lumberjack "gopkg.in/natefinch/lumberjack.v2"
@@ -13,9 +18,11 @@ import (
 )
 
 var (
-	logDest string
-	debug   bool
-	logFile = "stderr"
+	logDest   string
+	debug     bool
+	profile   string
+	statePath string
+	logFile   = "stderr"
 )
 
 func main() {
@@ -47,6 +54,17 @@ func main() {
 			Usage:       "gb2 -log `gopher.log`",
 			Destination: &logDest,
 		},
+		cli.StringFlag{
+			Name:        "profile,p",
+			Usage:       "credentials `profile` of settings.toml to use, the default profile if not set",
+			Destination: &profile,
+		},
+		cli.StringFlag{
+			Name:        "state",
+			Value:       defaultStatePath(),
+			Usage:       "BoltDB `file` saving the progress of large file uploads, so a killed upload continues where it stopped",
+			Destination: &statePath,
+		},
 		cli.BoolFlag{
 			Name:        "debug,d",
 			Usage:       "`-debug|-d` [command]",
@@ -55,50 +73,49 @@ func main() {
 	}
 
 	app.Commands = []cli.Command{
+		bucketCommand,
 		{
-			Name:        "bucket",
-			Aliases:     []string{"buckets"},
-			Usage:       "[global] bucket [command] [arguments...]",
-			Description: "Manages B2 Buckets",
-			Subcommands: []cli.Command{
-				{
-					Name:        "create",
-					Aliases:     []string{"new"},
-					Usage:       "[global] bucket create [name of new bucket]",
-					Description: "Creates New Backblaze B2 Bucket",
-					Action: func(c *cli.Context) error {
-						checkDebug()
-						gopherb2.B2CreateBucket(c.Args().Get(0), false)
-						return nil
-					},
+			Name:        "upload",
+			Aliases:     []string{"put"},
+			Usage:       "[global] upload [--name name] [bucket] [path or file, - for stdin]",
+			Description: "Upload File to BackBlaze B2, or the data read from stdin with - and --name",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "name",
+					Usage: "store the upload as file `name`, required when reading stdin",
 				},
-				{
-					Name:        "list",
-					Usage:       "[global] bucket list",
-					Description: "List all Buckets in Account",
-					Action: func(c *cli.Context) error {
-						buckets, err := gopherb2.GetBuckets()
-						if err != nil {
-							log.Fatal(err)
-						}
-						err = gopherb2.PrintBuckets(buckets)
-						if err != nil {
-							log.Fatal(err)
-						}
-						return nil
-					},
+				cli.Int64Flag{
+					Name:  "memory",
+					Value: gopherb2.DefaultUploadMemoryBudget >> 20,
+					Usage: "`MiB` of memory large file parts are read from the file through, split between the parts in flight",
 				},
 			},
-		},
-		{
-			Name:        "upload",
-			Aliases:     []string{"put"},
-			Usage:       "[global] upload [bucket id] [path or file]",
-			Description: "Upload File to BackBlaze B2",
 			Action: func(c *cli.Context) error {
 				checkDebug()
-				gopherb2.UploadFile(c.Args().Get(0), c.Args().Get(1))
-				return nil
+				if c.NArg() != 2 {
+					return cli.NewExitError("bucket name or id and file, or - for stdin, required", 1)
+				}
+				if c.Args().Get(1) == "-" && c.String("name") == "" {
+					return cli.NewExitError("--name required when reading stdin", 1)
+				}
+				client := newClient()
+				defer client.Close()
+				client.UploadMemoryBudget = c.Int64("memory") << 20
+				ctx, stop := interruptContext()
+				defer stop()
+				if c.String("name") != "" {
+					return uploadReader(ctx, client, c.Args().Get(0), c.Args().Get(1), c.String("name"))
+				}
+				uploaded, err := client.UploadFileContext(ctx, c.Args().Get(0), c.Args().Get(1))
+				if err == nil {
+					fmt.Printf("\nUpload Complete\nFilename: %v\nFileID: %v\nSize: %v\n", uploaded.FileName, uploaded.FileID, uploaded.ContentLength)
+				}
+				var largeFileErr *gopherb2.LargeFileError
+				if errors.As(err, &largeFileErr) {
+					fmt.Printf("\nLarge file %v incomplete, finished parts: %v\n", largeFileErr.FileID, largeFileErr.Finished)
+					fmt.Println("Run the same upload again, or gb2 large resume, to upload only the missing parts")
+				}
+				return err
 			},
 		},
 		{
@@ -109,24 +126,78 @@ func main() {
 			Subcommands: []cli.Command{
 				{
 					Name:        "list",
-					Usage:       "[global] file list [bucketId]",
-					Description: "List all files in given Bucket",
+					Aliases:     []string{"ls"},
+					Usage:       "[global] file list [--prefix prefix] [--recursive] [bucket]",
+					Description: "List files in given Bucket like a directory, or all files below the prefix with --recursive",
 					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "prefix",
+							Usage: "list files with names starting with `prefix`, such as photos/",
+						},
 						cli.BoolFlag{
-							Name: "all",
+							Name:  "recursive, r",
+							Usage: "list files in all folders instead of the folders themselves",
 						},
 					},
 					Action: func(c *cli.Context) error {
-						if !c.Bool("all") {
-							fmt.Println("Non-Working: List All Files in all buckets")
+						if c.NArg() != 1 {
+							return cli.NewExitError("bucket name or id required", 1)
 						}
-
-						gopherb2.B2ListFilenames(c.Args().Get(0), "")
-						return nil
+						opts := gopherb2.ListOptions{Prefix: c.String("prefix")}
+						if !c.Bool("recursive") {
+							opts.Delimiter = "/"
+						}
+						client := newClient()
+						ctx, stop := interruptContext()
+						defer stop()
+						var files []gopherb2.File
+						list := client.ListFilesContext(ctx, c.Args().Get(0), opts)
+						for list.Next() {
+							files = append(files, list.File())
+						}
+						if err := list.Err(); err != nil {
+							log.Fatal(err)
+						}
+						return gopherb2.PrintFiles(files)
 					},
 				},
+				{
+					Name:        "versions",
+					Aliases:     []string{"history"},
+					Usage:       "[global] file versions [bucket] [file name]",
+					Description: "List every version of a file, newest first, including hide markers and unfinished large files",
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 2 {
+							return cli.NewExitError("bucket name or id and file name required", 1)
+						}
+						name := c.Args().Get(1)
+						client := newClient()
+						ctx, stop := interruptContext()
+						defer stop()
+						var versions []gopherb2.File
+						list := client.ListFileVersionsContext(ctx, c.Args().Get(0), gopherb2.ListOptions{Prefix: name, StartFileName: name})
+						for list.Next() && list.File().FileName == name {
+							versions = append(versions, list.File())
+						}
+						if err := list.Err(); err != nil {
+							log.Fatal(err)
+						}
+						if len(versions) == 0 {
+							return cli.NewExitError("No versions of "+name, 1)
+						}
+						return gopherb2.PrintFileVersions(versions)
+					},
+				},
+				fileInfoCommand,
+				fileRemoveCommand,
+				fileHideCommand,
 			},
 		},
+		downloadCommand,
+		copyCommand,
+		shareCommand,
+		largeCommand,
+		keyCommand,
 		{
 			Name:        "version",
 			Aliases:     []string{"v"},
@@ -144,6 +215,57 @@ func main() {
 	app.Run(os.Args)
 }
 
+// newClient authorizes with the B2 account of the selected profile, exiting if that is not possible
+func newClient() *gopherb2.Client {
+	config, err := gopherb2.LoadProfile(profile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client, err := gopherb2.NewClient(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client.StatePath = statePath
+	return client
+}
+
+// uploadReader uploads stdin, or the local file at path, to bucket as fileName
+func uploadReader(ctx context.Context, client *gopherb2.Client, bucket string, path string, fileName string) error {
+	r, size := io.Reader(os.Stdin), int64(gopherb2.UnknownSize)
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		fileInfo, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		r, size = file, fileInfo.Size()
+	}
+	uploaded, err := client.UploadReaderContext(ctx, bucket, fileName, r, size)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nUpload Complete\nFilename: %v\nFileID: %v\nSize: %v\n", uploaded.FileName, uploaded.FileID, uploaded.ContentLength)
+	return nil
+}
+
+// defaultStatePath returns the upload state file in the home directory, or none if it is unknown
+func defaultStatePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".gb2.db")
+}
+
+// interruptContext returns a context cancelled on the first interrupt signal, so transfers stop cleanly
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
 func checkDebug() {
 	if debug {
 		fmt.Println("debug on")
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/dwin/gopherb2"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// shareCommand prints a download URL for a file of a private bucket that works without credentials
+var shareCommand = cli.Command{
+	Name:        "share",
+	Usage:       "[global] share [options] [bucket] [file name]",
+	Description: "Prints a URL anyone can use to download the file until it expires, even from a private bucket. The token in the URL is valid for every file with a name starting with the file name, such as name.bak or name/other.",
+	Flags: []cli.Flag{
+		cli.DurationFlag{
+			Name:  "expires",
+			Value: 24 * time.Hour,
+			Usage: "how long the URL is valid, such as 30m or 72h, at most 168h",
+		},
+		cli.StringFlag{
+			Name:  "content-disposition",
+			Usage: "`header` sent as Content-Disposition of the download, such as attachment; filename=\"build.zip\"",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		checkDebug()
+		if c.NArg() != 2 {
+			return cli.NewExitError("bucket name or id and file name required", 1)
+		}
+		if expires := c.Duration("expires"); expires < time.Second || expires > gopherb2.MaxDownloadAuthorizationDuration {
+			return cli.NewExitError("--expires must be between 1s and 168h", 1)
+		}
+		client := newClient()
+		ctx, stop := interruptContext()
+		defer stop()
+		shared, err := client.ShareURLContext(ctx, c.Args().Get(0), c.Args().Get(1), gopherb2.DownloadAuthorizationOptions{
+			ValidDuration:      c.Duration("expires"),
+			ContentDisposition: c.String("content-disposition"),
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%v\nExpires: %v\n", shared, time.Now().Add(c.Duration("expires")).Format("2006-01-02 15:04:05"))
+		return nil
+	},
+}
@@ -1,21 +1,17 @@
 package gopherb2
 
-// TODO: Read uploads from disk to reduce memory usage? optional?
-// TODO: Store Auth Info to reduce API requests, use old and re-auth if needed?
 // TODO: Check if existing file?
-// TODO: Check if unfinished large file?
 // TODO: Increase chunk size to reduce number of uploads?
-// TODO: Retry failed uploads/parts
 // TODO: Add ability to set logging level
 // TODO: Log to file
 // TODO: Upload progress
-// TODO: Upload timeout?
 // TODO: Automatically select standard or large file upload
 // TODO: Organize package
 // TODO: Check for success on all files or resend, timeout? num of tries?
 // TODO: Limit number of simultaneous uploads
 // TODO: Encrypt files before upload
 import (
+	"encoding/json"
 	"net/http"
 	"os"
 
@@ -36,11 +32,14 @@ type Configuration struct {
 	AcctID string
 	AppID  string
 	APIURL string
+	// APIVersion is the B2 native API version used, DefaultAPIVersion if empty
+	APIVersion string
 }
 type Response struct {
-	Header http.Header
-	Status string
-	Body   []byte
+	Header     http.Header
+	Status     string
+	StatusCode int
+	Body       []byte
 }
 
 type UploadPartResponse struct {
@@ -48,17 +47,84 @@ type UploadPartResponse struct {
 	FileID             string `json:"fileId"`
 	UploadURL          string `json:"uploadUrl"`
 }
-type B2File struct {
-	AccountID   string `json:"accountId"`
-	BucketID    string `json:"bucketId"`
-	ContentType string `json:"contentType"`
-	FileID      string `json:"fileId"`
-	FileInfo    struct {
-		LargeFileSHA1          string `json:"large_file_sha1"`
-		LastModificationMillis int64  `json:"src_last_modified_millis,string"`
-	} `json:"fileInfo"`
-	FileName        string `json:"fileName"`
-	UploadTimestamp int64  `json:"uploadTimestamp"`
+
+// ServerSideEncryption is the encryption of a file, or the default encryption of a bucket.
+// Mode is empty or "none" for unencrypted files, "SSE-B2" or "SSE-C" otherwise.
+type ServerSideEncryption struct {
+	Mode      string `json:"mode"`
+	Algorithm string `json:"algorithm"`
+}
+
+// FileRetention is the Object Lock retention of a file, Mode is empty if the file has none
+type FileRetention struct {
+	Mode                 string `json:"mode"`
+	RetainUntilTimestamp int64  `json:"retainUntilTimestamp"`
+}
+
+// LegalHold is the legal hold status of a file, "on", "off" or empty if not set
+type LegalHold string
+
+// protectedValue is the layout B2 uses for settings the key may not be authorized to read
+type protectedValue struct {
+	IsClientAuthorizedToRead *bool           `json:"isClientAuthorizedToRead"`
+	Value                    json.RawMessage `json:"value"`
+}
+
+// unwrapProtected returns the value of data if it uses the protectedValue layout, otherwise data itself
+func unwrapProtected(data []byte) []byte {
+	var protected protectedValue
+	if json.Unmarshal(data, &protected) == nil && protected.IsClientAuthorizedToRead != nil {
+		return protected.Value
+	}
+	return data
+}
+
+// UnmarshalJSON decodes encryption settings, unwrapping the value of bucket default settings
+func (e *ServerSideEncryption) UnmarshalJSON(data []byte) error {
+	type plain ServerSideEncryption
+	data = unwrapProtected(data)
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+	return json.Unmarshal(data, (*plain)(e))
+}
+
+// UnmarshalJSON decodes a file retention, unwrapping the value of the protected layout
+func (r *FileRetention) UnmarshalJSON(data []byte) error {
+	var value struct {
+		Mode                 *string `json:"mode"`
+		RetainUntilTimestamp *int64  `json:"retainUntilTimestamp"`
+	}
+	data = unwrapProtected(data)
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	if value.Mode != nil {
+		r.Mode = *value.Mode
+	}
+	if value.RetainUntilTimestamp != nil {
+		r.RetainUntilTimestamp = *value.RetainUntilTimestamp
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes a legal hold, unwrapping the value of the protected layout
+func (l *LegalHold) UnmarshalJSON(data []byte) error {
+	var value *string
+	data = unwrapProtected(data)
+	if len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	if value != nil {
+		*l = LegalHold(*value)
+	}
+	return nil
 }
 
 func init() {
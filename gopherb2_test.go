@@ -1,153 +1,1781 @@
 package gopherb2
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"testing/iotest"
+	"time"
+
+	blake2b "github.com/dsjr2006/blake2b-simd"
+	"github.com/dwin/gopherb2/b2test"
+	"github.com/spf13/viper"
 )
 
-// TODO: Create test files programmatically
+// testClient starts a fake B2 server and returns a Client authorized with it
+func testClient(t *testing.T) (*Client, *b2test.Server) {
+	srv := b2test.NewServer()
+	t.Cleanup(srv.Close)
+	c, err := NewClient(Configuration{
+		AcctID: srv.AccountID,
+		AppID:  srv.ApplicationKey,
+		APIURL: srv.APIURL(),
+	})
+	if err != nil {
+		t.Fatalf("Could not authorize with fake B2 server: %v", err)
+	}
+	c.Retry = RetryPolicy{MaxRetries: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+	return c, srv
+}
+
+// raceEnabled is set by race_test.go when testing with the race detector
+var raceEnabled = false
+
+// testBucket creates a bucket on the fake server and returns its ID
+func testBucket(t *testing.T, c *Client) string {
+	bucket, err := c.CreateBucket("test-bucket", false)
+	if err != nil {
+		t.Fatalf("Could not create bucket: %v", err)
+	}
+	return bucket.BucketID
+}
+
+// testFileNames returns the names of all files in bucketID
+func testFileNames(t *testing.T, c *Client, bucketID string) []string {
+	var allFiles allFiles
+	err := c.apiCall(context.Background(), "b2_list_file_names", map[string]string{"bucketId": bucketID}, &allFiles)
+	if err != nil {
+		t.Fatalf("Could not list files: %v", err)
+	}
+	var names []string
+	for _, f := range allFiles.File {
+		names = append(names, f.FileName)
+	}
+	return names
+}
+
+// testLargeFile writes a file just over one 100MB piece, so it is split in two parts, and returns its path
+func testLargeFile(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "large.bin")
+	data := make([]byte, 100*(1<<20)+1024)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
 
 // TestToReturnNewB2File does that
 func TestToReturnNewB2File(t *testing.T) {
-	b2F, err := NewB2File("/Users/dsjr2006/Dev/golang/src/github.com/dwin/gopherb2/testfile.txt")
+	b2F, err := NewB2File("testfile.txt")
 	if err != nil {
-		fmt.Printf("Error: %v", err)
-		return
+		t.Fatalf("Error: %v", err)
 	}
-	for i := 0; i < len(b2F.Piece); i++ {
-		fmt.Printf("\nFile Piece %v- Size: %v - SHA1: %v", i, b2F.Piece[i].Size, b2F.Piece[i].SHA1)
+	sha1, _ := fileSHA1("testfile.txt")
+	if len(b2F.Piece) != 1 || b2F.Piece[0].SHA1 != sha1 || b2F.SHA1 != sha1 {
+		t.Errorf("Expected one piece with SHA1 %v, got %+v", sha1, b2F.Piece)
+	}
+	if b2F.Blake2b == "" {
+		t.Error("Expected Blake2b hash to be set")
 	}
-	fmt.Printf("\nFile Blake2b: %v", b2F.Blake2b)
-	fmt.Println("\n^ New B2 File Test Completed\n ")
-	return
 }
 
-// TestToReturnNewB2File does that
+// TestToUploadNewStandardB2File does that
 func TestToUploadNewStandardB2File(t *testing.T) {
-	b2F, err := NewB2File("/Users/dsjr2006/Dev/golang/src/github.com/dwin/gopherb2/testfile.txt")
+	c, _ := testClient(t)
+	bucketID := testBucket(t, c)
+	b2F, err := NewB2File("testfile.txt")
 	if err != nil {
-		fmt.Printf("Error: %v", err)
-		return
+		t.Fatalf("Error: %v", err)
 	}
-	for i := 0; i < len(b2F.Piece); i++ {
-		fmt.Printf("\nFile Piece %v- Size: %v - SHA1: %v", i, b2F.Piece[i].Size, b2F.Piece[i].SHA1)
-	}
-	err = b2F.Upload("b6ee61624837a6c6588b0715")
+	err = b2F.Upload(c, bucketID)
 	if err != nil {
-		fmt.Printf("Could not upload file. Error: %v", err)
+		t.Fatalf("Could not upload file. Error: %v", err)
+	}
+	if names := testFileNames(t, c, bucketID); len(names) != 1 || names[0] != "testfile.txt" {
+		t.Errorf("Expected testfile.txt in bucket, got %v", names)
 	}
-	fmt.Printf("\nFile Blake2b: %v", b2F.Blake2b)
-	fmt.Println("\n^ Standard B2 File Upload Test Completed\n ")
-	return
 }
 
 // TestToReturnNewLargeB2File
 func TestToReturnNewLargeB2File(t *testing.T) {
-<<<<<<< HEAD
-	b2F, err := NewB2File("/Users/dsjr2006/Downloads/LibreOffice_5.3.0_MacOS_x86-64.dmg")
-=======
-	//b2F, err := NewB2File("/Users/dsjr2006/Downloads/megan@allaboutent.com.zip") // ~ 3GB
-	b2F, err := NewB2File("/Users/dsjr2006/Downloads/LibreOffice_5.3.0_MacOS_x86-64.dmg") // ~ 250MB
->>>>>>> Development
+	if testing.Short() {
+		t.Skip("Skipping large file upload in short mode")
+	}
+	c, _ := testClient(t)
+	bucketID := testBucket(t, c)
+	b2F, err := NewB2File(testLargeFile(t))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(b2F.Piece) != 2 {
+		t.Fatalf("Expected 2 pieces, got %v", len(b2F.Piece))
+	}
+
+	err = b2F.Upload(c, bucketID)
 	if err != nil {
-		fmt.Printf("Error: %v", err)
-		return
+		t.Fatalf("Could not upload file. Error: %v", err)
 	}
+	if names := testFileNames(t, c, bucketID); len(names) != 1 || names[0] != "large.bin" {
+		t.Errorf("Expected large.bin in bucket, got %v", names)
+	}
+}
 
-	for i := 0; i < len(b2F.Piece); i++ {
-		fmt.Printf("\nFile Piece %v- Size: %v - SHA1: %v", i, b2F.Piece[i].Size, b2F.Piece[i].SHA1)
+// Test cancelling a large upload stops the remaining parts and reports the finished ones
+func TestToCancelLargeB2FileUpload(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping large file upload in short mode")
+	}
+	c, srv := testClient(t)
+	bucketID := testBucket(t, c)
+	b2F, err := NewB2File(testLargeFile(t))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
 	}
-	fmt.Printf("\nFile Blake2b: %v", b2F.Blake2b)
-<<<<<<< HEAD
-	fmt.Println("\n^ New Large B2 File Test Completed\n")
-=======
-	fmt.Println("\n^ New Large B2 File Test Completed\n ")
 
-	fmt.Println("Upload Test..")
-	err = b2F.Upload("b6ee61624837a6c6588b0715")
+	// Upload one part at a time and cancel as soon as the first part is uploaded
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.UploadConcurrency = 1
+	c.HTTPClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err == nil && srv.Calls("b2_upload_part") == 1 {
+			cancel()
+		}
+		return resp, err
+	})}
+	err = b2F.UploadContext(ctx, c, bucketID)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	var largeFileErr *LargeFileError
+	if !errors.As(err, &largeFileErr) || len(largeFileErr.Finished) != 1 || largeFileErr.Finished[0] != 1 || len(largeFileErr.Failed) != 1 || largeFileErr.Failed[0] != 2 {
+		t.Errorf("Expected part 1 finished and part 2 not, got %+v", largeFileErr)
+	}
+	if b2F.Piece[0].Status != "Success" || b2F.Piece[1].Status == "Success" {
+		t.Errorf("Unexpected piece status %v and %v", b2F.Piece[0].Status, b2F.Piece[1].Status)
+	}
+	if calls := srv.Calls("b2_finish_large_file"); calls != 0 {
+		t.Errorf("Expected cancelled large file not to be finished, got %v calls", calls)
+	}
+
+	// Uploading the same file again resumes the unfinished large file with the missing part
+	c.HTTPClient = nil
+	if err := b2F.Upload(c, bucketID); err != nil {
+		t.Fatalf("Could not resume upload: %v", err)
+	}
+	if starts, parts := srv.Calls("b2_start_large_file"), srv.Calls("b2_upload_part"); starts != 1 || parts != 2 || b2F.FileID != largeFileErr.FileID {
+		t.Errorf("Expected large file %v resumed with one more part, got %v starts and %v parts for %v", largeFileErr.FileID, starts, parts, b2F.FileID)
+	}
+}
+
+// Test large files are uploaded from the source file, hashing parts as they are sent, without temp files
+func TestToUploadLargeFileWithoutTempFiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping large file upload in short mode")
+	}
+	c, srv := testClient(t)
+	bucketID := testBucket(t, c)
+	path := testLargeFile(t)
+	tempDir := t.TempDir()
+	t.Setenv("TMPDIR", tempDir)
+	c.UploadMemoryBudget = 128 << 10
+	finished, err := c.LargeFileUpload(bucketID, path)
+	if err != nil {
+		t.Fatalf("Could not upload large file: %v", err)
+	}
+	if calls := srv.Calls("b2_upload_part"); calls != 2 {
+		t.Errorf("Expected 2 parts, got %v", calls)
+	}
+	if entries, err := ioutil.ReadDir(tempDir); err != nil || len(entries) != 0 {
+		t.Errorf("Expected no temp files, got %v, %v", entries, err)
+	}
+	want, err := ioutil.ReadFile(path)
 	if err != nil {
-		fmt.Printf("Could not upload file. Error: %v", err)
-		fmt.Println("Could not complete Large B2 File Test.")
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := c.DownloadFileByID(finished.FileID, &buf, DownloadOptions{}); err != nil || !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Uploaded large file does not match, %v", err)
+	}
+}
+
+// Test the upload memory budget is split between the parts in flight
+func TestToSplitUploadMemoryBudget(t *testing.T) {
+	tests := []struct {
+		budget      int64
+		concurrency int
+		parts       int
+		bufferSize  int
+	}{
+		{0, 4, 4, DefaultUploadMemoryBudget / 4},
+		{8 << 20, 4, 4, 2 << 20},
+		{128 << 10, 4, 2, 64 << 10},
+		{1, 4, 1, 64 << 10},
+		{1 << 40, 2, 2, largeFilePartSize},
+	}
+	for _, test := range tests {
+		c := &Client{UploadConcurrency: test.concurrency, UploadMemoryBudget: test.budget}
+		if parts, bufferSize := c.uploadReadBuffers(); parts != test.parts || bufferSize != test.bufferSize {
+			t.Errorf("Budget %v: expected %v parts with %v byte buffers, got %v with %v", test.budget, test.parts, test.bufferSize, parts, bufferSize)
+		}
+	}
+}
+
+// Test credentials are loaded from named profiles with environment overrides
+func TestToLoadProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.toml")
+	settings := `DefaultProfile = "prod"
+[prod]
+  AcctID = "prodacct"
+  AppID = "prodapp"
+[staging]
+  AcctID = "stagingacct"
+  AppID = "stagingapp"
+  APIURL = "https://staging.example.com/b2api/v1/"
+`
+	if err := ioutil.WriteFile(path, []byte(settings), 0600); err != nil {
+		t.Fatal(err)
+	}
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if names := profiles(v); len(names) != 2 || names[0] != "prod" || names[1] != "staging" {
+		t.Errorf("Expected profiles prod and staging, got %v", names)
+	}
+
+	config, err := loadProfile(v, "")
+	if err != nil || config.AcctID != "prodacct" || config.AppID != "prodapp" {
+		t.Errorf("Expected default profile prod, got %+v, %v", config, err)
+	}
+	config, err = loadProfile(v, "staging")
+	if err != nil || config.AcctID != "stagingacct" || config.APIURL != "https://staging.example.com/b2api/v1/" {
+		t.Errorf("Expected profile staging, got %+v, %v", config, err)
+	}
+	if _, err = loadProfile(v, "archive"); err == nil {
+		t.Error("Expected error for missing profile archive")
+	}
+
+	// Profile environment variables override the file, and may define a profile on their own
+	t.Setenv("B2AppID_STAGING", "envapp")
+	t.Setenv("B2AcctID_ARCHIVE", "archiveacct")
+	t.Setenv("B2AppID_ARCHIVE", "archiveapp")
+	config, err = loadProfile(v, "staging")
+	if err != nil || config.AcctID != "stagingacct" || config.AppID != "envapp" {
+		t.Errorf("Expected B2AppID_STAGING to override profile staging, got %+v, %v", config, err)
+	}
+	config, err = loadProfile(v, "archive")
+	if err != nil || config.AcctID != "archiveacct" {
+		t.Errorf("Expected profile archive from environment, got %+v, %v", config, err)
+	}
+	t.Setenv("B2Profile", "staging")
+	if config, _ = loadProfile(v, ""); config.AcctID != "stagingacct" {
+		t.Errorf("Expected B2Profile to select staging, got %+v", config)
 	}
->>>>>>> Development
-	return
 }
 
 // Test authorizeAccount
 func TestToReturnAuthorization(t *testing.T) {
-	apiResponse := AuthorizeAcct()
-	fmt.Println("\nAccount ID: " + apiResponse.AccountID)
-	fmt.Println("\n^ Authorization Test Completed\n ")
+	c, srv := testClient(t)
+	if c.Authorization().AccountID != srv.AccountID {
+		t.Errorf("Expected Account ID %v, got %v", srv.AccountID, c.Authorization().AccountID)
+	}
+	_, err := NewClient(Configuration{AcctID: srv.AccountID, AppID: "wrong", APIURL: srv.APIURL()})
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected ErrUnauthorized for wrong application key, got %v", err)
+	}
+}
+
+// Test expired authorization tokens are renewed once
+func TestToReauthorizeExpiredToken(t *testing.T) {
+	c, srv := testClient(t)
+	token := c.Authorization().AuthorizationToken
+	srv.ExpireTokens()
+	if _, err := c.GetBuckets(); err != nil {
+		t.Fatalf("Expected re-authorization to succeed, got %v", err)
+	}
+	if c.Authorization().AuthorizationToken == token {
+		t.Error("Expected a new authorization token")
+	}
+	if calls := srv.Calls("b2_authorize_account"); calls != 2 {
+		t.Errorf("Expected 2 authorizations, got %v", calls)
+	}
+}
+
+// Test re-authorizing within a retried request does not retry on its own, and local errors are
+// not retried
+func TestToRetryOnlyTransportErrors(t *testing.T) {
+	c, srv := testClient(t)
+	srv.ExpireTokens()
+	srv.FailNext("b2_authorize_account", 100, http.StatusServiceUnavailable, "service_unavailable")
+	if _, err := c.GetBuckets(); err == nil {
+		t.Fatal("Expected re-authorization to fail")
+	}
+	// One authorization attempt per attempt of the request, besides the first of testClient
+	if calls := srv.Calls("b2_authorize_account") - 1; calls != c.Retry.MaxRetries+1 {
+		t.Errorf("Expected %d authorization attempts, got %v", c.Retry.MaxRetries+1, calls)
+	}
+
+	for _, err := range []error{ErrSHA1Mismatch, &json.SyntaxError{}, errors.New("stream cannot be read again")} {
+		if retryable(err) {
+			t.Errorf("Expected %v not to be retried", err)
+		}
+	}
+	for _, err := range []error{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, io.ErrUnexpectedEOF, &APIError{Status: http.StatusServiceUnavailable}} {
+		if !retryable(err) {
+			t.Errorf("Expected %v to be retried", err)
+		}
+	}
+}
+
+// Test concurrent re-authorizations share one authorization that does not block readers while retrying
+func TestToShareReauthorization(t *testing.T) {
+	c, srv := testClient(t)
+	token := c.Authorization().AuthorizationToken
+	c.Retry = RetryPolicy{MaxRetries: 3, InitialBackoff: 200 * time.Millisecond, MaxBackoff: 200 * time.Millisecond}
+	srv.FailNext("b2_authorize_account", 1, http.StatusServiceUnavailable, "service_unavailable")
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = c.reauthorize(context.Background(), token, true)
+		}(i)
+	}
+	// The first attempt failed and the authorization is waiting to retry
+	for srv.Calls("b2_authorize_account") < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	start := time.Now()
+	if c.Authorization().AuthorizationToken != token || time.Since(start) > 50*time.Millisecond {
+		t.Error("Expected the current authorization to be readable during re-authorization")
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("Could not re-authorize: %v", err)
+		}
+	}
+	if calls := srv.Calls("b2_authorize_account"); calls != 3 {
+		t.Errorf("Expected one shared authorization retried once, got %v calls", calls)
+	}
+	if c.Authorization().AuthorizationToken == token {
+		t.Error("Expected a new authorization token")
+	}
+}
+
+// Test application keys are created, listed and deleted, and restricted keys fail early
+func TestToManageKeys(t *testing.T) {
+	c, srv := testClient(t)
+	bucketID := testBucket(t, c)
+	key, err := c.CreateKey("readonly", []string{CapListBuckets, CapListFiles}, time.Hour, bucketID, "photos/")
+	if err != nil {
+		t.Fatalf("Could not create key: %v", err)
+	}
+	if key.ApplicationKey == "" || key.BucketID != bucketID || key.NamePrefix != "photos/" || key.ExpirationTimestamp == 0 {
+		t.Errorf("Unexpected key %+v", key)
+	}
+	keys, err := c.ListKeys()
+	if err != nil || len(keys) != 1 || keys[0].ApplicationKeyID != key.ApplicationKeyID || keys[0].ApplicationKey != "" {
+		t.Errorf("Expected created key without secret, got %+v, %v", keys, err)
+	}
+
+	restricted, err := NewClient(Configuration{AcctID: key.ApplicationKeyID, AppID: key.ApplicationKey, APIURL: srv.APIURL()})
+	if err != nil {
+		t.Fatalf("Could not authorize with restricted key: %v", err)
+	}
+	allowed := restricted.Authorization().Allowed
+	if allowed.BucketID != bucketID || allowed.BucketName != "test-bucket" || allowed.NamePrefix != "photos/" || !allowed.HasCapability(CapListFiles) {
+		t.Errorf("Unexpected allowed block %+v", allowed)
+	}
+	if buckets, err := restricted.GetBuckets(); err != nil || len(buckets.Bucket) != 1 {
+		t.Errorf("Expected restricted key to list its bucket, got %+v, %v", buckets, err)
+	}
+	calls := srv.Calls("b2_get_upload_url")
+	var capErr *CapabilityError
+	if _, err := restricted.UploadFile(bucketID, "testfile.txt"); !errors.As(err, &capErr) || capErr.Capability != CapWriteFiles {
+		t.Errorf("Expected CapabilityError for writeFiles, got %v", err)
+	}
+	if _, err := restricted.CreateBucket("other-bucket", false); !errors.As(err, &capErr) {
+		t.Errorf("Expected CapabilityError for writeBuckets, got %v", err)
+	}
+	if err := restricted.ListFilenames("otherbucket", ""); !errors.As(err, &capErr) || capErr.BucketID != "otherbucket" {
+		t.Errorf("Expected CapabilityError for other bucket, got %v", err)
+	}
+	if srv.Calls("b2_get_upload_url") != calls || srv.Calls("b2_create_bucket") != 1 {
+		t.Error("Expected restricted operations to fail without calling the API")
+	}
+
+	// Keys restricted to a name prefix fail early for other names
+	writeKey, err := c.CreateKey("photos", []string{CapListFiles, CapReadFiles, CapWriteFiles}, 0, bucketID, "photos/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := NewClient(Configuration{AcctID: writeKey.ApplicationKeyID, AppID: writeKey.ApplicationKey, APIURL: srv.APIURL()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls, uploads := srv.Calls("b2_get_upload_url"), srv.Calls("b2_upload_file")
+	if _, err := writer.UploadReader(bucketID, "notes.txt", strings.NewReader("notes"), 5); !errors.As(err, &capErr) || capErr.NamePrefix != "photos/" || capErr.FileName != "notes.txt" {
+		t.Errorf("Expected CapabilityError for file name outside the prefix, got %v", err)
+	}
+	if srv.Calls("b2_get_upload_url") != calls || srv.Calls("b2_upload_file") != uploads {
+		t.Error("Expected upload outside the name prefix to fail without calling the API")
+	}
+	if _, err := writer.HideFile(bucketID, "notes.txt"); !errors.As(err, &capErr) || capErr.NamePrefix != "photos/" {
+		t.Errorf("Expected CapabilityError hiding a file outside the prefix, got %v", err)
+	}
+	if _, err := writer.DownloadFileByName(bucketID, "notes.txt", ioutil.Discard, DownloadOptions{}); !errors.As(err, &capErr) || capErr.NamePrefix != "photos/" {
+		t.Errorf("Expected CapabilityError downloading a file outside the prefix, got %v", err)
+	}
+	files := writer.ListFiles(bucketID, ListOptions{})
+	if files.Next() || !errors.As(files.Err(), &capErr) || capErr.NamePrefix != "photos/" {
+		t.Errorf("Expected CapabilityError listing outside the prefix, got %v", files.Err())
+	}
+	if _, err := writer.UploadReader(bucketID, "photos/cat.jpg", strings.NewReader("meow"), 4); err != nil {
+		t.Errorf("Expected upload within the name prefix, got %v", err)
+	}
+	files = writer.ListFiles(bucketID, ListOptions{Prefix: "photos/"})
+	if !files.Next() || files.File().FileName != "photos/cat.jpg" {
+		t.Errorf("Expected listing within the prefix, got %v", files.Err())
+	}
+	if _, err := c.DeleteKey(writeKey.ApplicationKeyID); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := c.DeleteKey(key.ApplicationKeyID)
+	if err != nil || deleted.ApplicationKeyID != key.ApplicationKeyID {
+		t.Errorf("Could not delete key: %+v, %v", deleted, err)
+	}
+	if keys, _ := c.ListKeys(); len(keys) != 0 {
+		t.Errorf("Expected no keys after delete, got %+v", keys)
+	}
+}
+
+// Test authorization and restricted keys with every API version, including legacy API URLs
+// Test file sizes are listed under every API version, version 1 returning size for contentLength
+func TestToListFileSizesAPIVersions(t *testing.T) {
+	c, srv := testClient(t)
+	bucketID := testBucket(t, c)
+	srv.AddFile(bucketID, "notes.txt", []byte("version 1"))
+	srv.AddFile(bucketID, "notes.txt", []byte("second version"))
+	for _, version := range []string{APIv1, APIv2, APIv3} {
+		vc, err := NewClient(Configuration{AcctID: srv.AccountID, AppID: srv.ApplicationKey, APIURL: srv.APIURL(), APIVersion: version})
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := vc.ListFiles(bucketID, ListOptions{})
+		if !files.Next() || files.File().ContentLength != 14 {
+			t.Errorf("%v: expected listed file of 14 bytes, got %+v, %v", version, files.File(), files.Err())
+		}
+		var sizes []int64
+		versions := vc.ListFileVersions(bucketID, ListOptions{})
+		for versions.Next() {
+			sizes = append(sizes, versions.File().ContentLength)
+		}
+		if versions.Err() != nil || len(sizes) != 2 || sizes[0] != 14 || sizes[1] != 9 {
+			t.Errorf("%v: unexpected version sizes %v, %v", version, sizes, versions.Err())
+		}
+		var listErr error
+		printed := captureStdout(t, func() { listErr = vc.ListFilenames(bucketID, "") })
+		if listErr != nil || !strings.Contains(printed, "Size: 14") {
+			t.Errorf("%v: expected ListFilenames to print the size, got %q, %v", version, printed, listErr)
+		}
+	}
+}
+
+// captureStdout returns what f writes to os.Stdout
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	printed := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		printed <- string(data)
+	}()
+	f()
+	w.Close()
+	return <-printed
+}
+
+func TestToAuthorizeAPIVersions(t *testing.T) {
+	c, srv := testClient(t)
+	bucketID := testBucket(t, c)
+	key, err := c.CreateKey("bucketkey", []string{CapListBuckets, CapListFiles}, 0, bucketID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range []string{APIv1, APIv2, APIv3} {
+		for _, apiURL := range []string{srv.APIURL(), srv.URL + "/b2api/v1/"} {
+			vc, err := NewClient(Configuration{AcctID: key.ApplicationKeyID, AppID: key.ApplicationKey, APIURL: apiURL, APIVersion: version})
+			if err != nil {
+				t.Fatalf("%v %v: could not authorize: %v", version, apiURL, err)
+			}
+			auth := vc.Authorization()
+			if auth.ApiURL != srv.URL || auth.DownloadURL != srv.URL || auth.S3ApiURL == "" || auth.RecommendedPartSize == 0 {
+				t.Errorf("%v: unexpected authorization %+v", version, auth)
+			}
+			if auth.Allowed.BucketID != bucketID || auth.Allowed.BucketName != "test-bucket" || !auth.Allowed.AllowsBucket(bucketID) || auth.Allowed.AllowsBucket("other") {
+				t.Errorf("%v: unexpected allowed block %+v", version, auth.Allowed)
+			}
+			if err := vc.ListFilenames(bucketID, ""); err != nil {
+				t.Errorf("%v: could not list files: %v", version, err)
+			}
+		}
+	}
+	if _, err := NewClient(Configuration{AcctID: srv.AccountID, AppID: srv.ApplicationKey, APIURL: srv.APIURL(), APIVersion: "v9"}); err == nil {
+		t.Error("Expected error for unsupported API version")
+	}
+	if url := downloadByNameURL(APIAuthorization{DownloadURL: "https://f001.backblazeb2.com"}, "bucket", "dir/a b+c.txt"); url != "https://f001.backblazeb2.com/file/bucket/dir/a%20b+c.txt" {
+		t.Errorf("Unexpected download URL %v", url)
+	}
+}
+
+// Test retention, legal hold and encryption fields of newer API versions are decoded
+func TestToDecodeFileProtection(t *testing.T) {
+	var f File
+	body := `{"fileId": "4_z1", "fileRetention": {"isClientAuthorizedToRead": true, "value": {"mode": "governance", "retainUntilTimestamp": 1700000000000}},
+		"legalHold": {"isClientAuthorizedToRead": true, "value": "on"},
+		"serverSideEncryption": {"algorithm": "AES256", "mode": "SSE-B2"}}`
+	if err := json.Unmarshal([]byte(body), &f); err != nil {
+		t.Fatal(err)
+	}
+	if f.FileRetention.Mode != "governance" || f.FileRetention.RetainUntilTimestamp != 1700000000000 || f.LegalHold != "on" || f.ServerSideEncryption.Mode != "SSE-B2" {
+		t.Errorf("Unexpected file protection %+v %+v %+v", f.FileRetention, f.LegalHold, f.ServerSideEncryption)
+	}
+	var b Bucket
+	body = `{"bucketId": "b1", "defaultServerSideEncryption": {"isClientAuthorizedToRead": true, "value": {"algorithm": "AES256", "mode": "SSE-B2"}}}`
+	if err := json.Unmarshal([]byte(body), &b); err != nil || b.DefaultServerSideEncryption.Algorithm != "AES256" {
+		t.Errorf("Unexpected bucket encryption %+v, %v", b.DefaultServerSideEncryption, err)
+	}
+	f = File{}
+	body = `{"fileRetention": {"isClientAuthorizedToRead": false, "value": null}, "legalHold": {"isClientAuthorizedToRead": true, "value": null}}`
+	if err := json.Unmarshal([]byte(body), &f); err != nil || f.FileRetention.Mode != "" || f.LegalHold != "" {
+		t.Errorf("Expected empty protection, got %+v, %v", f, err)
+	}
 }
 
 // Test listBuckets
 func TestToPrintBucketList(t *testing.T) {
-	buckets, err := GetBuckets()
+	c, _ := testClient(t)
+	testBucket(t, c)
+	buckets, err := c.GetBuckets()
 	if err != nil {
-		fmt.Println("Could not get buckets.")
+		t.Fatalf("Could not get buckets: %v", err)
+	}
+	if len(buckets.Bucket) != 1 || buckets.Bucket[0].BucketName != "test-bucket" {
+		t.Errorf("Expected test-bucket, got %+v", buckets.Bucket)
 	}
 	err = PrintBuckets(buckets)
 	if err != nil {
-		fmt.Println("Could not display buckets.")
+		t.Errorf("Could not display buckets: %v", err)
 	}
-	//fmt.Println("\nBuckets Response Body:\n" + string(apiResponse.Body))
-	fmt.Println("\n\n^ List Buckets Test Completed\n ")
 }
 
-// Test B2ListFilenames
+// Test ListFilenames
 func TestToReturnFilenames(t *testing.T) {
-	B2ListFilenames("b6ee61624837a6c6588b0715", "")
+	c, _ := testClient(t)
+	bucketID := testBucket(t, c)
+	if _, err := c.UploadFile(bucketID, "testfile.txt"); err != nil {
+		t.Fatal(err)
+	}
+	err := c.ListFilenames(bucketID, "")
+	if err != nil {
+		t.Fatalf("Could not list filenames. Error: %v", err)
+	}
+}
+
+// Test all pages of files are listed, and folders with a delimiter
+func TestToListFiles(t *testing.T) {
+	c, srv := testClient(t)
+	bucketID := testBucket(t, c)
+	for i := 0; i < 25; i++ {
+		srv.AddFile(bucketID, fmt.Sprintf("photos/%02d.jpg", i), []byte("photo"))
+	}
+	srv.AddFile(bucketID, "photos/2017/01.jpg", []byte("photo"))
+	srv.AddFile(bucketID, "readme.txt", []byte("readme"))
+
+	lists := srv.Calls("b2_list_file_names")
+	files := c.ListFiles("test-bucket", ListOptions{Prefix: "photos/", MaxFileCount: 10})
+	var names []string
+	for files.Next() {
+		names = append(names, files.File().FileName)
+	}
+	if err := files.Err(); err != nil {
+		t.Fatalf("Could not list files: %v", err)
+	}
+	if len(names) != 26 || names[0] != "photos/00.jpg" || names[21] != "photos/2017/01.jpg" {
+		t.Errorf("Unexpected files %v", names)
+	}
+	if calls := srv.Calls("b2_list_file_names") - lists; calls != 3 {
+		t.Errorf("Expected 3 pages, got %d", calls)
+	}
+
+	var listed []File
+	files = c.ListFiles(bucketID, ListOptions{Delimiter: "/"})
+	for files.Next() {
+		listed = append(listed, files.File())
+	}
+	if files.Err() != nil || len(listed) != 2 || listed[0].FileName != "photos/" || listed[0].Action != "folder" || listed[1].ContentLength != 6 {
+		t.Errorf("Unexpected folder listing %+v, %v", listed, files.Err())
+	}
+	if err := PrintFiles(listed); err != nil {
+		t.Error(err)
+	}
+
+	srv.FailNext("b2_list_file_names", 10, http.StatusServiceUnavailable, "service_unavailable")
+	files = c.ListFiles(bucketID, ListOptions{})
+	if files.Next() || !errors.Is(files.Err(), ErrServiceUnavailable) {
+		t.Errorf("Expected ErrServiceUnavailable, got %v", files.Err())
+	}
+}
+
+// Test all versions are listed newest first across pages, starting at a file ID
+func TestToListFileVersions(t *testing.T) {
+	c, srv := testClient(t)
+	bucketID := testBucket(t, c)
+	var ids []string
+	for i := 0; i < 5; i++ {
+		ids = append(ids, srv.AddFile(bucketID, "notes.txt", []byte(fmt.Sprint("version ", i))))
+	}
+	srv.AddFile(bucketID, "notes.txt.bak", []byte("backup"))
+	if _, err := c.StartLargeFile(bucketID, "testfile.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	var versions []File
+	list := c.ListFileVersions(bucketID, ListOptions{MaxFileCount: 2})
+	for list.Next() {
+		versions = append(versions, list.File())
+	}
+	if err := list.Err(); err != nil {
+		t.Fatalf("Could not list versions: %v", err)
+	}
+	if len(versions) != 7 || versions[0].FileID != ids[4] || versions[4].FileID != ids[0] || versions[6].Action != "start" {
+		t.Errorf("Unexpected versions %+v", versions)
+	}
+	if err := PrintFileVersions(versions); err != nil {
+		t.Error(err)
+	}
+
+	list = c.ListFileVersions(bucketID, ListOptions{StartFileName: "notes.txt", StartFileID: ids[2], MaxFileCount: 2})
+	versions = nil
+	for list.Next() && list.File().FileName == "notes.txt" {
+		versions = append(versions, list.File())
+	}
+	if list.Err() != nil || len(versions) != 3 || versions[0].FileID != ids[2] {
+		t.Errorf("Unexpected versions from %v: %+v, %v", ids[2], versions, list.Err())
+	}
+}
+
+// Test file versions are looked up by ID and by name
+func TestToGetFileInfo(t *testing.T) {
+	c, srv := testClient(t)
+	bucketID := testBucket(t, c)
+	uploaded, err := c.UploadFile(bucketID, "testfile.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := c.GetFileInfo(uploaded.FileID)
+	if err != nil || file.FileID != uploaded.FileID || file.ContentSha1 != uploaded.ContentSha1 || file.ContentMd5 != uploaded.ContentMd5 || file.FileInfo["content-blake2b"] != uploaded.FileInfo["content-blake2b"] {
+		t.Errorf("Unexpected file info %+v, %v", file, err)
+	}
+	if err := PrintFile(file); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.GetFileInfo("4_missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected not found error, got %v", err)
+	}
+
+	latest := srv.AddFile(bucketID, "testfile.txt", []byte("newer"))
+	srv.AddFile(bucketID, "testfile.txt.bak", []byte("backup"))
+	if file, err = c.GetFileInfoByName("test-bucket", "testfile.txt"); err != nil || file.FileID != latest || file.ContentLength != 5 {
+		t.Errorf("Expected latest version %v, got %+v, %v", latest, file, err)
+	}
+	if _, err := c.HideFile(bucketID, "testfile.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetFileInfoByName(bucketID, "testfile.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected hidden file not to be found, got %v", err)
+	}
+	if _, err := c.GetFileInfoByName(bucketID, "testfile"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected prefix of a name not to be found, got %v", err)
+	}
+}
+
+// Test files are copied whole, in ranges, with replaced metadata, across buckets and as large files
+func TestToCopyFile(t *testing.T) {
+	c, srv := testClient(t)
+	bucketID := testBucket(t, c)
+	other, err := c.CreateBucket("other-bucket", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploaded, err := c.UploadFile(bucketID, "testfile.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile("testfile.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := func(f File) string {
+		var buf bytes.Buffer
+		if _, err := c.DownloadFileByID(f.FileID, &buf, DownloadOptions{}); err != nil {
+			t.Fatalf("Could not download copy %v: %v", f.FileName, err)
+		}
+		return buf.String()
+	}
+
+	copied, err := c.CopyFile(uploaded.FileID, "copy.txt", CopyOptions{})
+	if err != nil || copied.FileName != "copy.txt" || copied.BucketID != bucketID || copied.FileInfo["content-blake2b"] != uploaded.FileInfo["content-blake2b"] || content(copied) != string(data) {
+		t.Errorf("Unexpected copy %+v, %v", copied, err)
+	}
+	copied, err = c.CopyFile(uploaded.FileID, "range.txt", CopyOptions{Offset: 2, Length: 5})
+	if err != nil || content(copied) != string(data[2:7]) {
+		t.Errorf("Unexpected range copy %+v, %v", copied, err)
+	}
+	copied, err = c.CopyFile(uploaded.FileID, "moved/testfile.txt", CopyOptions{
+		DestinationBucket: "other-bucket",
+		MetadataDirective: MetadataReplace,
+		ContentType:       "text/plain",
+		FileInfo:          map[string]string{"author": "gopher"},
+	})
+	if err != nil || copied.BucketID != other.BucketID || copied.ContentType != "text/plain" || len(copied.FileInfo) != 1 || copied.FileInfo["author"] != "gopher" {
+		t.Errorf("Unexpected copy with replaced metadata %+v, %v", copied, err)
+	}
+	if _, err := c.CopyFile(uploaded.FileID, "bad.txt", CopyOptions{MetadataDirective: "MOVE"}); err == nil {
+		t.Error("Expected error for invalid metadata directive")
+	}
+	if srv.Calls("b2_copy_part") != 0 {
+		t.Errorf("Expected small copies without parts, got %v", srv.Calls("b2_copy_part"))
+	}
+
+	// Large copies are assembled from parts copied in parallel
+	large := bytes.Repeat([]byte("0123456789abcdefghij"), 5)
+	largeID := srv.AddFile(bucketID, "large.bin", large)
+	srv.AbsoluteMinimumPartSize = 10
+	c.CopyPartSize = 10
+	c.UploadConcurrency = 3
+	copied, err = c.CopyFile(largeID, "large-copy.bin", CopyOptions{DestinationBucket: other.BucketID})
+	if err != nil || copied.Action != "upload" || copied.ContentLength != 100 || content(copied) != string(large) {
+		t.Errorf("Unexpected large copy %+v, %v", copied, err)
+	}
+	if calls := srv.Calls("b2_copy_part"); calls != 10 {
+		t.Errorf("Expected 10 parts, got %v", calls)
+	}
+	copied, err = c.CopyFile(largeID, "large-range.bin", CopyOptions{Offset: 5, Length: 25})
+	if err != nil || content(copied) != string(large[5:30]) {
+		t.Errorf("Unexpected large range copy %+v, %v", copied, err)
+	}
+
+	// A failed part cancels the large file
+	srv.FailNext("b2_copy_part", 1, http.StatusBadRequest, "bad_request")
+	if _, err := c.CopyFile(largeID, "failed.bin", CopyOptions{}); !errors.Is(err, ErrBadRequest) {
+		t.Errorf("Expected bad request, got %v", err)
+	}
+	versions := c.ListFileVersions(bucketID, ListOptions{Prefix: "failed.bin"})
+	for versions.Next() {
+		t.Errorf("Expected failed copy to be cancelled, found %+v", versions.File())
+	}
+}
+
+// Test share URLs download files of private buckets without the account credentials
+func TestToShareFiles(t *testing.T) {
+	c, srv := testClient(t)
+	bucketID := testBucket(t, c)
+	srv.AddFile(bucketID, "builds/app 1.zip", []byte("app"))
+	srv.AddFile(bucketID, "builds/other.zip", []byte("other"))
+	srv.AddFile(bucketID, "secret.txt", []byte("secret"))
+	get := func(url string) (int, string, http.Header) {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body), resp.Header
+	}
+
+	disposition := `attachment; filename="app.zip"`
+	shared, err := c.ShareURL("test-bucket", "builds/app 1.zip", DownloadAuthorizationOptions{ValidDuration: 24 * time.Hour, ContentDisposition: disposition})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(shared, c.Authorization().DownloadURL+"/file/test-bucket/builds/app%201.zip?Authorization=") {
+		t.Errorf("Unexpected share URL %v", shared)
+	}
+	if status, body, header := get(shared); status != http.StatusOK || body != "app" || header.Get("Content-Disposition") != disposition {
+		t.Errorf("Unexpected shared download %v %q %v", status, body, header.Get("Content-Disposition"))
+	}
+	if status, _, _ := get(strings.Replace(shared, "app%201.zip", "other.zip", 1)); status != http.StatusUnauthorized {
+		t.Errorf("Expected share URL to allow only its file, got %v", status)
+	}
+	if status, _, _ := get(strings.Split(shared, "?")[0]); status != http.StatusUnauthorized {
+		t.Errorf("Expected download without token to fail, got %v", status)
+	}
+
+	downloadAuth, err := c.GetDownloadAuthorization(bucketID, "builds/", DownloadAuthorizationOptions{ValidDuration: time.Minute})
+	if err != nil || downloadAuth.BucketID != bucketID || downloadAuth.FileNamePrefix != "builds/" || downloadAuth.AuthorizationToken == "" {
+		t.Fatalf("Unexpected download authorization %+v, %v", downloadAuth, err)
+	}
+	if status, body, _ := get(c.DownloadURL("test-bucket", "builds/other.zip", downloadAuth)); status != http.StatusOK || body != "other" {
+		t.Errorf("Expected prefix authorization to download other.zip, got %v %q", status, body)
+	}
+	if status, _, _ := get(c.DownloadURL("test-bucket", "secret.txt", downloadAuth)); status != http.StatusUnauthorized {
+		t.Errorf("Expected prefix authorization not to download secret.txt, got %v", status)
+	}
+
+	for _, valid := range []time.Duration{0, 8 * 24 * time.Hour} {
+		if _, err := c.ShareURL(bucketID, "secret.txt", DownloadAuthorizationOptions{ValidDuration: valid}); err == nil {
+			t.Errorf("Expected error for duration %v", valid)
+		}
+	}
+	srv.ExpireTokens()
+	if status, _, _ := get(shared); status != http.StatusUnauthorized {
+		t.Errorf("Expected expired share URL to fail, got %v", status)
+	}
+}
+
+// Test unfinished large files are listed, cancelled and resumed with only their missing parts
+func TestToResumeLargeFileUpload(t *testing.T) {
+	c, srv := testClient(t)
+	bucketID := testBucket(t, c)
+	srv.AbsoluteMinimumPartSize = 10
+	path := filepath.Join(t.TempDir(), "build.bin")
+	data := []byte("0123456789abcdefghijABCDEFGHIJxyz!?")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	started, err := c.StartLargeFile(bucketID, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Parts 1 and 3 of 10 bytes were uploaded before the upload was interrupted
+	for _, index := range []int{0, 2} {
+		piece := data[index*10 : index*10+10]
+		sum := sha1.Sum(piece)
+		p := B2FilePiece{PieceNum: index, SHA1: hex.EncodeToString(sum[:]), Size: 10}
+		if _, _, err := c.uploadPiece(context.Background(), started.FileID, p, func() io.Reader { return bytes.NewReader(piece) }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other, err := c.StartLargeFile(bucketID, "testfile.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	fmt.Println("\n\n^ List Filenames complete")
+	var unfinished []File
+	list := c.ListUnfinishedLargeFiles("test-bucket", ListOptions{MaxFileCount: 1})
+	for list.Next() {
+		unfinished = append(unfinished, list.File())
+	}
+	if list.Err() != nil || len(unfinished) != 2 || unfinished[0].FileID != started.FileID || unfinished[1].FileID != other.FileID {
+		t.Errorf("Unexpected unfinished large files %+v, %v", unfinished, list.Err())
+	}
+	parts, err := c.ListParts(started.FileID)
+	if err != nil || len(parts) != 2 || parts[0].PartNumber != 1 || parts[1].PartNumber != 3 || parts[1].ContentLength != 10 {
+		t.Errorf("Unexpected parts %+v, %v", parts, err)
+	}
+	if err := c.CancelLargeFile(other.FileID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ListParts(other.FileID); err == nil {
+		t.Error("Expected cancelled large file to have no parts")
+	}
+
+	finished, err := c.ResumeLargeFileUpload(bucketID, path)
+	if err != nil {
+		t.Fatalf("Could not resume upload: %v", err)
+	}
+	if finished.FileID != started.FileID || finished.Action != "upload" || finished.ContentLength != int64(len(data)) {
+		t.Errorf("Unexpected finished file %+v", finished)
+	}
+	if calls := srv.Calls("b2_upload_part"); calls != 4 {
+		t.Errorf("Expected only parts 2 and 4 uploaded again, got %v part uploads", calls)
+	}
+	var buf bytes.Buffer
+	if _, err := c.DownloadFileByID(finished.FileID, &buf, DownloadOptions{}); err != nil || buf.String() != string(data) {
+		t.Errorf("Unexpected resumed file %q, %v", buf.String(), err)
+	}
+	if _, err := c.ResumeLargeFileUpload(bucketID, path); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected no unfinished large file left, got %v", err)
+	}
 }
 
-/* Test createBucket
+// Test an upload killed part way continues from the progress saved at StatePath
+func TestToResumeSavedLargeFileUpload(t *testing.T) {
+	if raceEnabled {
+		// The vendored bolt fails the checkptr checks of the race detector
+		t.Skip("Skipping bolt database test with the race detector")
+	}
+	c, srv := testClient(t)
+	bucketID := testBucket(t, c)
+	srv.AbsoluteMinimumPartSize = 10
+	dir := t.TempDir()
+	c.StatePath = filepath.Join(dir, "gopherb2.db")
+	t.Cleanup(func() { c.Close() })
+	path := filepath.Join(dir, "build.bin")
+	data := []byte("0123456789abcdefghijABCDEFGHIJxyz!?")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	whole, partSHA1s, err := hashParts(bytes.NewReader(data), int64(len(data)), 10)
+	if err != nil || len(partSHA1s) != 4 {
+		t.Fatalf("Unexpected part hashes %v, %v", partSHA1s, err)
+	}
+	started, err := c.StartLargeFile(bucketID, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The upload was killed after B2 acknowledged part 1 and 3, but before part 3 was saved
+	for _, index := range []int{0, 2} {
+		piece := data[index*10 : index*10+10]
+		p := B2FilePiece{PieceNum: index, SHA1: partSHA1s[index], Size: 10}
+		if _, _, err := c.uploadPiece(context.Background(), started.FileID, p, func() io.Reader { return bytes.NewReader(piece) }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db, err := c.uploadStateDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	absPath, _ := filepath.Abs(path)
+	key := uploadStateKey(bucketID, absPath)
+	err = db.saveUploadState(key, uploadState{
+		FileID:        started.FileID,
+		Size:          int64(len(data)),
+		ModTimeMillis: fileInfo.ModTime().UnixNano() / 1000000,
+		LargeFileSHA1: whole,
+		PartSize:      10,
+		PartSHA1s:     partSHA1s,
+		Acked:         []bool{true, false, false, false},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	finished, err := c.LargeFileUpload(bucketID, path)
+	if err != nil {
+		t.Fatalf("Could not continue saved upload: %v", err)
+	}
+	if finished.FileID != started.FileID || finished.ContentLength != int64(len(data)) {
+		t.Errorf("Unexpected finished file %+v", finished)
+	}
+	if calls := srv.Calls("b2_upload_part"); calls != 4 {
+		t.Errorf("Expected only parts 2 and 4 uploaded again, got %v part uploads", calls)
+	}
+	if calls := srv.Calls("b2_start_large_file"); calls != 1 {
+		t.Errorf("Expected the saved large file to be used, got %v starts", calls)
+	}
+	var buf bytes.Buffer
+	if _, err := c.DownloadFileByID(finished.FileID, &buf, DownloadOptions{}); err != nil || buf.String() != string(data) {
+		t.Errorf("Unexpected uploaded file %q, %v", buf.String(), err)
+	}
+	if _, found, err := db.loadUploadState(key); found || err != nil {
+		t.Errorf("Expected saved upload removed once finished, got %v, %v", found, err)
+	}
+
+	// A second client, like a second gb2 process, uploads without saving while c holds the database
+	defer func(timeout time.Duration) { openDBTimeout = timeout }(openDBTimeout)
+	openDBTimeout = 10 * time.Millisecond
+	other, err := NewClient(Configuration{AcctID: srv.AccountID, AppID: srv.ApplicationKey, APIURL: srv.APIURL()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.StatePath = c.StatePath
+	t.Cleanup(func() { other.Close() })
+	// The interrupted upload is found without the database
+	started, err = c.StartLargeFile(bucketID, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := B2FilePiece{PieceNum: 0, SHA1: partSHA1s[0], Size: 10}
+	if _, _, err := c.uploadPiece(context.Background(), started.FileID, p, func() io.Reader { return bytes.NewReader(data[:10]) }); err != nil {
+		t.Fatal(err)
+	}
+	finished, err = other.LargeFileUpload(bucketID, path)
+	if err != nil || finished.FileID != started.FileID {
+		t.Errorf("Expected upload to continue without saving progress while the database is locked, got %+v, %v", finished, err)
+	}
+}
+
+// Test readers of known size are sent with one upload, and streams as large files once too long
+func TestToUploadReader(t *testing.T) {
+	c, srv := testClient(t)
+	bucketID := testBucket(t, c)
+	srv.AbsoluteMinimumPartSize = 10
+	download := func(fileID string) string {
+		var buf bytes.Buffer
+		if _, err := c.DownloadFileByID(fileID, &buf, DownloadOptions{}); err != nil {
+			t.Fatalf("Could not download %v: %v", fileID, err)
+		}
+		return buf.String()
+	}
+	streams := []struct {
+		name string
+		data string
+		r    io.Reader
+		size int64
+	}{
+		{"seeker.txt", "read from a seeker", strings.NewReader("read from a seeker"), 18},
+		{"dumps/known size.sql", "known size stream", ioutil.NopCloser(strings.NewReader("known size stream")), 17},
+		{"unknown.sql", "stream of unknown size", ioutil.NopCloser(strings.NewReader("stream of unknown size")), UnknownSize},
+	}
+	for _, stream := range streams {
+		uploaded, err := c.UploadReader("test-bucket", stream.name, stream.r, stream.size)
+		if err != nil {
+			t.Fatalf("Could not upload %v: %v", stream.name, err)
+		}
+		if uploaded.FileName != stream.name || uploaded.Action != "upload" {
+			t.Errorf("Unexpected uploaded file %+v", uploaded)
+		}
+		if data := download(uploaded.FileID); data != stream.data {
+			t.Errorf("Unexpected content %q of %v", data, stream.name)
+		}
+	}
+	if calls := srv.Calls("b2_upload_file"); calls != 3 {
+		t.Errorf("Expected 3 single uploads, got %v", calls)
+	}
+	if _, err := c.UploadReader("test-bucket", "short.txt", ioutil.NopCloser(strings.NewReader("short")), 10); err == nil {
+		t.Error("Expected error for stream shorter than its size")
+	}
+	if !testing.Short() {
+		// Streams of known size too large to buffer are uploaded in parts, each retried on failure
+		size := int64(largeFilePartSize + 1)
+		stream := struct{ io.Reader }{bytes.NewReader(make([]byte, size))}
+		srv.FailNext("b2_upload_part", 1, http.StatusServiceUnavailable, "service_unavailable")
+		uploaded, err := c.UploadReader(bucketID, "known size.bin", stream, size)
+		if err != nil || uploaded.ContentLength != size {
+			t.Errorf("Could not upload large stream of known size: %+v, %v", uploaded, err)
+		}
+		if srv.Calls("b2_start_large_file") != 1 || srv.Calls("b2_upload_part") != 3 {
+			t.Errorf("Expected 2 parts with one retry, got %v part uploads", srv.Calls("b2_upload_part"))
+		}
+	}
+
+	data := "0123456789abcdefghijABCDEFGHIJxyz!?"
+	parts := srv.Calls("b2_upload_part")
+	uploaded, err := c.uploadLargeStream(context.Background(), bucketID, "large.sql", ioutil.NopCloser(strings.NewReader(data)), 10)
+	if err != nil {
+		t.Fatalf("Could not upload large stream: %v", err)
+	}
+	if uploaded.ContentLength != int64(len(data)) || download(uploaded.FileID) != data {
+		t.Errorf("Unexpected large stream file %+v", uploaded)
+	}
+	if calls := srv.Calls("b2_upload_part") - parts; calls != 4 {
+		t.Errorf("Expected 4 parts, got %v", calls)
+	}
+}
+
+// Test files are hidden, and versions deleted singly, by name and below a prefix
+func TestToDeleteAndHideFiles(t *testing.T) {
+	c, srv := testClient(t)
+	bucketID := testBucket(t, c)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		ids = append(ids, srv.AddFile(bucketID, "notes.txt", []byte(fmt.Sprint("version ", i))))
+	}
+	for i := 0; i < 10; i++ {
+		srv.AddFile(bucketID, fmt.Sprintf("photos/%02d.jpg", i), []byte("first"))
+		srv.AddFile(bucketID, fmt.Sprintf("photos/%02d.jpg", i), []byte("second"))
+	}
+	versions := func(prefix string) []File {
+		var files []File
+		list := c.ListFileVersions(bucketID, ListOptions{Prefix: prefix})
+		for list.Next() {
+			files = append(files, list.File())
+		}
+		if err := list.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+
+	hidden, err := c.HideFile("test-bucket", "notes.txt")
+	if err != nil || hidden.Action != "hide" || hidden.FileName != "notes.txt" {
+		t.Fatalf("Could not hide file: %+v, %v", hidden, err)
+	}
+	if _, err := c.DownloadFileByName(bucketID, "notes.txt", ioutil.Discard, DownloadOptions{}); err == nil {
+		t.Error("Expected hidden file not to be downloadable")
+	}
+	if _, err := c.HideFile(bucketID, "missing.txt"); err == nil {
+		t.Error("Expected error hiding a missing file")
+	}
+	// Deleting the hide marker, the latest version, reveals the file again
+	summary, err := c.DeleteFiles(bucketID, DeleteOptions{FileName: "notes.txt"})
+	if err != nil || summary.Removed != 1 || summary.Failed != 0 {
+		t.Fatalf("Unexpected summary %+v, %v", summary, err)
+	}
+	var buf bytes.Buffer
+	if _, err := c.DownloadFileByName(bucketID, "notes.txt", &buf, DownloadOptions{}); err != nil || buf.String() != "version 2" {
+		t.Errorf("Expected revealed file, got %q, %v", buf.String(), err)
+	}
+
+	if err := c.DeleteFileVersion("notes.txt", ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteFileVersion("notes.txt", ids[1]); err == nil {
+		t.Error("Expected error deleting a deleted version")
+	}
+	if files := versions("notes.txt"); len(files) != 2 || files[0].FileID != ids[2] || files[1].FileID != ids[0] {
+		t.Errorf("Unexpected versions after delete %+v", files)
+	}
+
+	// Dry runs report the versions without deleting them
+	var reported []File
+	summary, err = c.DeleteFiles(bucketID, DeleteOptions{Prefix: "photos/", DryRun: true, Removed: func(f File, err error) {
+		reported = append(reported, f)
+	}})
+	if err != nil || summary.Removed != 10 || len(reported) != 10 || len(versions("photos/")) != 20 {
+		t.Errorf("Unexpected dry run %+v, %v", summary, err)
+	}
+	summary, err = c.DeleteFiles(bucketID, DeleteOptions{Prefix: "photos/", Concurrency: 3})
+	if err != nil || summary.Removed != 10 || summary.Bytes != 60 {
+		t.Errorf("Unexpected summary %+v, %v", summary, err)
+	}
+	if files := versions("photos/"); len(files) != 10 || files[0].ContentLength != 5 {
+		t.Errorf("Expected only the first versions to remain, got %+v", files)
+	}
+	// Prefix deletes leave hidden files hidden instead of deleting their hide marker
+	if _, err := c.HideFile(bucketID, "photos/00.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	summary, err = c.DeleteFiles(bucketID, DeleteOptions{Prefix: "photos/"})
+	if err != nil || summary.Removed != 9 {
+		t.Errorf("Expected the hidden file to be skipped, got %+v, %v", summary, err)
+	}
+	if files := versions("photos/"); len(files) != 2 || files[0].Action != "hide" {
+		t.Errorf("Expected the hidden file to remain hidden, got %+v", files)
+	}
+	summary, err = c.DeleteFiles(bucketID, DeleteOptions{AllVersions: true, Concurrency: 8})
+	if err != nil || summary.Removed != 4 || len(versions("")) != 0 {
+		t.Errorf("Unexpected summary %+v, %v", summary, err)
+	}
+}
+
+// Test files are downloaded by name and ID, verified, and resumed after an offset
+func TestToDownloadFile(t *testing.T) {
+	c, _ := testClient(t)
+	bucketID := testBucket(t, c)
+	if _, err := c.UploadFile(bucketID, "testfile.txt"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile("testfile.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	file, err := c.DownloadFileByName("test-bucket", "testfile.txt", &buf, DownloadOptions{})
+	if err != nil {
+		t.Fatalf("Could not download by name: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) || file.ContentLength != int64(len(data)) || file.FileInfo["content-blake2b"] == "" {
+		t.Errorf("Unexpected download %+v of %d bytes", file, buf.Len())
+	}
+	buf.Reset()
+	if _, err = c.DownloadFileByID(file.FileID, &buf, DownloadOptions{}); err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("Could not download by ID: %v", err)
+	}
+
+	buf.Reset()
+	resumed, err := c.DownloadFileByName(bucketID, "testfile.txt", &buf, DownloadOptions{Offset: 100, Downloaded: bytes.NewReader(data[:100])})
+	if err != nil || !bytes.Equal(buf.Bytes(), data[100:]) || resumed.ContentLength != int64(len(data)) {
+		t.Errorf("Could not resume download: %+v, %v", resumed, err)
+	}
+	_, err = c.DownloadFileByName(bucketID, "testfile.txt", ioutil.Discard, DownloadOptions{Offset: 100, Downloaded: bytes.NewReader(make([]byte, 100))})
+	if !errors.Is(err, ErrSHA1Mismatch) {
+		t.Errorf("Expected ErrSHA1Mismatch resuming after other data, got %v", err)
+	}
+	if _, err = c.DownloadFileByName(bucketID, "missing.txt", ioutil.Discard, DownloadOptions{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+// Test a download failing midway continues with a Range request
+func TestToRetryInterruptedDownload(t *testing.T) {
+	c, srv := testClient(t)
+	bucketID := testBucket(t, c)
+	data := []byte(strings.Repeat("0123456789", 100))
+	fileID := srv.AddFile(bucketID, "digits.txt", data)
+	var ranges []string
+	c.HTTPClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil || !strings.Contains(req.URL.Path, "b2_download_file_by_id") {
+			return resp, err
+		}
+		ranges = append(ranges, req.Header.Get("Range"))
+		if len(ranges) == 1 {
+			resp.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(io.LimitReader(resp.Body, 300), iotest.ErrReader(syscall.ECONNRESET)), resp.Body}
+		}
+		return resp, err
+	})}
+
+	var buf bytes.Buffer
+	if _, err := c.DownloadFileByID(fileID, &buf, DownloadOptions{}); err != nil {
+		t.Fatalf("Could not download: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("Expected %d bytes, got %d", len(data), buf.Len())
+	}
+	if len(ranges) != 2 || ranges[0] != "" || ranges[1] != "bytes=300-" {
+		t.Errorf("Unexpected ranges %q", ranges)
+	}
+}
+
+// Test ranges are downloaded concurrently into a file, retrying failed ranges
+func TestToDownloadRangesInParallel(t *testing.T) {
+	c, srv := testClient(t)
+	bucketID := testBucket(t, c)
+	data := []byte(strings.Repeat("0123456789abcdef", 100))
+	fileID := srv.AddFile(bucketID, "hex.txt", data)
+	c.DownloadConcurrency = 3
+	c.DownloadRangeSize = 100
+	srv.FailNext("b2_download_file_by_id", 2, http.StatusServiceUnavailable, "service_unavailable")
+
+	path := filepath.Join(t.TempDir(), "hex.txt")
+	target, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	file, err := c.ParallelDownloadFileByName("test-bucket", "hex.txt", target)
+	if err != nil {
+		t.Fatalf("Could not download in parallel: %v", err)
+	}
+	downloaded, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, data) || file.FileID != fileID || file.ContentLength != int64(len(data)) {
+		t.Errorf("Unexpected download %+v of %d bytes", file, len(downloaded))
+	}
+	// The first range by name, 15 ranges and 2 failures by ID
+	if calls := srv.Calls("b2_download_file_by_id"); calls != 17 {
+		t.Errorf("Expected 17 ranges requested by ID, got %d", calls)
+	}
+
+	c.DownloadRangeSize = 1 << 20
+	if err = target.Truncate(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.ParallelDownloadFileByID(fileID, target); err != nil {
+		t.Errorf("Could not download file smaller than a range: %v", err)
+	}
+	if srv.Calls("b2_download_file_by_id") != 18 {
+		t.Error("Expected file smaller than a range to be downloaded with one request")
+	}
+}
+
+// Test large files are verified with large_file_sha1, and all files with content-blake2b
+func TestToVerifyDownload(t *testing.T) {
+	data := []byte("large file data")
+	sum := sha1.Sum(data)
+	file := DownloadedFile{ContentSha1: "none", FileInfo: map[string]string{"large_file_sha1": hex.EncodeToString(sum[:])}}
+	verify := func(data []byte) error {
+		sha1Hash, blake2bHash := sha1.New(), blake2b.New512()
+		sha1Hash.Write(data)
+		blake2bHash.Write(data)
+		return verifyDownload(file, sha1Hash, blake2bHash)
+	}
+	if err := verify(data); err != nil {
+		t.Errorf("Expected large file to verify, got %v", err)
+	}
+	if err := verify([]byte("other data")); !errors.Is(err, ErrSHA1Mismatch) {
+		t.Errorf("Expected ErrSHA1Mismatch, got %v", err)
+	}
+	file.FileInfo["content-blake2b"] = "00"
+	if err := verify(data); !errors.Is(err, ErrBlake2bMismatch) {
+		t.Errorf("Expected ErrBlake2bMismatch, got %v", err)
+	}
+	// Hashes truncated to 32 bytes by earlier uploads still verify
+	full := blake2b.Sum512(data)
+	file.FileInfo["content-blake2b"] = hex.EncodeToString(full[:32])
+	if err := verify(data); err != nil {
+		t.Errorf("Expected truncated Blake2b to verify, got %v", err)
+	}
+	if err := verify([]byte("other data")); err == nil {
+		t.Error("Expected other data not to verify against truncated Blake2b")
+	}
+}
+
+// Test files uploaded with NewB2File download verified
+func TestToDownloadNewB2File(t *testing.T) {
+	c, _ := testClient(t)
+	bucketID := testBucket(t, c)
+	b2F, err := NewB2File("testfile.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b2F.Upload(c, bucketID); err != nil {
+		t.Fatalf("Could not upload file: %v", err)
+	}
+	var buf bytes.Buffer
+	file, err := c.DownloadFileByName(bucketID, "testfile.txt", &buf, DownloadOptions{})
+	if err != nil {
+		t.Fatalf("Expected NewB2File upload to download verified, got %v", err)
+	}
+	if file.FileInfo["content-blake2b"] != b2F.Blake2b {
+		t.Errorf("Expected content-blake2b %v, got %v", b2F.Blake2b, file.FileInfo["content-blake2b"])
+	}
+}
+
+// Test createBucket
 func TestToCreateBucket(t *testing.T) {
-	B2CreateBucket("testbucket", false)
-	fmt.Println("\n^ Create Bucket Test Completed\n")
+	c, _ := testClient(t)
+	bucket, err := c.CreateBucket("testbucket", false)
+	if err != nil {
+		t.Fatalf("Could not create bucket: %v", err)
+	}
+	if bucket.BucketName != "testbucket" || bucket.BucketType != "allPrivate" {
+		t.Errorf("Unexpected bucket %+v", bucket)
+	}
+	_, err = c.CreateBucket("testbucket", false)
+	if !errors.Is(err, ErrDuplicateBucketName) {
+		t.Errorf("Expected ErrDuplicateBucketName, got %v", err)
+	}
+}
+
+// Test invalid bucket names are rejected before calling the API
+func TestToValidateBucketNames(t *testing.T) {
+	c, srv := testClient(t)
+	for _, name := range []string{"short", "b2-reserved", "under_score", "dot.bucket", "toolong-toolong-toolong-toolong-toolong-toolong-toolong"} {
+		if _, err := c.CreateBucket(name, false); err == nil {
+			t.Errorf("Expected invalid bucket name %q to be rejected", name)
+		}
+	}
+	if srv.Calls("b2_create_bucket") != 0 {
+		t.Error("Expected invalid bucket names not to be sent")
+	}
+	if err := ValidateBucketName("Valid-Bucket-01"); err != nil {
+		t.Error(err)
+	}
+}
+
+// Test bucket names are resolved to IDs once and IDs are used unchanged
+func TestToResolveBucketNames(t *testing.T) {
+	c, srv := testClient(t)
+	bucketID := testBucket(t, c)
+	// A new client has not cached the created bucket
+	c, err := NewClient(c.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lists := srv.Calls("b2_list_buckets")
+	if _, err = c.UploadFile("test-bucket", "testfile.txt"); err != nil {
+		t.Fatalf("Could not upload to bucket name: %v", err)
+	}
+	bucket, err := c.GetBucket("test-bucket")
+	if err != nil || bucket.BucketID != bucketID {
+		t.Fatalf("Could not get bucket by name: %+v, %v", bucket, err)
+	}
+	if names := testFileNames(t, c, bucketID); len(names) != 1 {
+		t.Errorf("Expected uploaded file, got %v", names)
+	}
+	// One lookup of the name, one of the bucket itself
+	if calls := srv.Calls("b2_list_buckets") - lists; calls != 2 {
+		t.Errorf("Expected bucket name to be looked up once, got %d list calls", calls)
+	}
+	lists = srv.Calls("b2_list_buckets")
+	if id, err := c.ResolveBucket(bucketID); err != nil || id != bucketID {
+		t.Errorf("Expected bucket ID to resolve to itself, got %v, %v", id, err)
+	}
+	if calls := srv.Calls("b2_list_buckets") - lists; calls != 0 {
+		t.Errorf("Expected bucket ID not to be looked up, got %d list calls", calls)
+	}
+	if _, err = c.GetBucket("missing-bucket"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound getting missing bucket, got %v", err)
+	}
+	if _, err = c.ResolveBucket("no_bucket!"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for invalid bucket name, got %v", err)
+	}
+	// A missing name is not cached, so a bucket created later with that name resolves
+	created, err := c.CreateBucket("missing-bucket", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := c.ResolveBucket("missing-bucket"); err != nil || id != created.BucketID {
+		t.Errorf("Expected bucket created after a failed lookup to resolve, got %v, %v", id, err)
+	}
+	// Names that look like bucket IDs are still resolved as names
+	hexNamed, err := c.CreateBucket("abcdefabcdefabcdefabcdef", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewClient(Configuration{AcctID: srv.AccountID, AppID: srv.ApplicationKey, APIURL: srv.APIURL()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := other.ResolveBucket("abcdefabcdefabcdefabcdef"); err != nil || id != hexNamed.BucketID {
+		t.Errorf("Expected hex bucket name to resolve to %v, got %v, %v", hexNamed.BucketID, id, err)
+	}
+	if id, err := other.ResolveBucket(bucketID); err != nil || id != bucketID {
+		t.Errorf("Expected bucket ID unknown to the client to resolve to itself, got %v, %v", id, err)
+	}
+
+	if _, err = c.EmptyBucket("test-bucket"); err != nil {
+		t.Fatalf("Could not empty bucket by name: %v", err)
+	}
+	if _, err = c.DeleteBucket("test-bucket"); err != nil {
+		t.Fatalf("Could not delete bucket by name: %v", err)
+	}
+	if _, err = c.CreateBucket("test-bucket", false); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := c.ResolveBucket("test-bucket"); err != nil || id == bucketID {
+		t.Errorf("Expected recreated bucket to get a new ID, got %v, %v", id, err)
+	}
+}
+
+// Test buckets are updated, and deleted once emptied of all file versions
+func TestToUpdateAndDeleteBucket(t *testing.T) {
+	c, _ := testClient(t)
+	bucketID := testBucket(t, c)
+	bucket, err := c.UpdateBucket(bucketID, BucketSettings{BucketType: "allPublic", BucketInfo: map[string]string{"owner": "ops"}, IfRevisionMatches: 1})
+	if err != nil {
+		t.Fatalf("Could not update bucket: %v", err)
+	}
+	if bucket.BucketType != "allPublic" || bucket.BucketInfo["owner"] != "ops" || bucket.Revision != 2 {
+		t.Errorf("Unexpected updated bucket %+v", bucket)
+	}
+	if _, err = c.UpdateBucket(bucketID, BucketSettings{BucketType: "allPrivate", IfRevisionMatches: 1}); !errors.Is(err, ErrRevisionConflict) {
+		t.Errorf("Expected ErrRevisionConflict, got %v", err)
+	}
+
+	// Two versions of a file and an unfinished large file
+	for i := 0; i < 2; i++ {
+		if _, err := c.UploadFile(bucketID, "testfile.txt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.StartLargeFile(bucketID, "testfile.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.DeleteBucket(bucketID); err == nil {
+		t.Error("Expected error deleting non-empty bucket")
+	}
+	removed, err := c.EmptyBucket(bucketID)
+	if err != nil || removed != 3 {
+		t.Errorf("Expected 3 versions removed, got %v, %v", removed, err)
+	}
+	if bucket, err = c.DeleteBucket(bucketID); err != nil || bucket.BucketID != bucketID {
+		t.Fatalf("Could not delete bucket: %+v, %v", bucket, err)
+	}
+	if buckets, _ := c.GetBuckets(); len(buckets.Bucket) != 0 {
+		t.Errorf("Expected no buckets, got %+v", buckets.Bucket)
+	}
+}
+
+// Test lifecycle rules are validated locally and set at create and update time
+func TestToManageLifecycleRules(t *testing.T) {
+	c, srv := testClient(t)
+	rules := []LifecycleRule{{FileNamePrefix: "logs/", DaysFromUploadingToHiding: 7, DaysFromHidingToDeleting: 1}}
+	bucket, err := c.CreateBucketWithSettings("lifecycle-bucket", BucketSettings{LifecycleRules: rules})
+	if err != nil {
+		t.Fatalf("Could not create bucket: %v", err)
+	}
+	if len(bucket.LifecycleRules) != 1 || bucket.LifecycleRules[0] != rules[0] || bucket.BucketType != "allPrivate" {
+		t.Errorf("Unexpected bucket %+v", bucket)
+	}
+
+	bucket, err = c.AddLifecycleRule(bucket.BucketID, LifecycleRule{FileNamePrefix: "tmp/", DaysFromHidingToDeleting: 3})
+	if err != nil || len(bucket.LifecycleRules) != 2 {
+		t.Fatalf("Could not add rule: %+v, %v", bucket.LifecycleRules, err)
+	}
+	updates := srv.Calls("b2_update_bucket")
+	for _, rule := range []LifecycleRule{
+		{FileNamePrefix: "logs/old/", DaysFromUploadingToHiding: 1},
+		{FileNamePrefix: "", DaysFromUploadingToHiding: 1},
+		{FileNamePrefix: "new/"},
+	} {
+		if _, err = c.AddLifecycleRule(bucket.BucketID, rule); err == nil {
+			t.Errorf("Expected invalid rule %+v to be rejected", rule)
+		}
+	}
+	if srv.Calls("b2_update_bucket") != updates {
+		t.Error("Expected invalid rules not to be sent")
+	}
+
+	bucket, err = c.RemoveLifecycleRule(bucket.BucketID, "logs/")
+	if err != nil || len(bucket.LifecycleRules) != 1 || bucket.LifecycleRules[0].FileNamePrefix != "tmp/" {
+		t.Errorf("Could not remove rule: %+v, %v", bucket.LifecycleRules, err)
+	}
+	if _, err = c.RemoveLifecycleRule(bucket.BucketID, "logs/"); err == nil {
+		t.Error("Expected error removing missing rule")
+	}
+	if err = PrintLifecycleRules(bucket.LifecycleRules); err != nil {
+		t.Error(err)
+	}
+}
+
+// Test bucket info entries are set and removed keeping the other entries
+func TestToSetBucketInfo(t *testing.T) {
+	c, srv := testClient(t)
+	bucket, err := c.CreateBucketWithSettings("info-bucket", BucketSettings{BucketInfo: map[string]string{"owner": "ops"}})
+	if err != nil {
+		t.Fatalf("Could not create bucket: %v", err)
+	}
+	bucket, err = c.SetBucketInfo(bucket.BucketID, map[string]string{"Cache-Control": "max-age=3600"}, nil)
+	if err != nil || len(bucket.BucketInfo) != 2 || bucket.BucketInfo["Cache-Control"] != "max-age=3600" || bucket.Revision != 2 {
+		t.Fatalf("Could not set bucket info: %+v, %v", bucket, err)
+	}
+	bucket, err = c.SetBucketInfo(bucket.BucketID, nil, []string{"owner"})
+	if err != nil || len(bucket.BucketInfo) != 1 || bucket.BucketInfo["owner"] != "" {
+		t.Fatalf("Could not remove bucket info: %+v, %v", bucket, err)
+	}
+	if _, err = c.SetBucketInfo(bucket.BucketID, nil, []string{"owner"}); err == nil {
+		t.Error("Expected error removing missing bucket info")
+	}
+
+	updates := srv.Calls("b2_update_bucket")
+	tooMany := map[string]string{}
+	for i := 0; i <= MaxBucketInfo; i++ {
+		tooMany[fmt.Sprint("key", i)] = "value"
+	}
+	if _, err = c.SetBucketInfo(bucket.BucketID, tooMany, nil); err == nil {
+		t.Error("Expected too many bucket info entries to be rejected")
+	}
+	if srv.Calls("b2_update_bucket") != updates {
+		t.Error("Expected invalid bucket info not to be sent")
+	}
+
+	// Another tool changes the bucket between reading and updating it
+	if _, err = c.UpdateBucket(bucket.BucketID, BucketSettings{BucketType: "allPublic"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.UpdateBucket(bucket.BucketID, BucketSettings{BucketInfo: map[string]string{}, IfRevisionMatches: bucket.Revision}); !errors.Is(err, ErrRevisionConflict) {
+		t.Errorf("Expected ErrRevisionConflict, got %v", err)
+	}
+	if err = PrintBucketInfo(bucket.BucketInfo); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestToManageCORSRules(t *testing.T) {
+	c, srv := testClient(t)
+	dir := t.TempDir()
+	tomlPath := filepath.Join(dir, "cors.toml")
+	err := ioutil.WriteFile(tomlPath, []byte(`
+[[corsRules]]
+corsRuleName = "downloadFromAnyOrigin"
+allowedOrigins = ["*"]
+allowedOperations = ["b2_download_file_by_name", "s3_get"]
+allowedHeaders = ["range"]
+maxAgeSeconds = 3600
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := LoadCORSRules(tomlPath)
+	if err != nil {
+		t.Fatalf("Could not load TOML rules: %v", err)
+	}
+	if len(rules) != 1 || rules[0].CorsRuleName != "downloadFromAnyOrigin" || len(rules[0].AllowedOperations) != 2 || rules[0].MaxAgeSeconds != 3600 {
+		t.Fatalf("Unexpected TOML rules %+v", rules)
+	}
+
+	bucket, err := c.CreateBucketWithSettings("cors-bucket", BucketSettings{CORSRules: rules})
+	if err != nil {
+		t.Fatalf("Could not create bucket: %v", err)
+	}
+	if len(bucket.CORSRules) != 1 || bucket.CORSRules[0].AllowedHeaders[0] != "range" {
+		t.Errorf("Unexpected CORS rules %+v", bucket.CORSRules)
+	}
+
+	jsonPath := filepath.Join(dir, "cors.json")
+	err = ioutil.WriteFile(jsonPath, []byte(`[{"corsRuleName": "uploadFromExample", "allowedOrigins": ["https://*.example.com"],
+		"allowedOperations": ["b2_upload_file"], "exposeHeaders": ["x-bz-content-sha1"], "maxAgeSeconds": 60}]`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules, err = LoadCORSRules(jsonPath); err != nil {
+		t.Fatalf("Could not load JSON rules: %v", err)
+	}
+	bucket, err = c.UpdateBucket(bucket.BucketID, BucketSettings{CORSRules: rules})
+	if err != nil || len(bucket.CORSRules) != 1 || bucket.CORSRules[0].CorsRuleName != "uploadFromExample" {
+		t.Fatalf("Could not set rules: %+v, %v", bucket.CORSRules, err)
+	}
+
+	updates := srv.Calls("b2_update_bucket")
+	valid := rules[0]
+	for _, change := range []func(r *CORSRule){
+		func(r *CORSRule) { r.CorsRuleName = "short" },
+		func(r *CORSRule) { r.CorsRuleName = "b2-reserved" },
+		func(r *CORSRule) { r.CorsRuleName = "not_allowed" },
+		func(r *CORSRule) { r.AllowedOrigins = nil },
+		func(r *CORSRule) { r.AllowedOrigins = []string{"https://*.*.example.com"} },
+		func(r *CORSRule) { r.AllowedOperations = []string{"b2_list_file_names"} },
+		func(r *CORSRule) { r.ExposeHeaders = []string{"*"} },
+		func(r *CORSRule) { r.MaxAgeSeconds = MaxCORSMaxAgeSeconds + 1 },
+	} {
+		rule := valid
+		change(&rule)
+		if _, err = c.UpdateBucket(bucket.BucketID, BucketSettings{CORSRules: []CORSRule{rule}}); err == nil {
+			t.Errorf("Expected invalid rule %+v to be rejected", rule)
+		}
+	}
+	if _, err = c.UpdateBucket(bucket.BucketID, BucketSettings{CORSRules: []CORSRule{valid, valid}}); err == nil {
+		t.Error("Expected duplicate rule names to be rejected")
+	}
+	if srv.Calls("b2_update_bucket") != updates {
+		t.Error("Expected invalid rules not to be sent")
+	}
+
+	bucket, err = c.UpdateBucket(bucket.BucketID, BucketSettings{CORSRules: []CORSRule{}})
+	if err != nil || len(bucket.CORSRules) != 0 {
+		t.Errorf("Could not remove rules: %+v, %v", bucket.CORSRules, err)
+	}
 }
-*/
 
 // Test getUploadURL
 func TestToReturnUploadURL(t *testing.T) {
-	uploadResponse := B2GetUploadURL("b6ee61624837a6c6588b0715")
-	fmt.Printf("\nUpload URL Received: %v", uploadResponse.URL)
-	fmt.Println("\n^ Get Upload URL Test Completed\n ")
+	c, _ := testClient(t)
+	bucketID := testBucket(t, c)
+	uploadResponse, err := c.GetUploadURL(bucketID)
+	if err != nil {
+		t.Fatalf("Could not get upload URL. Error: %v", err)
+	}
+	if uploadResponse.URL == "" || uploadResponse.AuthorizationToken == "" {
+		t.Errorf("Expected upload URL and token, got %+v", uploadResponse)
+	}
 }
 
 // Test uploadFile
 func TestToUploadFile(t *testing.T) {
-	err := UploadFile("b6ee61624837a6c6588b0715",
-		"/Users/dsjr2006/Dev/golang/src/github.com/dsjr2006/gopherb2/testfile.txt")
+	c, _ := testClient(t)
+	bucketID := testBucket(t, c)
+	uploaded, err := c.UploadFile(bucketID, "testfile.txt")
 	if err != nil {
-		fmt.Println("\n^ Upload File Test *Failed*")
-		return
+		t.Fatalf("Upload File Test Failed: %v", err)
+	}
+	if uploaded.Action != "upload" || uploaded.FileName != "testfile.txt" || uploaded.ContentMd5 == "" || uploaded.FileInfo["content-blake2b"] == "" || uploaded.FileInfo["src_last_modified_millis"] == "" {
+		t.Errorf("Unexpected uploaded file %+v", uploaded)
+	}
+	if names := testFileNames(t, c, bucketID); len(names) != 1 || names[0] != "testfile.txt" {
+		t.Errorf("Expected testfile.txt in bucket, got %v", names)
+	}
+	_, err = os.Stat("testfile.txt")
+	if err != nil {
+		t.Fatal(err)
 	}
-	fmt.Println("\n^ Upload File Test Completed\n ")
 }
 
-/*
-// Test B2StartLargeFile
-func TestToStartLargeFile(t *testing.T) {
-	apiResponse, fileResponse := B2StartLargeFile("b6ee61624837a6c6588b0715", "/Users/dsjr2006/Dev/golang/src/github.com/dsjr2006/gopherb2/rbsp_launch_1080p.mp4")
-	fmt.Println(string(apiResponse.Body))
-	fmt.Println("\nFile Name: " + fileResponse.FileName + "\nFile ID: " + fileResponse.FileID)
-	fmt.Println("\n^ Start Large File Test Completed\n")
+// Test failed uploads are retried with a new upload URL
+func TestToRetryUploadWithNewURL(t *testing.T) {
+	c, srv := testClient(t)
+	bucketID := testBucket(t, c)
+	srv.FailNext("b2_upload_file", 2, http.StatusServiceUnavailable, "service_unavailable")
+	_, retries, err := c.uploadStdFile(context.Background(), bucketID, "testfile.txt")
+	if err != nil {
+		t.Fatalf("Expected upload to succeed after retries, got %v", err)
+	}
+	if retries != 2 {
+		t.Errorf("Expected 2 retries, got %v", retries)
+	}
+	if calls := srv.Calls("b2_get_upload_url"); calls != 3 {
+		t.Errorf("Expected an upload URL for every attempt, got %v", calls)
+	}
 }
 
-// Test B2GetUploadPartURL
-
-func TestToGetUploadPartURL(t *testing.T) {
-	apiResponse := B2GetUploadPartURL("4_zb6ee61624837a6c6588b0715_f202847f5820b0610_d20161214_m184330_c001_v0001036_t0053")
-	fmt.Println("\nUpload Part URL: " + apiResponse.UploadURL)
-	fmt.Println("\n^ Get Upload Part URL Test Completed (*Test FileID hardcoded)\n")
+// Test API error bodies decode to APIError and match sentinel errors
+func TestAPIErrorIs(t *testing.T) {
+	var err error = newAPIError(401, []byte(`{"status": 401, "code": "expired_auth_token", "message": "Authorization token has expired"}`))
+	if !errors.Is(err, ErrExpiredAuthToken) {
+		t.Errorf("Expected %v to match ErrExpiredAuthToken", err)
+	}
+	if errors.Is(err, ErrBadAuthToken) {
+		t.Errorf("Expected %v not to match ErrBadAuthToken", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != 401 {
+		t.Errorf("Expected APIError with status 401, got %v", err)
+	}
+	// Non JSON bodies fall back to the HTTP status
+	err = newAPIError(503, []byte("upstream unavailable"))
+	if !errors.Is(err, ErrServiceUnavailable) {
+		t.Errorf("Expected %v to match ErrServiceUnavailable", err)
+	}
 }
 
+// Test cancelling the context stops waiting for the next retry
+func TestAPICallRetryCancel(t *testing.T) {
+	c, srv := testClient(t)
+	c.Retry = RetryPolicy{MaxRetries: 5, InitialBackoff: time.Minute, MaxBackoff: time.Minute}
+	srv.FailNext("b2_list_buckets", 1, http.StatusServiceUnavailable, "service_unavailable")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.GetBucketsContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Expected cancellation to interrupt backoff, took %v", time.Since(start))
+	}
+	if calls := srv.Calls("b2_list_buckets"); calls != 1 {
+		t.Errorf("Expected 1 call, got %v", calls)
+	}
+}
 
-// Test LargeFileUpload
-func TestToUploadFile(t *testing.T) {
-	UploadFile("b6ee61624837a6c6588b0715", "/Users/dsjr2006/Dev/golang/src/github.com/dsjr2006/gopherb2/rbsp_launch_1080p.mp4")
+// Test API calls are retried after 503 responses until they succeed
+func TestAPICallRetry(t *testing.T) {
+	c, srv := testClient(t)
+	testBucket(t, c)
+	srv.FailNext("b2_list_buckets", 2, http.StatusServiceUnavailable, "service_unavailable")
+	buckets, err := c.GetBuckets()
+	if err != nil {
+		t.Fatalf("Expected retries to succeed, got %v", err)
+	}
+	if calls := srv.Calls("b2_list_buckets"); calls != 3 || len(buckets.Bucket) != 1 {
+		t.Errorf("Expected 3 calls and 1 bucket, got %d calls and %d buckets", calls, len(buckets.Bucket))
+	}
 
-	fmt.Println("\n^ Upload Large File Test Completed\n")
+	// With retries disabled the first failure is returned
+	c.Retry.MaxRetries = 0
+	srv.FailNext("b2_list_buckets", 1, http.StatusServiceUnavailable, "service_unavailable")
+	_, err = c.GetBuckets()
+	if !errors.Is(err, ErrServiceUnavailable) {
+		t.Errorf("Expected ErrServiceUnavailable without retries, got %v", err)
+	}
 }
-*/
@@ -0,0 +1,143 @@
+package gopherb2
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/uber-go/zap"
+)
+
+// Key is an application key of the account. ApplicationKey, the secret, is only returned by CreateKey.
+type Key struct {
+	AccountID           string   `json:"accountId"`
+	ApplicationKeyID    string   `json:"applicationKeyId"`
+	ApplicationKey      string   `json:"applicationKey,omitempty"`
+	KeyName             string   `json:"keyName"`
+	Capabilities        []string `json:"capabilities"`
+	BucketID            string   `json:"bucketId"`
+	NamePrefix          string   `json:"namePrefix"`
+	ExpirationTimestamp int64    `json:"expirationTimestamp"`
+}
+
+// CreateKey creates a new application key named keyName with the given capabilities, such as
+// CapListFiles. If validDuration is not 0 the key expires after it, if bucketID is not empty the
+// key is restricted to that bucket and to files with names starting with namePrefix.
+func (c *Client) CreateKey(keyName string, capabilities []string, validDuration time.Duration, bucketID string, namePrefix string) (Key, error) {
+	return c.CreateKeyContext(context.Background(), keyName, capabilities, validDuration, bucketID, namePrefix)
+}
+
+// CreateKeyContext is like CreateKey with a context limiting the request
+func (c *Client) CreateKeyContext(ctx context.Context, keyName string, capabilities []string, validDuration time.Duration, bucketID string, namePrefix string) (Key, error) {
+	var key Key
+	reqBody := map[string]interface{}{
+		"accountId":    c.Authorization().AccountID,
+		"keyName":      keyName,
+		"capabilities": capabilities,
+	}
+	if validDuration > 0 {
+		reqBody["validDurationInSeconds"] = int64(validDuration / time.Second)
+	}
+	if bucketID != "" {
+		bucketID, err := c.ResolveBucketContext(ctx, bucketID)
+		if err != nil {
+			return key, err
+		}
+		reqBody["bucketId"] = bucketID
+		if namePrefix != "" {
+			reqBody["namePrefix"] = namePrefix
+		}
+	}
+	err := c.apiCall(ctx, "b2_create_key", reqBody, &key)
+	if err != nil {
+		c.Logger.Warn("Could not create application key",
+			zap.String("Key Name", keyName),
+			zap.Error(err),
+		)
+		return key, err
+	}
+	c.Logger.Info("Application Key Created",
+		zap.String("Key Name", key.KeyName),
+		zap.String("Application Key ID", key.ApplicationKeyID),
+	)
+	return key, nil
+}
+
+// ListKeys returns all application keys of the account
+func (c *Client) ListKeys() ([]Key, error) {
+	return c.ListKeysContext(context.Background())
+}
+
+// ListKeysContext is like ListKeys with a context limiting the requests
+func (c *Client) ListKeysContext(ctx context.Context) ([]Key, error) {
+	var keys []Key
+	startKeyID := ""
+	for {
+		var page struct {
+			Keys                 []Key   `json:"keys"`
+			NextApplicationKeyID *string `json:"nextApplicationKeyId"`
+		}
+		reqBody := map[string]interface{}{
+			"accountId":   c.Authorization().AccountID,
+			"maxKeyCount": 1000,
+		}
+		if startKeyID != "" {
+			reqBody["startApplicationKeyId"] = startKeyID
+		}
+		err := c.apiCall(ctx, "b2_list_keys", reqBody, &page)
+		if err != nil {
+			c.Logger.Warn("List Keys Failed.",
+				zap.Error(err),
+			)
+			return keys, err
+		}
+		keys = append(keys, page.Keys...)
+		if page.NextApplicationKeyID == nil || *page.NextApplicationKeyID == "" {
+			return keys, nil
+		}
+		startKeyID = *page.NextApplicationKeyID
+	}
+}
+
+// DeleteKey deletes the application key applicationKeyID and returns the deleted key
+func (c *Client) DeleteKey(applicationKeyID string) (Key, error) {
+	return c.DeleteKeyContext(context.Background(), applicationKeyID)
+}
+
+// DeleteKeyContext is like DeleteKey with a context limiting the request
+func (c *Client) DeleteKeyContext(ctx context.Context, applicationKeyID string) (Key, error) {
+	var key Key
+	err := c.apiCall(ctx, "b2_delete_key", map[string]string{"applicationKeyId": applicationKeyID}, &key)
+	if err != nil {
+		c.Logger.Warn("Could not delete application key",
+			zap.String("Application Key ID", applicationKeyID),
+			zap.Error(err),
+		)
+		return key, err
+	}
+	c.Logger.Info("Application Key Deleted",
+		zap.String("Key Name", key.KeyName),
+		zap.String("Application Key ID", key.ApplicationKeyID),
+	)
+	return key, nil
+}
+
+// PrintKeys Displays list of application keys in console
+func PrintKeys(keys []Key) error {
+	writer := new(tabwriter.Writer)
+	fmt.Println("B2 Application Keys")
+	writer.Init(os.Stdout, 0, 5, 1, ' ', 0)
+	fmt.Fprintln(writer, "-ID-\t -NAME-\t -CAPABILITIES-\t -BUCKET-\t -PREFIX-\t -EXPIRES-")
+	for _, key := range keys {
+		expires := "never"
+		if key.ExpirationTimestamp > 0 {
+			expires = time.Unix(0, key.ExpirationTimestamp*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintln(writer, key.ApplicationKeyID+"\t", key.KeyName+"\t", strings.Join(key.Capabilities, ",")+"\t", key.BucketID+"\t", key.NamePrefix+"\t", expires+"\t")
+	}
+	fmt.Fprintln(writer)
+	return writer.Flush()
+}
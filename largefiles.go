@@ -0,0 +1,520 @@
+package gopherb2
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/uber-go/zap"
+	pb "gopkg.in/cheggaaa/pb.v1"
+)
+
+// largeFilePartSize is the size of the parts of large file uploads, other than the last
+const largeFilePartSize = 100 * (1 << 20)
+
+// DefaultUploadMemoryBudget is the UploadMemoryBudget of clients returned from NewClient
+const DefaultUploadMemoryBudget = 64 * (1 << 20)
+
+// minUploadReadBuffer is the smallest buffer a part in flight reads the source file through
+const minUploadReadBuffer = 64 * (1 << 10)
+
+// LargeFilePart is a part uploaded for an unfinished large file
+type LargeFilePart struct {
+	FileID          string `json:"fileId"`
+	PartNumber      int    `json:"partNumber"`
+	ContentLength   int64  `json:"contentLength"`
+	ContentSha1     string `json:"contentSha1"`
+	UploadTimestamp int64  `json:"uploadTimestamp"`
+}
+
+// ListUnfinishedLargeFiles returns an iterator over the large files of bucket started but neither
+// finished nor cancelled, with names starting with opts.Prefix. Their uploaded parts are stored
+// and billed until the file is finished or cancelled.
+func (c *Client) ListUnfinishedLargeFiles(bucket string, opts ListOptions) *FileIterator {
+	return c.ListUnfinishedLargeFilesContext(context.Background(), bucket, opts)
+}
+
+// ListUnfinishedLargeFilesContext is like ListUnfinishedLargeFiles with a context limiting the requests
+func (c *Client) ListUnfinishedLargeFilesContext(ctx context.Context, bucket string, opts ListOptions) *FileIterator {
+	return c.newFileIterator(ctx, "b2_list_unfinished_large_files", bucket, opts)
+}
+
+// CancelLargeFile cancels the unfinished large file fileID and deletes its uploaded parts
+func (c *Client) CancelLargeFile(fileID string) error {
+	return c.CancelLargeFileContext(context.Background(), fileID)
+}
+
+// CancelLargeFileContext is like CancelLargeFile with a context limiting the request
+func (c *Client) CancelLargeFileContext(ctx context.Context, fileID string) error {
+	err := c.apiCall(ctx, "b2_cancel_large_file", map[string]string{"fileId": fileID}, nil)
+	if err != nil {
+		c.Logger.Warn("Could not cancel large file",
+			zap.String("File ID", fileID),
+			zap.Error(err),
+		)
+		return err
+	}
+	c.Logger.Info("Large file cancelled",
+		zap.String("File ID", fileID),
+	)
+	return nil
+}
+
+// ListParts returns the parts uploaded so far for the unfinished large file fileID, ordered by part number
+func (c *Client) ListParts(fileID string) ([]LargeFilePart, error) {
+	return c.ListPartsContext(context.Background(), fileID)
+}
+
+// ListPartsContext is like ListParts with a context limiting the requests
+func (c *Client) ListPartsContext(ctx context.Context, fileID string) ([]LargeFilePart, error) {
+	var parts []LargeFilePart
+	startPartNumber := 0
+	for {
+		reqBody := map[string]interface{}{
+			"fileId":       fileID,
+			"maxPartCount": 1000,
+		}
+		if startPartNumber > 0 {
+			reqBody["startPartNumber"] = startPartNumber
+		}
+		var page struct {
+			Parts          []LargeFilePart `json:"parts"`
+			NextPartNumber *int            `json:"nextPartNumber"`
+		}
+		if err := c.apiCall(ctx, "b2_list_parts", reqBody, &page); err != nil {
+			c.Logger.Warn("Could not list parts of large file",
+				zap.String("File ID", fileID),
+				zap.Error(err),
+			)
+			return parts, err
+		}
+		parts = append(parts, page.Parts...)
+		if page.NextPartNumber == nil || *page.NextPartNumber == 0 {
+			return parts, nil
+		}
+		startPartNumber = *page.NextPartNumber
+	}
+}
+
+// ResumeLargeFileUpload finishes an interrupted upload of the large file at filePath. The unfinished
+// large file in bucket with the name, size and large_file_sha1 of the local file is completed by
+// uploading only the parts that are missing or do not match the local file.
+func (c *Client) ResumeLargeFileUpload(bucket string, filePath string) (File, error) {
+	return c.ResumeLargeFileUploadContext(context.Background(), bucket, filePath)
+}
+
+// ResumeLargeFileUploadContext is like ResumeLargeFileUpload. Cancelling ctx stops all parts in
+// flight and returns a *LargeFileError reporting which parts finished.
+func (c *Client) ResumeLargeFileUploadContext(ctx context.Context, bucket string, filePath string) (File, error) {
+	bucketID, err := c.ResolveBucketContext(ctx, bucket)
+	if err != nil {
+		return File{}, err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return File{}, err
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return File{}, err
+	}
+	largeFileSHA1, err := fileSHA1(filePath)
+	if err != nil {
+		return File{}, err
+	}
+	unfinished, found, err := c.findUnfinishedLargeFile(ctx, bucketID, fileInfo.Name(), largeFileSHA1)
+	if err != nil {
+		return File{}, err
+	}
+	if !found {
+		return File{}, &APIError{Status: http.StatusNotFound, Code: "not_found", Message: "no unfinished large file matches " + filePath}
+	}
+	return c.resumeLargeFile(ctx, unfinished, file, fileInfo.Size())
+}
+
+// findUnfinishedLargeFile returns the newest unfinished large file in bucketID named fileName with
+// the large_file_sha1 largeFileSHA1
+func (c *Client) findUnfinishedLargeFile(ctx context.Context, bucketID string, fileName string, largeFileSHA1 string) (File, bool, error) {
+	var match File
+	found := false
+	files := c.ListUnfinishedLargeFilesContext(ctx, bucketID, ListOptions{Prefix: fileName})
+	for files.Next() {
+		f := files.File()
+		if f.FileName == fileName && f.FileInfo["large_file_sha1"] == largeFileSHA1 && (!found || f.UploadTimestamp > match.UploadTimestamp) {
+			match, found = f, true
+		}
+	}
+	return match, found, files.Err()
+}
+
+// resumeLargeFile uploads the parts of file, size bytes long, missing from the unfinished large file
+// and finishes it. The part size is taken from the first uploaded part, so uploads started with a
+// different part size are resumed correctly.
+func (c *Client) resumeLargeFile(ctx context.Context, unfinished File, file io.ReaderAt, size int64) (File, error) {
+	uploaded, err := c.ListPartsContext(ctx, unfinished.FileID)
+	if err != nil {
+		return File{}, err
+	}
+	partSize := int64(largeFilePartSize)
+	byNumber := make(map[int]LargeFilePart)
+	for _, part := range uploaded {
+		byNumber[part.PartNumber] = part
+		if part.PartNumber == 1 {
+			partSize = part.ContentLength
+		}
+	}
+	if partSize <= 0 {
+		partSize = largeFilePartSize
+	}
+	pieces := newPieces(size, partSize)
+	count := len(pieces)
+	for i := range pieces {
+		hash := sha1.New()
+		if _, err := io.Copy(hash, io.NewSectionReader(file, int64(i)*partSize, pieces[i].Size)); err != nil {
+			return File{}, err
+		}
+		pieces[i].SHA1 = hex.EncodeToString(hash.Sum(nil))
+		if part, ok := byNumber[i+1]; ok && part.ContentLength == pieces[i].Size && part.ContentSha1 == pieces[i].SHA1 {
+			pieces[i].Status = "Success"
+		}
+	}
+	for _, part := range uploaded {
+		if part.PartNumber > count {
+			return File{}, fmt.Errorf("Unfinished large file %v has part %d, the local file of %d bytes only %d", unfinished.FileID, part.PartNumber, size, count)
+		}
+	}
+
+	c.Logger.Info("Resuming Large File Upload",
+		zap.String("B2 File ID", unfinished.FileID),
+		zap.String("Filename", unfinished.FileName),
+		zap.Int("Parts", count),
+		zap.Int("Parts Uploaded", len(uploaded)),
+	)
+	if err := c.uploadMissingPieces(ctx, unfinished.FileID, file, partSize, pieces, nil); err != nil {
+		return File{}, err
+	}
+	partSha1Array := make([]string, count)
+	for i := range pieces {
+		partSha1Array[i] = pieces[i].SHA1
+	}
+	return c.finishLargeFile(ctx, unfinished.FileID, partSha1Array)
+}
+
+// uploadMissingPieces uploads the pieces of file not yet successful with c.UploadConcurrency
+// uploads at a time, calling acked, unless nil, with the index of every piece B2 acknowledged. It
+// returns a *LargeFileError if any piece failed or ctx was cancelled. Pieces are read from file
+// with ReadAt as they are sent, pieces without a SHA1 are hashed on the way and their SHA1 set.
+func (c *Client) uploadMissingPieces(ctx context.Context, fileID string, file io.ReaderAt, partSize int64, pieces []B2FilePiece, acked func(index int)) error {
+	missing := make(chan int)
+	concurrency, bufferSize := c.uploadReadBuffers()
+	pbpool := c.startProgressPool()
+	errs := make([]error, len(pieces))
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range missing {
+				p := pieces[index]
+				pbar := pb.New64(p.Size).SetUnits(pb.U_BYTES)
+				pbar.SetRefreshRate(time.Second)
+				pbar.Prefix(fmt.Sprintf("Part %v of %v", p.PieceNum+1, len(pieces)))
+				pbar.ShowSpeed = true
+				pbar.ShowTimeLeft = true
+				if pbpool != nil {
+					pbpool.Add(pbar)
+				}
+				pbar.Start()
+				sum, retries, err := c.uploadPiece(ctx, fileID, p, func() io.Reader {
+					pbar.Set(0)
+					piece := io.NewSectionReader(file, int64(p.PieceNum)*partSize, p.Size)
+					return pbar.NewProxyReader(bufio.NewReaderSize(piece, bufferSize))
+				})
+				pbar.Finish()
+				pieces[index].Retries = retries
+				if err != nil {
+					errs[index] = err
+					pieces[index].Status = "Failed"
+					continue
+				}
+				pieces[index].SHA1 = sum
+				pieces[index].Status = "Success"
+				if acked != nil {
+					acked(index)
+				}
+			}
+		}()
+	}
+	for i := range pieces {
+		if pieces[i].Status == "Success" {
+			continue
+		}
+		select {
+		case missing <- i:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(missing)
+	wg.Wait()
+	if pbpool != nil {
+		pbpool.Stop()
+	}
+
+	largeFileErr := &LargeFileError{FileID: fileID, Err: ctx.Err()}
+	for i := range pieces {
+		if pieces[i].Status == "Success" {
+			largeFileErr.Finished = append(largeFileErr.Finished, i+1)
+			continue
+		}
+		largeFileErr.Failed = append(largeFileErr.Failed, i+1)
+		if largeFileErr.Err == nil && errs[i] != nil {
+			largeFileErr.Err = fmt.Errorf("part %d: %w", i+1, errs[i])
+		}
+	}
+	if largeFileErr.Failed != nil {
+		c.Logger.Warn("Large file upload incomplete",
+			zap.String("B2 File ID", fileID),
+			zap.Int("Finished Parts", len(largeFileErr.Finished)),
+			zap.Int("Failed Parts", len(largeFileErr.Failed)),
+			zap.Error(largeFileErr.Err),
+		)
+		return largeFileErr
+	}
+	return nil
+}
+
+// uploadReadBuffers returns the number of parts uploaded at a time and the size of the buffer
+// each of them reads the source through, splitting c.UploadMemoryBudget between the parts.
+// Fewer parts than c.UploadConcurrency are sent at a time if the budget is too small for all.
+func (c *Client) uploadReadBuffers() (int, int) {
+	concurrency := c.UploadConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	budget := c.UploadMemoryBudget
+	if budget <= 0 {
+		budget = DefaultUploadMemoryBudget
+	}
+	bufferSize := budget / int64(concurrency)
+	if bufferSize < minUploadReadBuffer {
+		bufferSize = minUploadReadBuffer
+		if concurrency = int(budget / minUploadReadBuffer); concurrency < 1 {
+			concurrency = 1
+		}
+	}
+	if bufferSize > largeFilePartSize {
+		bufferSize = largeFilePartSize
+	}
+	return concurrency, int(bufferSize)
+}
+
+// newPieces returns the pieces of a file of size bytes split into parts of partSize bytes
+func newPieces(size int64, partSize int64) []B2FilePiece {
+	count := int((size + partSize - 1) / partSize)
+	pieces := make([]B2FilePiece, count)
+	for i := range pieces {
+		pieces[i] = B2FilePiece{PieceNum: i, Size: partSize, Status: "Unprocessed"}
+		if i == count-1 {
+			pieces[i].Size = size - int64(i)*partSize
+		}
+	}
+	return pieces
+}
+
+// resumeIfUnfinished resumes the upload of filePath if bucketID holds a matching unfinished large
+// file, reporting whether one was found. Failing to look for one is not an error, the file is
+// then uploaded from scratch.
+func (c *Client) resumeIfUnfinished(ctx context.Context, bucketID string, filePath string, largeFileSHA1 string) (File, bool, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return File{}, false, err
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return File{}, false, err
+	}
+	unfinished, found, err := c.findUnfinishedLargeFile(ctx, bucketID, filepath.Base(filePath), largeFileSHA1)
+	if err != nil || !found {
+		if err != nil {
+			c.Logger.Debug("Could not look for unfinished large file",
+				zap.String("Filepath", filePath),
+				zap.Error(err),
+			)
+		}
+		return File{}, false, nil
+	}
+	c.Logger.Info("Found unfinished large file upload",
+		zap.String("Filepath", filePath),
+		zap.String("B2 File ID", unfinished.FileID),
+	)
+	finished, err := c.resumeLargeFile(ctx, unfinished, file, fileInfo.Size())
+	return finished, true, err
+}
+
+// statefulLargeFileUpload uploads filePath to bucketID as a large file, saving the large file ID,
+// the part SHA1s and the parts B2 acknowledged in db, the database at c.StatePath. An upload of the
+// same unchanged file saved there continues without hashing the file again or sending the
+// acknowledged parts.
+func (c *Client) statefulLargeFileUpload(ctx context.Context, db *boltDB, bucketID string, filePath string) (File, error) {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return File{}, err
+	}
+	file, err := os.Open(absPath)
+	if err != nil {
+		return File{}, err
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return File{}, err
+	}
+	modTimeMillis := fileInfo.ModTime().UnixNano() / 1000000
+	key := uploadStateKey(bucketID, absPath)
+
+	state, found, err := db.loadUploadState(key)
+	if err != nil {
+		return File{}, err
+	}
+	if found && (state.Size != fileInfo.Size() || state.ModTimeMillis != modTimeMillis || state.PartSize <= 0 || len(state.Acked) != len(state.PartSHA1s)) {
+		c.Logger.Info("Local file changed since its upload was saved, starting over",
+			zap.String("Filepath", absPath),
+			zap.String("B2 File ID", state.FileID),
+		)
+		found = false
+	}
+	var uploaded []LargeFilePart
+	if found {
+		uploaded, err = c.ListPartsContext(ctx, state.FileID)
+		if errors.Is(err, ErrBadRequest) || errors.Is(err, ErrNotFound) {
+			// The large file was finished or cancelled since the upload was saved
+			found = false
+		} else if err != nil {
+			return File{}, err
+		}
+	}
+	if !found {
+		largeFileSHA1, partSHA1s, err := hashParts(file, fileInfo.Size(), largeFilePartSize)
+		if err != nil {
+			return File{}, err
+		}
+		if len(partSHA1s) > 10000 {
+			return File{}, errors.New("File cannot be split into more than 10000 pieces")
+		}
+		state = uploadState{
+			Size:          fileInfo.Size(),
+			ModTimeMillis: modTimeMillis,
+			LargeFileSHA1: largeFileSHA1,
+			PartSize:      largeFilePartSize,
+			PartSHA1s:     partSHA1s,
+			Acked:         make([]bool, len(partSHA1s)),
+		}
+		if state.FileID, uploaded, err = c.startOrFindLargeFile(ctx, bucketID, fileInfo, largeFileSHA1, len(partSHA1s)); err != nil {
+			return File{}, err
+		}
+		if err := db.saveUploadState(key, state); err != nil {
+			return File{}, err
+		}
+	}
+
+	listed := make(map[int]LargeFilePart)
+	for _, part := range uploaded {
+		listed[part.PartNumber] = part
+	}
+	pieces := newPieces(state.Size, state.PartSize)
+	if len(pieces) != len(state.PartSHA1s) {
+		return File{}, fmt.Errorf("Saved upload of %v has %d parts, the file %d", absPath, len(state.PartSHA1s), len(pieces))
+	}
+	done := 0
+	for i := range pieces {
+		pieces[i].SHA1 = state.PartSHA1s[i]
+		if part, ok := listed[i+1]; state.Acked[i] || (ok && part.ContentSha1 == pieces[i].SHA1) {
+			pieces[i].Status = "Success"
+			done++
+		}
+	}
+	c.Logger.Info("Beginning Multipart Upload",
+		zap.String("B2 File ID", state.FileID),
+		zap.Int64("Size", state.Size),
+		zap.Int("Pieces", len(pieces)),
+		zap.Int("Pieces Uploaded", done),
+	)
+	err = c.uploadMissingPieces(ctx, state.FileID, file, state.PartSize, pieces, func(index int) {
+		if err := db.ackPart(key, index); err != nil {
+			c.Logger.Warn("Could not save uploaded part",
+				zap.String("B2 File ID", state.FileID),
+				zap.Int("B2 Part #", index+1),
+				zap.Error(err),
+			)
+		}
+	})
+	if err != nil {
+		return File{}, err
+	}
+	finished, err := c.finishLargeFile(ctx, state.FileID, state.PartSHA1s)
+	if err != nil {
+		return finished, err
+	}
+	if err := db.deleteUploadState(key); err != nil {
+		c.Logger.Warn("Could not remove saved upload",
+			zap.String("Filepath", absPath),
+			zap.Error(err),
+		)
+	}
+	return finished, nil
+}
+
+// startOrFindLargeFile returns the ID and uploaded parts of the unfinished large file matching the
+// local file described by fileInfo, or of a new large file if none of count parts or fewer exists
+func (c *Client) startOrFindLargeFile(ctx context.Context, bucketID string, fileInfo os.FileInfo, largeFileSHA1 string, count int) (string, []LargeFilePart, error) {
+	unfinished, found, err := c.findUnfinishedLargeFile(ctx, bucketID, fileInfo.Name(), largeFileSHA1)
+	if err != nil {
+		c.Logger.Debug("Could not look for unfinished large file",
+			zap.String("Filename", fileInfo.Name()),
+			zap.Error(err),
+		)
+	}
+	if found {
+		uploaded, err := c.ListPartsContext(ctx, unfinished.FileID)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(uploaded) == 0 || uploaded[len(uploaded)-1].PartNumber <= count {
+			c.Logger.Info("Found unfinished large file upload",
+				zap.String("Filename", fileInfo.Name()),
+				zap.String("B2 File ID", unfinished.FileID),
+			)
+			return unfinished.FileID, uploaded, nil
+		}
+	}
+	started, err := c.startLargeFile(ctx, bucketID, fileInfo, largeFileSHA1)
+	return started.FileID, nil, err
+}
+
+// PrintUnfinishedLargeFiles Displays unfinished large files in console with the time they were started
+func PrintUnfinishedLargeFiles(files []File) error {
+	writer := new(tabwriter.Writer)
+	writer.Init(os.Stdout, 0, 5, 1, ' ', 0)
+	fmt.Fprintln(writer, "-NAME-\t -STARTED-\t -LARGE FILE SHA1-\t -ID-")
+	for _, f := range files {
+		started := time.Unix(0, f.UploadTimestamp*int64(time.Millisecond)).Format("2006-01-02 15:04:05")
+		fmt.Fprintln(writer, f.FileName+"\t", started+"\t", f.FileInfo["large_file_sha1"]+"\t", f.FileID+"\t")
+	}
+	fmt.Fprintln(writer)
+	return writer.Flush()
+}
@@ -0,0 +1,99 @@
+package gopherb2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// LifecycleRule hides and deletes the files of a bucket with names starting with FileNamePrefix,
+// an empty prefix applies to all files. Days left 0 are not set.
+type LifecycleRule struct {
+	FileNamePrefix            string `json:"fileNamePrefix"`
+	DaysFromUploadingToHiding int    `json:"daysFromUploadingToHiding,omitempty"`
+	DaysFromHidingToDeleting  int    `json:"daysFromHidingToDeleting,omitempty"`
+}
+
+// ValidateLifecycleRules checks rules like B2 does, so invalid rules are rejected before anything
+// is sent: days must not be negative, every rule must set at least one of them, and no prefix may
+// start with the prefix of another rule.
+func ValidateLifecycleRules(rules []LifecycleRule) error {
+	for i, rule := range rules {
+		if rule.DaysFromUploadingToHiding < 0 || rule.DaysFromHidingToDeleting < 0 {
+			return fmt.Errorf("Lifecycle rule for prefix %q has negative days", rule.FileNamePrefix)
+		}
+		if rule.DaysFromUploadingToHiding == 0 && rule.DaysFromHidingToDeleting == 0 {
+			return fmt.Errorf("Lifecycle rule for prefix %q must hide or delete files", rule.FileNamePrefix)
+		}
+		for _, other := range rules[i+1:] {
+			if strings.HasPrefix(rule.FileNamePrefix, other.FileNamePrefix) || strings.HasPrefix(other.FileNamePrefix, rule.FileNamePrefix) {
+				return fmt.Errorf("Lifecycle rule prefixes %q and %q overlap", rule.FileNamePrefix, other.FileNamePrefix)
+			}
+		}
+	}
+	return nil
+}
+
+// AddLifecycleRule adds rule to the lifecycle rules of bucket bucketID and returns the updated Bucket.
+// The update fails if the rules of the bucket were changed concurrently.
+func (c *Client) AddLifecycleRule(bucketID string, rule LifecycleRule) (Bucket, error) {
+	return c.AddLifecycleRuleContext(context.Background(), bucketID, rule)
+}
+
+// AddLifecycleRuleContext is like AddLifecycleRule with a context limiting the requests
+func (c *Client) AddLifecycleRuleContext(ctx context.Context, bucketID string, rule LifecycleRule) (Bucket, error) {
+	bucket, err := c.GetBucketContext(ctx, bucketID)
+	if err != nil {
+		return bucket, err
+	}
+	rules := append(append([]LifecycleRule{}, bucket.LifecycleRules...), rule)
+	return c.UpdateBucketContext(ctx, bucket.BucketID, BucketSettings{LifecycleRules: rules, IfRevisionMatches: bucket.Revision})
+}
+
+// RemoveLifecycleRule removes the lifecycle rule for fileNamePrefix from bucket bucketID and returns
+// the updated Bucket. The update fails if the rules of the bucket were changed concurrently.
+func (c *Client) RemoveLifecycleRule(bucketID string, fileNamePrefix string) (Bucket, error) {
+	return c.RemoveLifecycleRuleContext(context.Background(), bucketID, fileNamePrefix)
+}
+
+// RemoveLifecycleRuleContext is like RemoveLifecycleRule with a context limiting the requests
+func (c *Client) RemoveLifecycleRuleContext(ctx context.Context, bucketID string, fileNamePrefix string) (Bucket, error) {
+	bucket, err := c.GetBucketContext(ctx, bucketID)
+	if err != nil {
+		return bucket, err
+	}
+	rules := []LifecycleRule{}
+	for _, rule := range bucket.LifecycleRules {
+		if rule.FileNamePrefix != fileNamePrefix {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == len(bucket.LifecycleRules) {
+		return bucket, errors.New("No lifecycle rule for prefix \"" + fileNamePrefix + "\"")
+	}
+	return c.UpdateBucketContext(ctx, bucket.BucketID, BucketSettings{LifecycleRules: rules, IfRevisionMatches: bucket.Revision})
+}
+
+// PrintLifecycleRules Displays lifecycle rules in console
+func PrintLifecycleRules(rules []LifecycleRule) error {
+	writer := new(tabwriter.Writer)
+	fmt.Println("Lifecycle Rules")
+	writer.Init(os.Stdout, 0, 5, 1, ' ', 0)
+	fmt.Fprintln(writer, "-PREFIX-\t -DAYS TO HIDE-\t -DAYS TO DELETE-")
+	for _, rule := range rules {
+		fmt.Fprintln(writer, fmt.Sprintf("%q\t", rule.FileNamePrefix), days(rule.DaysFromUploadingToHiding)+"\t", days(rule.DaysFromHidingToDeleting)+"\t")
+	}
+	fmt.Fprintln(writer)
+	return writer.Flush()
+}
+
+// days formats a lifecycle rule day count, where 0 means not set
+func days(n int) string {
+	if n == 0 {
+		return "-"
+	}
+	return fmt.Sprint(n)
+}
@@ -1,18 +1,40 @@
 package gopherb2
 
 import (
+	"encoding/json"
+	"time"
+
 	"github.com/boltdb/bolt"
 	"github.com/uber-go/zap"
-	"time"
 )
 
+// checksumsBucket holds the state of large file uploads in progress, keyed by bucket ID and local path
+var checksumsBucket = []byte("checksums")
+
 type boltDB struct {
 	*bolt.DB
 }
 
+// uploadState is the progress of a large file upload, saved so a killed upload of the same file
+// continues where it stopped
+type uploadState struct {
+	FileID        string `json:"fileId"`
+	Size          int64  `json:"size"`
+	ModTimeMillis int64  `json:"modTimeMillis"`
+	LargeFileSHA1 string `json:"largeFileSha1"`
+	PartSize      int64  `json:"partSize"`
+	// PartSHA1s holds the SHA1 of every part, in part number order
+	PartSHA1s []string `json:"partSha1s"`
+	// Acked tells which parts B2 acknowledged, in part number order
+	Acked []bool `json:"acked"`
+}
+
+// openDBTimeout is how long openDB waits for another process holding the database to close it
+var openDBTimeout = 2 * time.Second
+
 // openDB opens a database.
 func openDB(file string) (*boltDB, error) {
-	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: openDBTimeout})
 	if err != nil {
 		logger.Warn("Could not open boltdb file",
 			zap.Error(err),
@@ -20,18 +42,70 @@ func openDB(file string) (*boltDB, error) {
 		return nil, err
 	}
 	err = db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte("checksums"))
+		_, err := tx.CreateBucketIfNotExists(checksumsBucket)
 		return err
 	})
 	return &boltDB{DB: db}, err
 }
 
-/*
-boltdb, err := bolt.Open("gopherb2.db", 0600, nil)
-if err != nil {
-    logger.Fatal("Could not open boltdb",
-        zap.Error(err),
-    )
+// uploadStateKey returns the key of the upload state of the local file at absPath to bucketID
+func uploadStateKey(bucketID string, absPath string) []byte {
+	return []byte(bucketID + "/" + absPath)
+}
+
+// loadUploadState returns the upload state saved under key, reporting whether there is one
+func (db *boltDB) loadUploadState(key []byte) (uploadState, bool, error) {
+	var state uploadState
+	found := false
+	err := db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(checksumsBucket).Get(key)
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &state)
+	})
+	return state, found, err
+}
+
+// saveUploadState saves state under key, replacing any earlier state
+func (db *boltDB) saveUploadState(key []byte, state uploadState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(checksumsBucket).Put(key, data)
+	})
+}
+
+// ackPart records that B2 acknowledged the part at index of the upload state saved under key
+func (db *boltDB) ackPart(key []byte, index int) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(checksumsBucket)
+		var state uploadState
+		data := bucket.Get(key)
+		if data == nil {
+			return nil
+		}
+		if err := json.Unmarshal(data, &state); err != nil {
+			return err
+		}
+		if index >= len(state.Acked) {
+			return nil
+		}
+		state.Acked[index] = true
+		data, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, data)
+	})
+}
+
+// deleteUploadState removes the upload state saved under key
+func (db *boltDB) deleteUploadState(key []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(checksumsBucket).Delete(key)
+	})
 }
-defer boltdb.Close()
-*/
@@ -0,0 +1,7 @@
+//go:build race
+
+package gopherb2
+
+func init() {
+	raceEnabled = true
+}
@@ -0,0 +1,197 @@
+package gopherb2
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+
+	blake2b "github.com/dsjr2006/blake2b-simd"
+	"github.com/uber-go/zap"
+)
+
+// DefaultDownloadRangeSize is the size of the ranges fetched by parallel downloads of clients
+// returned from NewClient
+const DefaultDownloadRangeSize = 100 * 1000 * 1000
+
+// DownloadTarget is written by parallel downloads at the offsets of the ranges fetched, and read back
+// in order to verify the whole file. *os.File is a DownloadTarget, and is grown to the size of the
+// file before the ranges are written.
+type DownloadTarget interface {
+	io.WriterAt
+	io.ReaderAt
+}
+
+// ParallelDownloadFileByID downloads the file version fileID into w, fetching ranges of
+// c.DownloadRangeSize bytes with c.DownloadConcurrency requests at a time, and verifies the whole
+// file against its SHA1 or large_file_sha1
+func (c *Client) ParallelDownloadFileByID(fileID string, w DownloadTarget) (DownloadedFile, error) {
+	return c.ParallelDownloadFileByIDContext(context.Background(), fileID, w)
+}
+
+// ParallelDownloadFileByIDContext is like ParallelDownloadFileByID with a context limiting the download
+func (c *Client) ParallelDownloadFileByIDContext(ctx context.Context, fileID string, w DownloadTarget) (DownloadedFile, error) {
+	downloadURL := func(auth APIAuthorization) string {
+		return c.downloadEndpoint(auth, "b2_download_file_by_id") + "?fileId=" + url.QueryEscape(fileID)
+	}
+	return c.parallelDownload(ctx, "b2_download_file_by_id", "", downloadURL, w)
+}
+
+// ParallelDownloadFileByName is like ParallelDownloadFileByID for the latest version of fileName in bucket
+func (c *Client) ParallelDownloadFileByName(bucket string, fileName string, w DownloadTarget) (DownloadedFile, error) {
+	return c.ParallelDownloadFileByNameContext(context.Background(), bucket, fileName, w)
+}
+
+// ParallelDownloadFileByNameContext is like ParallelDownloadFileByName with a context limiting the download
+func (c *Client) ParallelDownloadFileByNameContext(ctx context.Context, bucket string, fileName string, w DownloadTarget) (DownloadedFile, error) {
+	bucketID, err := c.ResolveBucketContext(ctx, bucket)
+	if err != nil {
+		return DownloadedFile{}, err
+	}
+	if err := c.checkFileName("b2_download_file_by_name", bucketID, fileName); err != nil {
+		return DownloadedFile{}, err
+	}
+	bucketName, err := c.bucketName(ctx, bucketID)
+	if err != nil {
+		return DownloadedFile{}, err
+	}
+	downloadURL := func(auth APIAuthorization) string {
+		return downloadByNameURL(auth, bucketName, fileName)
+	}
+	return c.parallelDownload(ctx, "b2_download_file_by_name", bucketID, downloadURL, w)
+}
+
+// rangeResult reports the download of range index
+type rangeResult struct {
+	index int
+	err   error
+}
+
+// parallelDownload fetches the first range from downloadURL to learn the size and version of the
+// file, then the other ranges of that version by ID concurrently. Finished ranges are hashed in order
+// while later ranges are still downloading.
+func (c *Client) parallelDownload(ctx context.Context, operation string, bucketID string, downloadURL func(APIAuthorization) string, w DownloadTarget) (DownloadedFile, error) {
+	rangeSize := c.DownloadRangeSize
+	if rangeSize <= 0 {
+		rangeSize = DefaultDownloadRangeSize
+	}
+	concurrency := c.DownloadConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	file, _, err := c.fetch(ctx, operation, bucketID, downloadURL, io.NewOffsetWriter(w, 0), 0, rangeSize)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusRequestedRangeNotSatisfiable {
+		// Empty files have no range to request
+		return c.download(ctx, operation, bucketID, downloadURL, io.NewOffsetWriter(w, 0), DownloadOptions{})
+	}
+	if err != nil {
+		c.Logger.Warn("Download failed",
+			zap.String("Filename", file.FileName),
+			zap.Error(err),
+		)
+		return file, err
+	}
+	if t, ok := w.(interface{ Truncate(int64) error }); ok {
+		if err := t.Truncate(file.ContentLength); err != nil {
+			return file, err
+		}
+	}
+
+	count := int((file.ContentLength + rangeSize - 1) / rangeSize)
+	if count == 0 {
+		count = 1
+	}
+	rangeOf := func(index int) (int64, int64) {
+		start := int64(index) * rangeSize
+		if end := start + rangeSize; end < file.ContentLength {
+			return start, rangeSize
+		}
+		return start, file.ContentLength - start
+	}
+	byID := func(auth APIAuthorization) string {
+		return c.downloadEndpoint(auth, "b2_download_file_by_id") + "?fileId=" + url.QueryEscape(file.FileID)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ranges := make(chan int)
+	results := make(chan rangeResult, count)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency && i < count-1; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range ranges {
+				start, length := rangeOf(index)
+				part, _, err := c.fetch(ctx, "b2_download_file_by_id", bucketID, byID, io.NewOffsetWriter(w, start), start, length)
+				if err == nil && part.FileID != file.FileID {
+					err = &APIError{Status: http.StatusConflict, Code: "file_changed", Message: "file " + file.FileName + " was replaced during the download"}
+				}
+				results <- rangeResult{index: index, err: err}
+			}
+		}()
+	}
+	go func() {
+		defer close(ranges)
+		for index := 1; index < count; index++ {
+			select {
+			case ranges <- index:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	sha1Hash, blake2bHash := sha1.New(), blake2b.New512()
+	hashes := io.MultiWriter(sha1Hash, blake2bHash)
+	finished := make([]bool, count)
+	finished[0] = true
+	next := 0
+	for received := 0; ; received++ {
+		for next < count && finished[next] {
+			start, length := rangeOf(next)
+			if _, err = io.Copy(hashes, io.NewSectionReader(w, start, length)); err != nil {
+				break
+			}
+			next++
+		}
+		if err != nil || received == count-1 {
+			break
+		}
+		result := <-results
+		if err = result.err; err != nil {
+			break
+		}
+		finished[result.index] = true
+	}
+	cancel()
+	wg.Wait()
+	if err != nil {
+		c.Logger.Warn("Parallel download failed",
+			zap.String("Filename", file.FileName),
+			zap.Int("Ranges verified", next),
+			zap.Error(err),
+		)
+		return file, err
+	}
+
+	if err := verifyDownload(file, sha1Hash, blake2bHash); err != nil {
+		c.Logger.Warn("Downloaded file does not match its hash",
+			zap.String("Filename", file.FileName),
+			zap.String("File ID", file.FileID),
+			zap.Error(err),
+		)
+		return file, err
+	}
+	c.Logger.Info("File Downloaded",
+		zap.String("Filename", file.FileName),
+		zap.String("File ID", file.FileID),
+		zap.Int("Ranges", count),
+	)
+	return file, nil
+}
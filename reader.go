@@ -0,0 +1,265 @@
+package gopherb2
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uber-go/zap"
+	pb "gopkg.in/cheggaaa/pb.v1"
+)
+
+// MaxSingleUploadSize is the largest file B2 stores with a single b2_upload_file call
+const MaxSingleUploadSize = 5 * 1000 * 1000 * 1000
+
+// UnknownSize is passed as the size of UploadReader for streams whose length is not known
+const UnknownSize = -1
+
+// UploadReader stores the data read from r as fileName in bucket, size is the number of bytes r
+// holds, or UnknownSize. Readers of known size are sent with a single upload, rewound for retries
+// if r is an io.Seeker and buffered in memory otherwise. Streams of unknown size, or streams that
+// cannot be rewound and are too large to buffer, are read in parts of 100 MiB uploaded as a large
+// file, keeping at most c.UploadConcurrency+1 parts in memory.
+func (c *Client) UploadReader(bucket string, fileName string, r io.Reader, size int64) (File, error) {
+	return c.UploadReaderContext(context.Background(), bucket, fileName, r, size)
+}
+
+// UploadReaderContext is like UploadReader, cancelling ctx stops the upload
+func (c *Client) UploadReaderContext(ctx context.Context, bucket string, fileName string, r io.Reader, size int64) (File, error) {
+	bucketID, err := c.ResolveBucketContext(ctx, bucket)
+	if err != nil {
+		return File{}, err
+	}
+	if size < 0 && size != UnknownSize {
+		return File{}, fmt.Errorf("Invalid size %d of %v", size, fileName)
+	}
+	if seeker, ok := r.(io.ReadSeeker); ok && size >= 0 && size <= MaxSingleUploadSize {
+		return c.uploadSeeker(ctx, bucketID, fileName, seeker, size)
+	}
+	if size >= 0 && size <= largeFilePartSize {
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return File{}, err
+		}
+		return c.uploadSeeker(ctx, bucketID, fileName, bytes.NewReader(data), size)
+	}
+
+	// Read the first part, a stream ending within it is sent with a single upload
+	first := make([]byte, largeFilePartSize)
+	n, err := io.ReadFull(r, first)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return c.uploadSeeker(ctx, bucketID, fileName, bytes.NewReader(first[:n]), int64(n))
+	}
+	if err != nil {
+		return File{}, err
+	}
+	// A large file needs at least two parts
+	next := make([]byte, 1)
+	if _, err := io.ReadFull(r, next); err == io.EOF {
+		return c.uploadSeeker(ctx, bucketID, fileName, bytes.NewReader(first), int64(n))
+	} else if err != nil {
+		return File{}, err
+	}
+	return c.uploadLargeStream(ctx, bucketID, fileName, io.MultiReader(bytes.NewReader(first), bytes.NewReader(next), r), largeFilePartSize)
+}
+
+// uploadSeeker uploads size bytes of body from its current offset with a single request, sending
+// the SHA1 after the data so body is read only once per attempt
+func (c *Client) uploadSeeker(ctx context.Context, bucketID string, fileName string, body io.ReadSeeker, size int64) (File, error) {
+	var uploaded File
+	start, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return uploaded, err
+	}
+	pbar := pb.New64(size).SetUnits(pb.U_BYTES)
+	pbar.SetRefreshRate(time.Second)
+	pbar.ShowSpeed = true
+	pbar.ShowTimeLeft = true
+	pbar.Start()
+
+	header := http.Header{}
+	header.Add("Content-Type", "b2/x-auto")
+	header.Add("X-Bz-Content-Sha1", "hex_digits_at_end")
+	header.Add("X-Bz-File-Name", strings.Replace(escapeFileName(fileName), "+", "%2B", -1))
+	var trailer *sha1Trailer
+	apiResponse, retries, err := c.uploadWithRetry(ctx, "b2_upload_file", uploadTarget{}, c.uploadURLTarget(ctx, bucketID), header, size+sha1.Size*2, func() (io.Reader, error) {
+		pbar.Set(0)
+		if _, err := body.Seek(start, io.SeekStart); err != nil {
+			return nil, err
+		}
+		trailer = &sha1Trailer{r: pbar.NewProxyReader(io.LimitReader(body, size)), hash: sha1.New()}
+		return trailer, nil
+	})
+	pbar.Finish()
+	if err != nil {
+		c.Logger.Warn("Could not upload file",
+			zap.String("Filename", fileName),
+			zap.Int("Retries", retries),
+			zap.Error(err),
+		)
+		return uploaded, err
+	}
+	if err := json.Unmarshal(apiResponse.Body, &uploaded); err != nil {
+		return uploaded, err
+	}
+	if uploaded.ContentSha1 != trailer.sum {
+		c.Logger.Warn("API Response SHA1 Hash Mismatch.",
+			zap.String("Local SHA1", trailer.sum),
+			zap.String("API SHA1", uploaded.ContentSha1),
+		)
+		return uploaded, ErrSHA1Mismatch
+	}
+	c.Logger.Info("File Uploaded",
+		zap.String("Filename", uploaded.FileName),
+		zap.String("File ID", uploaded.FileID),
+		zap.Int("Retries", retries),
+	)
+	return uploaded, nil
+}
+
+// sha1Trailer reads r followed by the hex SHA1 of the data read from r, the body of uploads with
+// the SHA1 hex_digits_at_end
+type sha1Trailer struct {
+	r    io.Reader
+	hash hash.Hash
+	// sum is the hex SHA1 of r, set once r is read to the end
+	sum     string
+	trailer io.Reader
+}
+
+func (t *sha1Trailer) Read(p []byte) (int, error) {
+	if t.trailer != nil {
+		return t.trailer.Read(p)
+	}
+	n, err := t.r.Read(p)
+	t.hash.Write(p[:n])
+	if err == io.EOF {
+		t.sum = hex.EncodeToString(t.hash.Sum(nil))
+		t.trailer = strings.NewReader(t.sum)
+		err = nil
+	}
+	return n, err
+}
+
+// uploadLargeStream reads r in parts of partSize bytes uploaded by c.UploadConcurrency uploads
+// at a time as the large file fileName, cancelling the large file if a part fails since the
+// stream cannot be read again
+func (c *Client) uploadLargeStream(ctx context.Context, bucketID string, fileName string, r io.Reader, partSize int64) (File, error) {
+	var started File
+	reqBody := map[string]interface{}{
+		"bucketId":    bucketID,
+		"fileName":    fileName,
+		"contentType": "b2/x-auto",
+		"fileInfo":    map[string]string{},
+	}
+	if err := c.apiCall(ctx, "b2_start_large_file", reqBody, &started); err != nil {
+		c.Logger.Warn("Invalid response to start large file request",
+			zap.Error(err),
+		)
+		return started, err
+	}
+	concurrency := c.UploadConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	c.Logger.Info("Beginning Large Stream Upload",
+		zap.String("B2 File ID", started.FileID),
+		zap.String("Filename", fileName),
+	)
+
+	uploadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pieces := make(chan B2FilePiece)
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var partErr error
+	fail := func(err error) {
+		errOnce.Do(func() {
+			partErr = err
+			cancel()
+		})
+	}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range pieces {
+				if uploadCtx.Err() != nil {
+					continue
+				}
+				data := p.Data
+				_, _, err := c.uploadPiece(uploadCtx, started.FileID, p, func() io.Reader {
+					return bytes.NewReader(data)
+				})
+				if err != nil {
+					fail(fmt.Errorf("part %d: %w", p.PieceNum+1, err))
+					continue
+				}
+				c.Logger.Debug("Part Uploaded",
+					zap.String("B2 File ID", started.FileID),
+					zap.Int("B2 Part #", p.PieceNum+1),
+					zap.Int64("Size", p.Size),
+				)
+			}
+		}()
+	}
+
+	var partSha1Array []string
+	var size int64
+	for uploadCtx.Err() == nil {
+		data := make([]byte, partSize)
+		n, err := io.ReadFull(r, data)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			fail(err)
+			break
+		}
+		if len(partSha1Array) == 10000 {
+			fail(errors.New("Stream cannot be split into more than 10000 parts"))
+			break
+		}
+		sum := sha1.Sum(data[:n])
+		p := B2FilePiece{PieceNum: len(partSha1Array), Data: data[:n], SHA1: hex.EncodeToString(sum[:]), Size: int64(n)}
+		partSha1Array = append(partSha1Array, p.SHA1)
+		size += int64(n)
+		select {
+		case pieces <- p:
+		case <-uploadCtx.Done():
+		}
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+	close(pieces)
+	wg.Wait()
+	if partErr == nil {
+		partErr = ctx.Err()
+	}
+	if partErr != nil {
+		c.Logger.Warn("Large stream upload failed, cancelling large file",
+			zap.String("B2 File ID", started.FileID),
+			zap.Error(partErr),
+		)
+		// The stream cannot be read again to resume, ctx may already be cancelled
+		c.CancelLargeFileContext(context.Background(), started.FileID)
+		return started, partErr
+	}
+	c.Logger.Info("Large Stream Uploaded",
+		zap.String("B2 File ID", started.FileID),
+		zap.Int64("Size", size),
+		zap.Int("Parts", len(partSha1Array)),
+	)
+	return c.finishLargeFile(ctx, started.FileID, partSha1Array)
+}
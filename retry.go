@@ -0,0 +1,172 @@
+package gopherb2
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/uber-go/zap"
+)
+
+// RetryPolicy controls how failed requests are retried, following the B2 integration checklist:
+// transport errors, 408, 429 and 5xx responses are retried with exponential backoff and jitter,
+// waiting at least as long as the Retry-After header of the response asks for.
+type RetryPolicy struct {
+	// MaxRetries is the number of times a request is retried after the first attempt, 0 disables retries
+	MaxRetries int
+	// InitialBackoff is the delay before the first retry, doubled for every further retry
+	InitialBackoff time.Duration
+	// MaxBackoff limits the delay between retries
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is used by clients returned from NewClient
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries:     5,
+	InitialBackoff: time.Second,
+	MaxBackoff:     64 * time.Second,
+}
+
+// uploadTarget is an upload URL and the authorization token valid for it
+type uploadTarget struct {
+	URL                string
+	AuthorizationToken string
+}
+
+// backoff returns the delay before retry number retry, starting at 1, or the Retry-After
+// delay of the failed response when that is longer.
+func (p RetryPolicy) backoff(retry int, header http.Header) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < retry && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	// Jitter between half and the full delay so concurrent uploads do not retry in lockstep
+	if delay > 1 {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	}
+	if seconds, err := strconv.Atoi(header.Get("Retry-After")); err == nil {
+		if retryAfter := time.Duration(seconds) * time.Second; retryAfter > delay {
+			delay = retryAfter
+		}
+	}
+	return delay
+}
+
+// retryable reports whether a request that failed with err may succeed when sent again
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var capErr *CapabilityError
+	var writeErr *downloadWriteError
+	if errors.As(err, &capErr) || errors.As(err, &writeErr) {
+		return false
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		// Connection resets, timeouts and other transport errors, but no local failures such as hash
+		// mismatches or responses that cannot be decoded, which fail the same way when sent again
+		var netErr net.Error
+		return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+	}
+	switch apiErr.Status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return apiErr.Status >= 500
+}
+
+// retry calls attempt until it succeeds, fails with an error that is not retryable or the retry
+// policy is used up, and returns the last response together with the number of retries made. No further
+// attempts are made once ctx is done, returning the error of ctx.
+func (c *Client) retry(ctx context.Context, operation string, attempt func() (Response, error)) (Response, int, error) {
+	for retries := 0; ; retries++ {
+		if err := ctx.Err(); err != nil {
+			return Response{}, retries, err
+		}
+		apiResponse, err := attempt()
+		if err == nil || !retryable(err) || retries >= c.Retry.MaxRetries {
+			if err != nil && retries > 0 {
+				c.Logger.Warn("Giving up on B2 request",
+					zap.String("Operation", operation),
+					zap.Int("Retries", retries),
+					zap.Error(err),
+				)
+			}
+			return apiResponse, retries, err
+		}
+		delay := c.Retry.backoff(retries+1, apiResponse.Header)
+		c.Logger.Warn("Retrying B2 request",
+			zap.String("Operation", operation),
+			zap.Int("Retry", retries+1),
+			zap.Duration("Delay", delay),
+			zap.Error(err),
+		)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return apiResponse, retries, ctx.Err()
+		}
+	}
+}
+
+// uploadWithRetry posts the body returned by newBody to target. B2 requires a new upload URL after
+// any failed upload, so getTarget is called for a fresh URL and token before every retry, and before
+// the first attempt if target is empty.
+func (c *Client) uploadWithRetry(ctx context.Context, operation string, target uploadTarget, getTarget func() (uploadTarget, error), header http.Header, size int64, newBody func() (io.Reader, error)) (Response, int, error) {
+	// Uploads send the file name as header, parts have none
+	if fileName, err := url.PathUnescape(header.Get("X-Bz-File-Name")); err == nil && fileName != "" {
+		if err := c.checkFileName(operation, "", fileName); err != nil {
+			return Response{}, 0, err
+		}
+	}
+	var bodyErr error
+	apiResponse, retries, err := c.retry(ctx, operation, func() (Response, error) {
+		if target.URL == "" {
+			var err error
+			if target, err = getTarget(); err != nil {
+				return Response{}, err
+			}
+		}
+		body, err := newBody()
+		if err != nil {
+			bodyErr = err
+			return Response{}, nil
+		}
+		// The caller owns the body and may need it for retries, so do not let the transport close it
+		req, err := http.NewRequestWithContext(ctx, "POST", target.URL, ioutil.NopCloser(body))
+		if err != nil {
+			bodyErr = err
+			return Response{}, nil
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		req.ContentLength = size
+		req.Header.Set("Authorization", target.AuthorizationToken)
+
+		apiResponse, err := c.do(req)
+		if err != nil {
+			// Do not reuse the upload URL of a failed upload
+			target = uploadTarget{}
+		}
+		return apiResponse, err
+	})
+	if bodyErr != nil {
+		return apiResponse, retries, bodyErr
+	}
+	return apiResponse, retries, err
+}
@@ -0,0 +1,107 @@
+package gopherb2
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/uber-go/zap"
+)
+
+// MaxDownloadAuthorizationDuration is the longest time a download authorization can be valid for
+const MaxDownloadAuthorizationDuration = 7 * 24 * time.Hour
+
+// DownloadAuthorization allows downloading files by name from a private bucket without the
+// credentials of the account, for files with names starting with FileNamePrefix
+type DownloadAuthorization struct {
+	BucketID           string `json:"bucketId"`
+	FileNamePrefix     string `json:"fileNamePrefix"`
+	AuthorizationToken string `json:"authorizationToken"`
+	// ContentDisposition is the Content-Disposition override downloads with the token must request
+	ContentDisposition string `json:"-"`
+}
+
+// DownloadAuthorizationOptions changes the downloads a download authorization allows
+type DownloadAuthorizationOptions struct {
+	// ValidDuration is how long the authorization is valid, from a second up to
+	// MaxDownloadAuthorizationDuration
+	ValidDuration time.Duration
+	// ContentDisposition overrides the Content-Disposition header of the downloads, such as
+	// attachment; filename="build.zip"
+	ContentDisposition string
+}
+
+// GetDownloadAuthorization returns a token for downloading the files of bucket with names starting
+// with fileNamePrefix, an empty prefix allows all files of the bucket
+func (c *Client) GetDownloadAuthorization(bucket string, fileNamePrefix string, opts DownloadAuthorizationOptions) (DownloadAuthorization, error) {
+	return c.GetDownloadAuthorizationContext(context.Background(), bucket, fileNamePrefix, opts)
+}
+
+// GetDownloadAuthorizationContext is like GetDownloadAuthorization with a context limiting the request
+func (c *Client) GetDownloadAuthorizationContext(ctx context.Context, bucket string, fileNamePrefix string, opts DownloadAuthorizationOptions) (DownloadAuthorization, error) {
+	var downloadAuth DownloadAuthorization
+	if opts.ValidDuration < time.Second || opts.ValidDuration > MaxDownloadAuthorizationDuration {
+		return downloadAuth, errors.New("Download authorization must be valid for between one second and one week")
+	}
+	bucketID, err := c.ResolveBucketContext(ctx, bucket)
+	if err != nil {
+		return downloadAuth, err
+	}
+	reqBody := map[string]interface{}{
+		"bucketId":               bucketID,
+		"fileNamePrefix":         fileNamePrefix,
+		"validDurationInSeconds": int64(opts.ValidDuration / time.Second),
+	}
+	if opts.ContentDisposition != "" {
+		reqBody["b2ContentDisposition"] = opts.ContentDisposition
+	}
+	err = c.apiCall(ctx, "b2_get_download_authorization", reqBody, &downloadAuth)
+	if err != nil {
+		c.Logger.Warn("Could not get download authorization",
+			zap.String("Bucket ID", bucketID),
+			zap.String("Prefix", fileNamePrefix),
+			zap.Error(err),
+		)
+		return downloadAuth, err
+	}
+	downloadAuth.ContentDisposition = opts.ContentDisposition
+	c.Logger.Info("Download authorization created",
+		zap.String("Bucket ID", bucketID),
+		zap.String("Prefix", fileNamePrefix),
+		zap.Duration("Valid", opts.ValidDuration),
+	)
+	return downloadAuth, nil
+}
+
+// ShareURL returns a URL anyone can use to download fileName from bucket until opts.ValidDuration
+// has passed, even if the bucket is private. The URL contains a download authorization for
+// fileName as a prefix, B2 authorizes by prefix only, so its token also downloads every file with a
+// name starting with fileName, such as fileName+".bak" or fileName+"/other".
+func (c *Client) ShareURL(bucket string, fileName string, opts DownloadAuthorizationOptions) (string, error) {
+	return c.ShareURLContext(context.Background(), bucket, fileName, opts)
+}
+
+// ShareURLContext is like ShareURL with a context limiting the requests
+func (c *Client) ShareURLContext(ctx context.Context, bucket string, fileName string, opts DownloadAuthorizationOptions) (string, error) {
+	downloadAuth, err := c.GetDownloadAuthorizationContext(ctx, bucket, fileName, opts)
+	if err != nil {
+		return "", err
+	}
+	bucketName, err := c.bucketName(ctx, downloadAuth.BucketID)
+	if err != nil {
+		return "", err
+	}
+	return c.DownloadURL(bucketName, fileName, downloadAuth), nil
+}
+
+// DownloadURL returns the download by name URL of fileName in bucketName, including the token and
+// content disposition override of downloadAuth
+func (c *Client) DownloadURL(bucketName string, fileName string, downloadAuth DownloadAuthorization) string {
+	query := url.Values{}
+	query.Set("Authorization", downloadAuth.AuthorizationToken)
+	if downloadAuth.ContentDisposition != "" {
+		query.Set("b2ContentDisposition", downloadAuth.ContentDisposition)
+	}
+	return downloadByNameURL(c.Authorization(), bucketName, fileName) + "?" + query.Encode()
+}
@@ -1,24 +1,21 @@
 package gopherb2
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"math"
 	"net/http"
-	"net/http/httputil"
 	"os"
 	"time"
 
 	pb "gopkg.in/cheggaaa/pb.v1"
 
-	"log"
-
 	blake2b "github.com/dsjr2006/blake2b-simd"
+	"github.com/uber-go/zap"
 )
 
 type UpToB2File struct {
@@ -30,20 +27,16 @@ type UpToB2File struct {
 	TotalSize     int64
 	Blake2b       string
 	SHA1          string
+	Retries       int           // Number of retries of standard upload or of all pieces
 	Piece         []B2FilePiece // For B2 Large File - First Piece [0] will have Size/Hashes/Status
 }
 type B2FilePiece struct {
-<<<<<<< HEAD
-	SHA1   string
-	Size   int64
-	Status string
-=======
 	PieceNum int
 	Data     []byte
 	SHA1     string
 	Size     int64
 	Status   string
->>>>>>> Development
+	Retries  int
 }
 
 // Pointer to buffer?
@@ -76,7 +69,6 @@ func NewB2File(path string) (UpToB2File, error) {
 
 	// calculate total number of parts the file will be chunked into
 	totalPartsNum := uint64(math.Ceil(float64(b2F.TotalSize) / float64(fileChunk)))
-	fmt.Printf("\nTotal Parts Num: %v", totalPartsNum)
 	if totalPartsNum > 10000 {
 		//TODO increase chunk size if too many parts, will fail at too many pieces because of file size 1TB?
 	}
@@ -89,16 +81,20 @@ func NewB2File(path string) (UpToB2File, error) {
 		} else {
 			pieceSize = totalSize
 		}
-		fmt.Printf("\nPiece size: %v", pieceSize)
 
 		piece := B2FilePiece{
-			Status: "Unprocessed",
-			Size:   pieceSize,
+			PieceNum: i,
+			Status:   "Unprocessed",
+			Size:     pieceSize,
 		}
 		totalSize -= b2F.PieceSize
-		fmt.Printf("\nUpdating Status of Piece# %v", i+1)
 		b2F.Piece = append(b2F.Piece, piece)
 	}
+	logger.Debug("File split into pieces",
+		zap.String("Filepath", b2F.Filepath),
+		zap.Int("Pieces", len(b2F.Piece)),
+		zap.Int64("Piece Size", b2F.PieceSize),
+	)
 	err = b2F.Process()
 	if err != nil {
 		return b2F, err
@@ -107,36 +103,54 @@ func NewB2File(path string) (UpToB2File, error) {
 	return b2F, nil
 }
 
-// Process runs functions to get necessary file hashes, currently run at end of NewB2File
+// Process hashes the file and each of its pieces while reading the file once, it is run at end
+// of NewB2File
 func (b2F *UpToB2File) Process() error {
-	err := b2F.getPieceSHA1s()
-	if err != nil {
-		//TODO:Handle error
-		return err
-	}
-	err = b2F.getSHA1()
+	file, err := os.Open(b2F.Filepath)
 	if err != nil {
-		//TODO: Handle error
 		return err
 	}
-	err = b2F.getBlakeb2()
-	if err != nil {
-		//TODO:Handle error
-		return err
+	defer file.Close()
+	whole := sha1.New()
+	blake := blake2b.New512()
+	for i := range b2F.Piece {
+		part := sha1.New()
+		piece := io.NewSectionReader(file, int64(i)*b2F.PieceSize, b2F.Piece[i].Size)
+		if _, err := io.Copy(io.MultiWriter(whole, blake, part), piece); err != nil {
+			return err
+		}
+		b2F.Piece[i].SHA1 = hex.EncodeToString(part.Sum(nil))
 	}
-	fmt.Printf("\nTotal Size: %v", b2F.getTotalSize())
+	b2F.SHA1 = hex.EncodeToString(whole.Sum(nil))
+	// Full 64 byte hash, like UploadFile stores
+	b2F.Blake2b = hex.EncodeToString(blake.Sum(nil))
+	logger.Debug("File hashed",
+		zap.String("Filepath", b2F.Filepath),
+		zap.Int64("Total Size", b2F.getTotalSize()),
+	)
 	return nil
 }
 
-// Upload transmits file(s) to Backblaze B2
-func (b2F *UpToB2File) Upload(bucketID string) error {
+// Upload transmits file(s) to Backblaze B2 using client c
+func (b2F *UpToB2File) Upload(c *Client, bucketID string) error {
+	return b2F.UploadContext(context.Background(), c, bucketID)
+}
+
+// UploadContext is like Upload. Cancelling ctx stops all pieces in flight, the Status of every
+// piece then tells whether it finished, and a *LargeFileError lists the finished part numbers.
+func (b2F *UpToB2File) UploadContext(ctx context.Context, c *Client, bucketID string) error {
+	bucketID, err := c.ResolveBucketContext(ctx, bucketID)
+	if err != nil {
+		return err
+	}
 	// Standard Upload if one piece
 	if len(b2F.Piece) == 1 {
-		fmt.Println("Starting Standard upload")
-		uploadURL := B2GetUploadURL(bucketID)
+		c.Logger.Debug("Starting Standard upload",
+			zap.String("Filepath", b2F.Filepath),
+		)
 		file, err := os.Open(b2F.Filepath)
 		if err != nil {
-			//TODO: handle error
+			return err
 		}
 		defer file.Close()
 		// Create and Start Progress Bar
@@ -146,182 +160,106 @@ func (b2F *UpToB2File) Upload(bucketID string) error {
 		pbar.ShowTimeLeft = true
 		pbar.Start()
 		// Create and Send Request
-		client := &http.Client{}
-		req, err := http.NewRequest("POST", uploadURL.URL, pbar.NewProxyReader(file))
-		req.ContentLength = b2F.TotalSize
-		req.Header.Add("Authorization", uploadURL.AuthorizationToken)
-		req.Header.Add("Content-Type", "b2/x-auto")
-		req.Header.Add("X-Bz-Content-Sha1", b2F.SHA1)
-		req.Header.Add("X-Bz-File-Name", b2F.Filename)
-		req.Header.Add("X-Bz-Info-src_last_modified_millis", fmt.Sprintf("%d", b2F.LastModMillis))
-		req.Header.Add("X-Bz-Info-Content-Blake2b", b2F.Blake2b)
+		header := http.Header{}
+		header.Add("Content-Type", "b2/x-auto")
+		header.Add("X-Bz-Content-Sha1", b2F.SHA1)
+		header.Add("X-Bz-File-Name", b2F.Filename)
+		header.Add("X-Bz-Info-src_last_modified_millis", fmt.Sprintf("%d", b2F.LastModMillis))
+		header.Add("X-Bz-Info-Content-Blake2b", b2F.Blake2b)
+
+		apiResponse, retries, err := c.uploadWithRetry(ctx, "b2_upload_file", uploadTarget{}, c.uploadURLTarget(ctx, bucketID), header, b2F.TotalSize, func() (io.Reader, error) {
+			pbar.Set(0)
+			_, err := file.Seek(0, io.SeekStart)
+			return pbar.NewProxyReader(file), err
+		})
+		pbar.Finish()
+		b2F.Retries = retries
 		if err != nil {
-			log.Fatalf("\nRequest failed. Error: %v", err)
+			return err
 		}
 
-		resp, err := client.Do(req)
+		// Check API Response
+		var uploaded File
+		err = json.Unmarshal(apiResponse.Body, &uploaded)
 		if err != nil {
-			log.Fatalf("\nResponse read fail. Error: %v", err)
+			return err
 		}
-		pbar.Finish()
-		// Read Response Body
-		respBody, _ := ioutil.ReadAll(resp.Body)
-
-		// Check API Response
-		if resp.Status == "200 OK" {
-			var uploaded UploadedFile
-			err = json.Unmarshal(respBody, &uploaded)
-
-			if uploaded.ContentSha1 != b2F.SHA1 {
-				log.Fatal("API Response SHA1 Hash Mismatch.")
-			}
-
-			fmt.Printf("\nUpload Complete \nFilename: %v \nFileID: %v\n", uploaded.FileName, uploaded.FileID)
-
-			return nil
+		if uploaded.ContentSha1 != b2F.SHA1 {
+			return ErrSHA1Mismatch
 		}
-		if resp.Status != "200 OK" {
-			requestDump, err := httputil.DumpRequest(req, true)
-			if err != nil {
-				log.Fatal("Could not dump HTTP request")
-			}
-			fmt.Printf("\nRequest: %v\n", string(requestDump))
-
-			responseDump, err := httputil.DumpResponse(resp, true)
-			if err != nil {
-				log.Fatal("Could not dump HTTP response")
-			}
-			fmt.Printf("\nResponse: %v\n", string(responseDump))
-
-			return errors.New("could not complete upload, please see log and retry")
+		b2F.FileID = uploaded.FileID
 
+		c.Logger.Debug("Upload Complete",
+			zap.String("Filename", uploaded.FileName),
+			zap.String("File ID", uploaded.FileID),
+		)
+		return nil
+	}
+	// Multi-part Upload if greather than one piece, reading every part straight from the file
+	c.Logger.Debug("Starting multi-part upload",
+		zap.String("Filepath", b2F.Filepath),
+	)
+	// Continue an interrupted upload of the same file instead of starting over
+	if finished, found, err := c.resumeIfUnfinished(ctx, bucketID, b2F.Filepath, b2F.SHA1); found {
+		if err == nil {
+			b2F.FileID = finished.FileID
 		}
+		return err
 	}
-	// Multi-part Upload if greather than one piece
-
-<<<<<<< HEAD
-=======
-	// TODO: Multi-part upload simulataneous without creating temp files, need to evaluate performance
-	// impact of reading multiple part from same file concurrently rather than concurrently reading from
-	// seperate files. Brief web searches seem to suggest reading multiple segments of same file in parallels
-	// will degrade IO performance, suggest reading from temp files. This will require user to have as much
-	// space as large file uses available for creating temp files. Minimize by only creating chunks as it goes
-	// and delete as uploads confirmed?
-
-	fmt.Println("Starting multi-part upload")
 	file, err := os.Open(b2F.Filepath)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 	// Send start request to API and check response
-	b2StartLgFile, err := b2F.startB2LargeFile(bucketID)
+	b2StartLgFile, err := b2F.startB2LargeFile(ctx, c, bucketID)
 	if err != nil {
-		log.Fatal("Start large file failed", err)
+		return err
 	}
-	fmt.Printf("Start large file:\n%v", b2StartLgFile) // TODO: Remove this
 	b2F.FileID = b2StartLgFile.FileID
-	// create progress bar pool
-	pbpool, err := pb.StartPool()
+	err = c.uploadMissingPieces(ctx, b2F.FileID, file, b2F.PieceSize, b2F.Piece, nil)
+	for i := range b2F.Piece {
+		b2F.Retries += b2F.Piece[i].Retries
+	}
 	if err != nil {
-		logger.Fatal("Could not start Progress Bar pool")
+		return err
 	}
-	// create task channel
-	filePieces := make(chan B2FilePiece)
-	go func() {
-		for i := 0; i < len(b2F.Piece); i++ {
-			filePieces <- b2F.Piece[i]
-			// Create byte array and fill buffer from file
-			part := make([]byte, b2F.Piece[i].Size)
-			_, err := file.Read(part)
-			if err != nil {
-				log.Fatal("Could not read file into buffer for multi-part upload")
-			}
-			fmt.Printf("read to part %v", len(part))
-			b2F.Piece[i].Data = append(b2F.Piece[i].Data, part...)
-		}
-		close(filePieces)
-	}()
-
-	// waitgroup, and close results channel when done
-	results := make(chan string)
-	var wg sync.WaitGroup
-	wg.Add(UploadConcurrency)
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-
-	for i := 0; i < UploadConcurrency; i++ {
-		go func(id int) {
-			defer wg.Done()
-
-			for p := range filePieces {
-
-				// Upload Temp Pieces here
-
-				// Progress Bar
-				pbar := pb.New64(p.Size).SetUnits(pb.U_BYTES)
-				pbar.SetRefreshRate(time.Second)
-				pbar.Prefix(fmt.Sprintf("Part %v of %v", p.PieceNum+1, len(b2F.Piece)))
-				pbar.ShowSpeed = true
-				pbar.ShowTimeLeft = true
-				pbpool.Add(pbar)
 
-				// Get Upload Part URL & AuthorizationToken
-				uploadPtResp := B2GetUploadPartURL(b2F.FileID)
-				// Attempt Upload
-				// Create client, body, & request
-				client := &http.Client{}
-				buf := new(bytes.Buffer)
-				buf.Write(p.Data)
-				fmt.Printf("p.Data size: %v", len(p.Data))
-				body := pbar.NewProxyReader(buf)
-				req, err := http.NewRequest("POST", uploadPtResp.UploadURL, body)
-				if err != nil {
-					log.Fatal("Error creating multi-part upload request")
-				}
-				// Headers
-				req.ContentLength = p.Size
-				req.Header.Add("X-Bz-Part-Number", fmt.Sprintf("%d", (p.PieceNum+1))) // Temp files begin at 0, increase by 1 to match B2 response
-				req.Header.Add("Authorization", uploadPtResp.AuthorizationToken)
-				req.Header.Add("X-Bz-Content-Sha1", p.SHA1)
-
-				// Fetch Request
-				pbar.Start()
-				resp, err := client.Do(req)
-				if err != nil {
-					fmt.Println("Failure : ", err)
-				}
-				// Read Response Body
-				pbar.Finish()
-				respBody, _ := ioutil.ReadAll(resp.Body)
-				var apiResponse Response
-				apiResponse.Header = resp.Header
-				apiResponse.Status = resp.Status
-				apiResponse.Body = respBody
-				if apiResponse.Status == "200 OK" {
-					p.Status = "Success"
-				}
-				if apiResponse.Status != "200 OK" {
-					p.Status = "Failed"
-				}
-
-				fmt.Printf("Thread #%v Piece ID: %v Size: %v SHA1: %v\n", id, p.PieceNum, p.Size, p.SHA1)
-
-				results <- "done"
-			}
-		}(i)
+	partSha1Array := make([]string, len(b2F.Piece))
+	for i := range b2F.Piece {
+		partSha1Array[i] = b2F.Piece[i].SHA1
 	}
+	_, err = c.finishLargeFile(ctx, b2F.FileID, partSha1Array)
+	return err
+}
 
-	// loop over results until closed (see above)
-	for r := range results {
-		fmt.Printf("%v\n", r)
+// uploadPiece sends the data of piece p as a part of large file fileID, using a new
+// upload part URL for every attempt. A piece without a SHA1 is hashed while it is sent, with
+// the SHA1 following the data. It returns the SHA1 of the part and the number of retries made.
+func (c *Client) uploadPiece(ctx context.Context, fileID string, p B2FilePiece, newBody func() io.Reader) (string, int, error) {
+	// Headers
+	header := http.Header{}
+	header.Add("X-Bz-Part-Number", fmt.Sprintf("%d", (p.PieceNum+1))) // Pieces begin at 0, increase by 1 to match B2 part numbers
+	size := p.Size
+	if p.SHA1 == "" {
+		header.Add("X-Bz-Content-Sha1", "hex_digits_at_end")
+		size += sha1.Size * 2
+	} else {
+		header.Add("X-Bz-Content-Sha1", p.SHA1)
 	}
-	pbpool.Stop()
 
->>>>>>> Development
-	return nil
+	var trailer *sha1Trailer
+	_, retries, err := c.uploadWithRetry(ctx, "b2_upload_part", uploadTarget{}, c.uploadPartURLTarget(ctx, fileID), header, size, func() (io.Reader, error) {
+		if p.SHA1 != "" {
+			return newBody(), nil
+		}
+		trailer = &sha1Trailer{r: newBody(), hash: sha1.New()}
+		return trailer, nil
+	})
+	if err != nil || p.SHA1 != "" {
+		return p.SHA1, retries, err
+	}
+	return trailer.sum, retries, nil
 }
 func (b2F *UpToB2File) getTotalSize() int64 {
 	var tSz int64
@@ -335,118 +273,20 @@ func (b2F *UpToB2File) getTotalSize() int64 {
 	b2F.TotalSize = tSz
 	return tSz
 }
-func (b2F *UpToB2File) getPieceSHA1s() error {
-	file, err := os.Open(b2F.Filepath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	for i := 0; i < len(b2F.Piece); i++ {
-		// Create buffer and fill buffer from file
-		partBuffer := make([]byte, b2F.Piece[i].Size)
-		_, err := file.Read(partBuffer)
-		if err != nil {
-			return err
-		}
-
-		hash := sha1.New()
-		_, err = hash.Write(partBuffer)
-		if err != nil {
-			return err
-		}
-		// Get 20 bytes hash
-		hashAsBytes := hash.Sum(nil)[:20]
-		b2F.Piece[i].SHA1 = hex.EncodeToString(hashAsBytes)
-	}
-
-	return nil
-}
-func (b2F *UpToB2File) getSHA1() error {
-	file, err := os.Open(b2F.Filepath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	// Create buffer and fill buffer from file
-	partBuffer := make([]byte, b2F.TotalSize)
-	ptSz, err := file.Read(partBuffer)
-	if err != nil {
-		return err
-	}
-	if int64(ptSz) != b2F.TotalSize {
-		return errors.New("File Size read into buffer does not match file total size")
-	}
-
-	hash := sha1.New()
-	_, err = hash.Write(partBuffer)
-	if err != nil {
-		return err
-	}
-	// Get 20 bytes hash
-	hashAsBytes := hash.Sum(nil)[:20]
-	b2F.SHA1 = hex.EncodeToString(hashAsBytes)
-
-	return nil
-}
-func (b2F *UpToB2File) getBlakeb2() error {
-	file, err := os.Open(b2F.Filepath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	partBuffer := make([]byte, b2F.TotalSize)
-	file.Read(partBuffer)
-	hash := blake2b.New512()
-	_, err = hash.Write(partBuffer)
-	if err != nil {
-		return err
-	}
-	//io.WriteString(hash, string(partBuffer))
-	// 32 byte hash
-	hashAsBytes := hash.Sum(nil)[:32]
-	b2F.Blake2b = hex.EncodeToString(hashAsBytes)
-
-	return nil
-}
-func (b2F *UpToB2File) startB2LargeFile(bucketID string) (B2File, error) {
-	// Authorize
-	apiAuth := AuthorizeAcct()
-
-	// Create client
-	client := &http.Client{}
+func (b2F *UpToB2File) startB2LargeFile(ctx context.Context, c *Client, bucketID string) (File, error) {
+	var b2File File
 	// Request Body : JSON object
-	jsonBody := []byte(`{"fileInfo": {"large_file_sha1": "` + b2F.SHA1 + `","src_last_modified_millis": "` + fmt.Sprintf("%d", b2F.LastModMillis) + `"},"bucketId": "` + bucketID + `","fileName": "` + b2F.Filename + `","contentType": "b2/x-auto"}`)
-	body := bytes.NewBuffer(jsonBody)
-
-	// Create request
-	req, err := http.NewRequest("POST", "https://api001.backblazeb2.com/b2api/v1/b2_start_large_file", body)
-
-	// Headers
-	req.Header.Add("Authorization", apiAuth.AuthorizationToken)
-
-	// Fetch Request
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Println("Failure : ", err)
+	reqBody := map[string]interface{}{
+		"fileInfo": map[string]string{
+			"large_file_sha1":          b2F.SHA1,
+			"src_last_modified_millis": fmt.Sprintf("%d", b2F.LastModMillis),
+		},
+		"bucketId":    bucketID,
+		"fileName":    b2F.Filename,
+		"contentType": "b2/x-auto",
 	}
 
-	// Read Response Body
-	respBody, _ := ioutil.ReadAll(resp.Body)
-	defer resp.Body.Close()
-	var apiResponse Response
-	apiResponse = Response{Header: resp.Header, Status: resp.Status, Body: respBody}
-
-	// Parse API Response File Info to B2File if request is successful
-	var b2File B2File
-	if apiResponse.Status == "200 OK" {
-		err = json.Unmarshal(apiResponse.Body, &b2File)
-		if err != nil {
-			log.Fatalf("File JSON parse failed. Error: %v", err)
-		}
-		return b2File, nil
-	}
+	// Parse API Response File Info to File if request is successful
+	err := c.apiCall(ctx, "b2_start_large_file", reqBody, &b2File)
 	return b2File, err
 }
@@ -1,17 +1,15 @@
 package gopherb2
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
-	"net/http/httputil"
 	"os"
-	"sync"
 	"time"
 
-	log "github.com/Sirupsen/logrus"
 	"github.com/uber-go/zap"
 	pb "gopkg.in/cheggaaa/pb.v1"
 )
@@ -37,69 +35,55 @@ type TempPiece struct {
 	AuthorizationToken string
 	FileID             string
 	UploadStatus       string
+	Retries            int
 }
 type UploadURL struct {
 	AuthorizationToken string `json:"authorizationToken"`
 	BucketId           string `json:"bucketId"`
 	URL                string `json:"uploadUrl"`
 }
-type UploadedFile struct {
-	AccountID     string `json:"accountId"`
-	Action        string `json:"action"`
-	BucketID      string `json:"bucketId"`
-	ContentLength int    `json:"contentLength"`
-	ContentSha1   string `json:"contentSha1"`
-	ContentType   string `json:"contentType"`
-	FileID        string `json:"fileId"`
-	FileInfo      struct {
-		ContentBlake2B        string `json:"content-blake2b"`
-		SrcLastModifiedMillis string `json:"src_last_modified_millis"`
-	} `json:"fileInfo"`
-	FileName        string `json:"fileName"`
-	UploadTimestamp int64  `json:"uploadTimestamp"`
+
+// UploadFile transmits file at given path to B2 Storage and returns the uploaded file
+func (c *Client) UploadFile(bucketID string, filePath string) (File, error) {
+	return c.UploadFileContext(context.Background(), bucketID, filePath)
 }
 
-// UploadFile transmits file at given path to B2 Storage
-func UploadFile(bucketID string, filePath string) error {
+// UploadFileContext is like UploadFile, cancelling ctx stops the upload
+func (c *Client) UploadFileContext(ctx context.Context, bucketID string, filePath string) (File, error) {
+	bucketID, err := c.ResolveBucketContext(ctx, bucketID)
+	if err != nil {
+		return File{}, err
+	}
 	// Determine Upload Method
 	file, err := os.Stat(filePath)
-
-	// defer file.Close()
 	if err != nil {
-		logger.Fatal("Unable to get file stats.",
+		c.Logger.Warn("Unable to get file stats.",
 			zap.Error(err),
 		)
-		log.Fatalf("Unable to get file stats. Error: %v", err)
+		return File{}, err
 	}
 
 	if file.Size() < 120586240 {
-		log.Debug("Sending file to Standard upload.")
-		b2UploadStdFile(bucketID, filePath)
-	} else {
-		log.Debug("Sending file to Large upload")
-		LargeFileUpload(bucketID, filePath)
+		c.Logger.Debug("Sending file to Standard upload.")
+		uploaded, _, err := c.uploadStdFile(ctx, bucketID, filePath)
+		return uploaded, err
 	}
-
-	return err
+	c.Logger.Debug("Sending file to Large upload")
+	return c.LargeFileUploadContext(ctx, bucketID, filePath)
 }
-func b2UploadStdFile(bucketID string, filePath string) {
-	// Authorize and Get Upload URL
-	uploadURL := B2GetUploadURL(bucketID)
 
+// uploadStdFile uploads filePath with a single request, returning the uploaded file and the number
+// of times the upload was retried
+func (c *Client) uploadStdFile(ctx context.Context, bucketID string, filePath string) (File, int, error) {
+	var uploaded File
 	file, err := os.Open(filePath)
 	if err != nil {
-		logger.Fatal("Error opening file for upload",
-			zap.String("File", filePath),
-			zap.Error(err),
-		)
+		return uploaded, 0, err
 	}
 	defer file.Close()
 	fileInfo, err := file.Stat()
 	if err != nil {
-		logger.Fatal("Error getting file stats",
-			zap.String("File", filePath),
-			zap.Error(err),
-		)
+		return uploaded, 0, err
 	}
 
 	// Get File Modification Time as int64 value in milliseconds since midnight, January 1, 1970 UTC
@@ -107,8 +91,14 @@ func b2UploadStdFile(bucketID string, filePath string) {
 
 	// Get File Hash
 	fsha1, err := fileSHA1(filePath)
+	if err != nil {
+		return uploaded, 0, err
+	}
 	fileBlake2b, err := fileBlake2b(filePath)
-	logger.Debug("File Hashing Complete.",
+	if err != nil {
+		return uploaded, 0, err
+	}
+	c.Logger.Debug("File Hashing Complete.",
 		zap.String("Filename", fileInfo.Name()),
 		zap.String("SHA1", fsha1),
 		zap.String("Blake2b", fileBlake2b),
@@ -121,277 +111,213 @@ func b2UploadStdFile(bucketID string, filePath string) {
 	pbar.ShowTimeLeft = true
 	pbar.Start()
 
-	// Create and Send Request
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", uploadURL.URL, pbar.NewProxyReader(file))
-	req.ContentLength = fileInfo.Size()
-	req.Header.Add("Authorization", uploadURL.AuthorizationToken)
-	req.Header.Add("Content-Type", "b2/x-auto")
-	req.Header.Add("X-Bz-Content-Sha1", fsha1)
-	req.Header.Add("X-Bz-File-Name", fileInfo.Name())
-	req.Header.Add("X-Bz-Info-src_last_modified_millis", fmt.Sprintf("%d", fileModTimeMillis))
-	req.Header.Add("X-Bz-Info-Content-Blake2b", fileBlake2b)
+	// Headers
+	header := http.Header{}
+	header.Add("Content-Type", "b2/x-auto")
+	header.Add("X-Bz-Content-Sha1", fsha1)
+	header.Add("X-Bz-File-Name", fileInfo.Name())
+	header.Add("X-Bz-Info-src_last_modified_millis", fmt.Sprintf("%d", fileModTimeMillis))
+	header.Add("X-Bz-Info-Content-Blake2b", fileBlake2b)
+
+	// Send Request, rewinding the file for every retry
+	apiResponse, retries, err := c.uploadWithRetry(ctx, "b2_upload_file", uploadTarget{}, c.uploadURLTarget(ctx, bucketID), header, fileInfo.Size(), func() (io.Reader, error) {
+		pbar.Set(0)
+		_, err := file.Seek(0, io.SeekStart)
+		return pbar.NewProxyReader(file), err
+	})
+	pbar.Finish()
 	if err != nil {
-		logger.Fatal("Error creating upload request",
+		c.Logger.Warn("Could not upload file",
+			zap.String("File", filePath),
+			zap.Int("Retries", retries),
 			zap.Error(err),
 		)
+		return uploaded, retries, err
 	}
 
-	resp, err := client.Do(req)
+	// Check API Response
+	err = json.Unmarshal(apiResponse.Body, &uploaded)
 	if err != nil {
-		logger.Fatal("API Upload Request Error",
-			zap.String("File", filePath),
-			zap.Error(err),
+		return uploaded, retries, err
+	}
+	if uploaded.ContentSha1 != fsha1 {
+		c.Logger.Warn("API Response SHA1 Hash Mismatch.",
+			zap.String("Local SHA1", fsha1),
+			zap.String("API SHA1", uploaded.ContentSha1),
 		)
+		return uploaded, retries, ErrSHA1Mismatch
 	}
-	pbar.Finish()
 
-	// Read Response Body
-	respBody, _ := ioutil.ReadAll(resp.Body)
-
-	// Check API Response
-	if resp.Status == "200 OK" {
-		var uploaded UploadedFile
-		err = json.Unmarshal(respBody, &uploaded)
+	c.Logger.Info("File Uploaded",
+		zap.String("Filename", uploaded.FileName),
+		zap.String("File ID", uploaded.FileID),
+		zap.Int("Retries", retries),
+	)
+	return uploaded, retries, nil
+}
 
-		if uploaded.ContentSha1 != fsha1 {
-			logger.Fatal("API Response SHA1 Hash Mismatch.",
-				zap.String("Local SHA1", fsha1),
-				zap.String("API SHA1", uploaded.ContentSha1),
-			)
-		}
+// LargeFileUpload transmits the file at given path to B2 Storage as a multipart large file and
+// returns the finished file
+func (c *Client) LargeFileUpload(bucketID string, filePath string) (File, error) {
+	return c.LargeFileUploadContext(context.Background(), bucketID, filePath)
+}
 
-		fmt.Printf("\nUpload Complete \nFilename: %v \nFileID: %v\n", uploaded.FileName, uploaded.FileID)
-	} else {
-		requestDump, err := httputil.DumpRequest(req, true)
-		if err != nil {
-			logger.Warn("Could not dump HTTP request",
-				zap.Error(err),
-			)
+// LargeFileUploadContext is like LargeFileUpload. An unfinished large file left by an interrupted
+// upload of the same file is resumed instead of starting a new one, using the progress saved at
+// c.StatePath if set. Cancelling ctx stops all parts in flight and returns a *LargeFileError
+// reporting which parts finished.
+func (c *Client) LargeFileUploadContext(ctx context.Context, bucketID string, filePath string) (File, error) {
+	bucketID, err := c.ResolveBucketContext(ctx, bucketID)
+	if err != nil {
+		return File{}, err
+	}
+	if c.StatePath != "" {
+		db, err := c.uploadStateDB()
+		if err == nil {
+			return c.statefulLargeFileUpload(ctx, db, bucketID, filePath)
 		}
-		fmt.Printf("\nRequest: %v\n", string(requestDump))
-		logger.Fatal("Could not upload file",
-			zap.String("API Resp Body", string(respBody)),
+		// Another upload, such as a second gb2 process, holds the database, continue without
+		// saving progress, an interrupted upload is still found by resumeIfUnfinished
+		c.Logger.Warn("Could not open upload state database, progress is not saved",
+			zap.String("State Path", c.StatePath),
+			zap.Error(err),
 		)
-		responseDump, err := httputil.DumpResponse(resp, true)
-		if err != nil {
-			logger.Warn("Could not dump HTTP response",
-				zap.Error(err),
-			)
-		}
-		fmt.Printf("\nResponse: %v\n", string(responseDump))
-
 	}
-
-}
-
-func LargeFileUpload(bucketID string, filePath string) {
 	// Open File and Get File Stats
 	file, err := os.Open(filePath)
+	if err != nil {
+		return File{}, err
+	}
 	defer file.Close()
-	// TODO: Check file open error
 	fileInfo, err := file.Stat()
-	// TODO: Check file stat error
-
-	// Send start request to API and check response
-	startResp, b2File := B2StartLargeFile(bucketID, filePath)
-	if startResp.Status != "200 OK" {
-		logger.Warn("Invalid response to start large file request",
-			zap.String("Response", string(startResp.Body)),
-		)
+	if err != nil {
+		return File{}, err
 	}
-	var largeFile LargeFile
-	largeFile.Name = fileInfo.Name()
-	largeFile.OrigPath = filePath
-	largeFile.LastModificationMillis = b2File.FileInfo.LastModificationMillis
-	largeFile.FileID = b2File.FileID
-	largeFile.Size = fileInfo.Size()
-	sha1, err := fileSHA1(filePath)
 
-	// TODO: Check SHA1 error
-	largeFile.SHA1 = sha1
+	largeFileSHA1, err := fileSHA1(filePath)
+	if err != nil {
+		return File{}, err
+	}
+	// Continue an interrupted upload of the same file instead of starting over
+	if finished, found, err := c.resumeIfUnfinished(ctx, bucketID, filePath, largeFileSHA1); found {
+		return finished, err
+	}
 
-	largeFile, err = createTempFiles(largeFile)
+	pieces := newPieces(fileInfo.Size(), largeFilePartSize)
+	if len(pieces) > 10000 {
+		return File{}, errors.New("File cannot be split into more than 10000 pieces")
+	}
+	// Send start request to API and check response
+	started, err := c.startLargeFile(ctx, bucketID, fileInfo, largeFileSHA1)
 	if err != nil {
-		logger.Fatal("Invalid response from create temp files operation.",
-			zap.Error(err),
-		)
+		return started, err
 	}
-	// Do simultaneous multipart upload
-	logger.Info("Beginning Multipart Upload",
-		zap.String("B2 File ID", largeFile.FileID),
-		zap.Int64("Size", largeFile.Size),
-		zap.Int("Pieces", largeFile.Pieces),
+	// Do simultaneous multipart upload, every part read from the file and hashed as it is sent
+	c.Logger.Info("Beginning Multipart Upload",
+		zap.String("B2 File ID", started.FileID),
+		zap.Int64("Size", fileInfo.Size()),
+		zap.Int("Pieces", len(pieces)),
 	)
-	uploadParts(largeFile)
-	if err != nil {
-		logger.Fatal("Upload Parts of Large File failed",
-			zap.Error(err),
-		)
+	if err := c.uploadMissingPieces(ctx, started.FileID, file, largeFilePartSize, pieces, nil); err != nil {
+		return started, err
 	}
-	err = B2FinishLargeFile(largeFile)
-
+	partSha1Array := make([]string, len(pieces))
+	for i := range pieces {
+		partSha1Array[i] = pieces[i].SHA1
+	}
+	finished, err := c.finishLargeFile(ctx, started.FileID, partSha1Array)
 	if err != nil {
-		logger.Warn("Could not complete large file",
+		c.Logger.Warn("Could not complete large file",
 			zap.Error(err),
 		)
+		return started, err
 	}
-	if err == nil {
-		removeTempFiles(largeFile)
-	}
-
-	return
+	return finished, nil
 }
 
-// Begin Large File Upload
-func B2StartLargeFile(bucketID string, filePath string) (Response, B2File) {
-	// Authorize
-	apiAuth := AuthorizeAcct()
+// StartLargeFile begins Large File Upload
+func (c *Client) StartLargeFile(bucketID string, filePath string) (File, error) {
+	return c.StartLargeFileContext(context.Background(), bucketID, filePath)
+}
 
+// StartLargeFileContext is like StartLargeFile with a context limiting the request
+func (c *Client) StartLargeFileContext(ctx context.Context, bucketID string, filePath string) (File, error) {
+	var b2File File
+	bucketID, err := c.ResolveBucketContext(ctx, bucketID)
+	if err != nil {
+		return b2File, err
+	}
 	// Open File and Get File Stats
 	file, err := os.Open(filePath)
-	defer file.Close()
 	if err != nil {
-		log.Fatalf("Unable to open file. Error: %v", err)
+		return b2File, err
 	}
+	defer file.Close()
 	fileInfo, err := file.Stat()
 	if err != nil {
-		log.Fatalf("Unable to get file stats. Error: %v", err)
+		return b2File, err
 	}
-	// Get File Modification Time as int64 value in milliseconds since midnight, January 1, 1970 UTC
-	fileModTimeMillis := fileInfo.ModTime().UnixNano() / 1000000
 	// Get File sha1
 	largeFileSHA1, err := fileSHA1(filePath)
-	if largeFileSHA1 == "fail" {
-		logger.Fatal("Cannot parse API Auth Response JSON.")
-	}
-
-	// Create client
-	client := &http.Client{}
-	// Request Body : JSON object
-	jsonBody := []byte(`{"fileInfo": {"large_file_sha1": "` + largeFileSHA1 + `","src_last_modified_millis": "` + fmt.Sprintf("%d", fileModTimeMillis) + `"},"bucketId": "` + bucketID + `","fileName": "` + fileInfo.Name() + `","contentType": "b2/x-auto"}`)
-	body := bytes.NewBuffer(jsonBody)
-
-	// Create request
-	req, err := http.NewRequest("POST", "https://api001.backblazeb2.com/b2api/v1/b2_start_large_file", body)
-
-	// Headers
-	req.Header.Add("Authorization", apiAuth.AuthorizationToken)
-
-	// Fetch Request
-	resp, err := client.Do(req)
-
 	if err != nil {
-		fmt.Println("Failure : ", err)
+		return b2File, err
 	}
+	return c.startLargeFile(ctx, bucketID, fileInfo, largeFileSHA1)
+}
 
-	// Read Response Body
-	respBody, _ := ioutil.ReadAll(resp.Body)
-	defer resp.Body.Close()
-	var apiResponse Response
-	apiResponse = Response{Header: resp.Header, Status: resp.Status, Body: respBody}
+// startLargeFile starts a large file named after the local file described by fileInfo, whose
+// SHA1 is largeFileSHA1
+func (c *Client) startLargeFile(ctx context.Context, bucketID string, fileInfo os.FileInfo, largeFileSHA1 string) (File, error) {
+	var b2File File
+	// Get File Modification Time as int64 value in milliseconds since midnight, January 1, 1970 UTC
+	fileModTimeMillis := fileInfo.ModTime().UnixNano() / 1000000
 
-	// Parse API Response File Info to B2File if request is successful
-	var b2File B2File
-	if apiResponse.Status == "200 OK" {
-		err = json.Unmarshal(apiResponse.Body, &b2File)
-		if err != nil {
-			logger.Fatal("File JSON Parse Failed",
-				zap.Error(err),
-			)
-		}
-		return apiResponse, b2File
+	// Request Body : JSON object
+	reqBody := map[string]interface{}{
+		"fileInfo": map[string]string{
+			"large_file_sha1":          largeFileSHA1,
+			"src_last_modified_millis": fmt.Sprintf("%d", fileModTimeMillis),
+		},
+		"bucketId":    bucketID,
+		"fileName":    fileInfo.Name(),
+		"contentType": "b2/x-auto",
 	}
 
-	return apiResponse, b2File
-}
-func uploadParts(largeFile LargeFile) {
-	var wg sync.WaitGroup
-	logger.Info("Uploading File Part",
-		zap.String("File", largeFile.Name),
-	)
-	pbpool, err := pb.StartPool()
+	// Parse API Response File Info to File if request is successful
+	err := c.apiCall(ctx, "b2_start_large_file", reqBody, &b2File)
 	if err != nil {
-		logger.Fatal("Could not start Progress Bar pool")
-	}
-	for i := 0; i < len(largeFile.Temp); i++ {
-		wg.Add(1)
-		go UploadPart(largeFile, i, &wg, pbpool)
+		c.Logger.Warn("Invalid response to start large file request",
+			zap.Error(err),
+		)
 	}
-	wg.Wait()
-	pbpool.Stop()
-
-	return
+	return b2File, err
 }
-func UploadPart(largeFile LargeFile, pieceNum int, wg *sync.WaitGroup, pbpool *pb.Pool) {
-	defer wg.Done()
-	logger.Info("Starting Upload of Part",
-		zap.Int("B2 Part #", pieceNum+1),
-		zap.String("Piece Path", largeFile.Temp[pieceNum].Path),
-		zap.String("Upload URL", largeFile.Temp[pieceNum].URL),
-	)
-	file, err := os.Open(largeFile.Temp[pieceNum].Path)
-	if err != nil {
-		log.Fatalf("Unable to open file. Error: %v", err)
-	}
-	defer file.Close()
-	// Upload Part (POST https://pod-000-1004-12.backblaze.com/b2api/v1/b2_upload_part/....)
-	// Create client
-	client := &http.Client{}
-
-	// Progress Bar
-	pbar := pb.New64(largeFile.Temp[pieceNum].Size).SetUnits(pb.U_BYTES)
-	pbar.SetRefreshRate(time.Second)
-	pbar.Prefix(fmt.Sprintf("Part %v of %v", pieceNum+1, len(largeFile.Temp)))
-	pbar.ShowSpeed = true
-	pbar.ShowTimeLeft = true
-
-	pbpool.Add(pbar)
-
-	// Create request
-	req, err := http.NewRequest("POST", largeFile.Temp[pieceNum].URL, pbar.NewProxyReader(file))
 
-	// Headers
-	req.ContentLength = largeFile.Temp[pieceNum].Size
-	req.Header.Add("X-Bz-Part-Number", fmt.Sprintf("%d", (pieceNum+1))) // Temp files begin at 0, increase by 1 to match B2 response
-	req.Header.Add("Authorization", largeFile.Temp[pieceNum].AuthorizationToken)
-	req.Header.Add("X-Bz-Content-Sha1", largeFile.Temp[pieceNum].SHA1)
-
-	pbar.Start()
-
-	// Fetch Request
-	resp, err := client.Do(req)
-
-	if err != nil {
-		fmt.Println("Failure : ", err)
+// uploadURLTarget returns a function requesting a new upload URL for bucketID
+func (c *Client) uploadURLTarget(ctx context.Context, bucketID string) func() (uploadTarget, error) {
+	return func() (uploadTarget, error) {
+		uploadURL, err := c.GetUploadURLContext(ctx, bucketID)
+		return uploadTarget{URL: uploadURL.URL, AuthorizationToken: uploadURL.AuthorizationToken}, err
 	}
-	pbar.Finish()
-
-	// Read Response Body
-	respBody, _ := ioutil.ReadAll(resp.Body)
+}
 
-	var apiResponse Response
-	apiResponse.Header = resp.Header
-	apiResponse.Status = resp.Status
-	apiResponse.Body = respBody
-	if apiResponse.Status == "200 OK" {
-		logger.Info("Part Uploaded Successfully",
-			zap.String("Filename", largeFile.Name),
-			zap.Int("B2 Piece Num", int(pieceNum+1)),
-			zap.String("Piece Path", largeFile.Temp[pieceNum].Path),
-			zap.String("Response Body", string(apiResponse.Body)),
-		)
-		largeFile.Temp[pieceNum].UploadStatus = "Success"
+// uploadPartURLTarget returns a function requesting a new upload part URL for the large file fileID
+func (c *Client) uploadPartURLTarget(ctx context.Context, fileID string) func() (uploadTarget, error) {
+	return func() (uploadTarget, error) {
+		uploadPartURL, err := c.GetUploadPartURLContext(ctx, fileID)
+		return uploadTarget{URL: uploadPartURL.UploadURL, AuthorizationToken: uploadPartURL.AuthorizationToken}, err
 	}
-	if apiResponse.Status != "200 OK" {
-		logger.Warn("Part Upload Failed",
-			zap.String("Filename", largeFile.Name),
-			zap.Int("B2 Piece Num", int(pieceNum+1)),
-			zap.String("Piece Path", largeFile.Temp[pieceNum].Path),
-			zap.String("Response Body", string(apiResponse.Body)),
+}
+
+// startProgressPool starts a pool for the progress bars of concurrent parts, or returns nil when
+// that is not possible, such as without a terminal, in which case bars are printed on their own
+func (c *Client) startProgressPool() *pb.Pool {
+	pbpool, err := pb.StartPool()
+	if err != nil {
+		c.Logger.Debug("Could not start progress bar pool",
+			zap.Error(err),
 		)
-		largeFile.Temp[pieceNum].UploadStatus = "Failed"
+		return nil
 	}
-
-	return
+	return pbpool
 }